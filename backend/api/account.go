@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/mail"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Shortest password registration and reset accept
+	minPasswordLength = 6
+	// Error code for a token that is unknown, expired or already used
+	authErrInvalidToken = "invalid_token"
+)
+
+func (s *Server) registerAccountRoutes(app *fiber.App) {
+	// Tokens cannot be guessed, but each attempt still costs a lookup
+	perIP := func(policy string) fiber.Handler {
+		return s.rateLimit(ratelimit.Rule{Policy: policy, Limit: s.cfg.RateLimits.LoginPerIP, Key: ratelimit.ByIP})
+	}
+	app.Post("/api/auth/verify", perIP("verify_email"), s.verifyEmail)
+	app.Post("/api/auth/password/forgot", perIP("password_forgot"), s.forgotPassword)
+	app.Post("/api/auth/password/reset", perIP("password_reset"), s.resetPassword)
+}
+
+// @Summary Verify email
+// @Description Confirms the user's email with the token from the link mailed at registration. Each token works once.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.VerifyEmailRequest true "Token from the email"
+// @Success 200 {object} models.MessageResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/auth/verify [post]
+func (s *Server) verifyEmail(c *fiber.Ctx) error {
+	var body models.VerifyEmailRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid request"})
+	}
+	ctx := c.UserContext()
+	now := time.Now()
+	token, err := s.tokens.Use(ctx, hashToken(body.Token), models.TokenVerifyEmail, now)
+	if err != nil {
+		return tokenErrorResponse(c, err)
+	}
+	if err := s.users.MarkEmailVerified(ctx, token.UserID, now); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to verify email"})
+	}
+	return c.JSON(models.MessageResponse{Message: "Email verified"})
+}
+
+// @Summary Request a password reset
+// @Description Mails a single-use password reset link to the address if it belongs to an account. The response is the same either way, so it does not tell which addresses are registered.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.ForgotPasswordRequest true "Account email"
+// @Success 202 {object} models.MessageResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Router /api/auth/password/forgot [post]
+func (s *Server) forgotPassword(c *fiber.Ctx) error {
+	var body models.ForgotPasswordRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid request"})
+	}
+	email, ok := validEmail(body.Email)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "A valid email address is required"})
+	}
+	// The lookup and the mail happen after responding, so the response time
+	// does not tell either
+	ctx := context.WithoutCancel(c.UserContext())
+	s.goAsync(func() {
+		user, err := s.users.ByUsernameOrEmail(ctx, email)
+		if err != nil {
+			if !errors.Is(err, repository.ErrNotFound) {
+				slog.ErrorContext(ctx, "Failed to look up password reset email", "error", err)
+			}
+			return
+		}
+		s.sendPasswordResetEmail(ctx, user)
+	})
+	return c.Status(fiber.StatusAccepted).JSON(models.MessageResponse{
+		Message: "If the address belongs to an account, a reset link has been sent to it",
+	})
+}
+
+// @Summary Reset password
+// @Description Sets a new password with the token from a password reset email. The token works once, and any other reset links of the account stop working. Failed logins and lockouts are cleared and the email counts as verified.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body models.ResetPasswordRequest true "Token from the email and the new password"
+// @Success 200 {object} models.MessageResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/auth/password/reset [post]
+func (s *Server) resetPassword(c *fiber.Ctx) error {
+	var body models.ResetPasswordRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid request"})
+	}
+	if len(body.Password) < minPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Password must be at least 6 characters"})
+	}
+	ctx := c.UserContext()
+	now := time.Now()
+	token, err := s.tokens.Use(ctx, hashToken(body.Token), models.TokenResetPassword, now)
+	if err != nil {
+		return tokenErrorResponse(c, err)
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to hash password"})
+	}
+	if err := s.users.SetPassword(ctx, token.UserID, string(hashed)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to reset password"})
+	}
+	// The reset proves the user reads the mailbox
+	user, err := s.users.ByID(ctx, token.UserID)
+	if err == nil {
+		err = s.resetFailedLogins(ctx, user)
+	}
+	err = errors.Join(
+		err,
+		s.users.MarkEmailVerified(ctx, token.UserID, now),
+		s.tokens.DeleteForUser(ctx, token.UserID, models.TokenResetPassword),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to finish password reset", "user_id", token.UserID, "error", err)
+	}
+	slog.InfoContext(ctx, "Password reset", "event", "security.password_reset", "user_id", token.UserID, "ip", c.IP())
+	return c.JSON(models.MessageResponse{Message: "Password updated"})
+}
+
+func tokenErrorResponse(c *fiber.Ctx, err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: "The link is invalid, expired or already used",
+			Code:  authErrInvalidToken,
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to check token"})
+}
+
+// validEmail returns address in stored form if it is a bare email address
+func validEmail(address string) (string, bool) {
+	address = models.NormalizeLogin(address)
+	parsed, err := netmail.ParseAddress(address)
+	if err != nil || parsed.Address != address {
+		return "", false
+	}
+	return address, true
+}
+
+// sendVerificationEmail mails user a link to confirm their address
+func (s *Server) sendVerificationEmail(ctx context.Context, user *models.User) {
+	token, err := s.issueToken(ctx, user.ID, models.TokenVerifyEmail, s.cfg.EmailVerifyTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create email verification token", "user_id", user.ID, "error", err)
+		return
+	}
+	s.sendMail(ctx, user, mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Confirm your email address by opening this link:\n\n" +
+			s.appLink("/verify-email", token) + "\n\n" +
+			"The link expires in " + s.cfg.EmailVerifyTTL.String() + ".\n",
+	})
+}
+
+// sendPasswordResetEmail mails user a link to choose a new password,
+// replacing any link sent before
+func (s *Server) sendPasswordResetEmail(ctx context.Context, user *models.User) {
+	if err := s.tokens.DeleteForUser(ctx, user.ID, models.TokenResetPassword); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete old password reset tokens", "user_id", user.ID, "error", err)
+		return
+	}
+	token, err := s.issueToken(ctx, user.ID, models.TokenResetPassword, s.cfg.PasswordResetTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create password reset token", "user_id", user.ID, "error", err)
+		return
+	}
+	s.sendMail(ctx, user, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Someone asked to reset the password of your account. Choose a new one here:\n\n" +
+			s.appLink("/reset-password", token) + "\n\n" +
+			"The link expires in " + s.cfg.PasswordResetTTL.String() + ". If you did not ask for it, ignore this email.\n",
+	})
+}
+
+func (s *Server) sendMail(ctx context.Context, user *models.User, msg mail.Message) {
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send mail", "user_id", user.ID, "subject", msg.Subject, "error", err)
+	}
+}
+
+// appLink returns the web app URL of path carrying token
+func (s *Server) appLink(path, token string) string {
+	return s.cfg.AppURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// issueToken stores a new token for purpose and returns it. Only the email
+// carries the token itself.
+func (s *Server) issueToken(ctx context.Context, userID uint, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	err := s.tokens.Create(ctx, &models.AuthToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Hash:      hashToken(token),
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	return token, err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,25 +1,53 @@
 package api
 
 import (
-	"log"
-	"os"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
 	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // RegisterAuthRoutes registers authentication routes
-func RegisterAuthRoutes(app *fiber.App) {
-	app.Post("/api/auth/register", register)
-	app.Post("/api/auth/login", login)
+func (s *Server) RegisterAuthRoutes(app *fiber.App) {
+	app.Post("/api/auth/register", s.register)
+	app.Post("/api/auth/login", s.rateLimit(
+		ratelimit.Rule{Policy: "login_ip", Limit: s.cfg.RateLimits.LoginPerIP, Key: ratelimit.ByIP},
+		ratelimit.Rule{Policy: "login_username", Limit: s.cfg.RateLimits.LoginPerUsername, Key: loginKey},
+	), s.login)
+	s.registerAccountRoutes(app)
+}
+
+// loginIdentifier returns the username or email a login names
+func loginIdentifier(body models.AuthRequest) string {
+	if body.Username != "" {
+		return body.Username
+	}
+	return body.Email
+}
+
+// loginKey counts login attempts per account, whichever address they come
+// from
+func loginKey(c *fiber.Ctx) string {
+	var body models.AuthRequest
+	if err := c.BodyParser(&body); err != nil {
+		return ""
+	}
+	return models.NormalizeLogin(loginIdentifier(body))
 }
 
 // @Summary Register a new user
-// @Description Register a new user with username and password
+// @Description Register a new user with username, email and a password of at least 6 characters. A link to verify the email is mailed to it. Usernames and emails are stored lower-cased and must be unique regardless of case; usernames may not contain @.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -28,15 +56,20 @@ func RegisterAuthRoutes(app *fiber.App) {
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/auth/register [post]
-func register(c *fiber.Ctx) error {
+func (s *Server) register(c *fiber.Ctx) error {
 	var body models.AuthRequest
 	if err := c.BodyParser(&body); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
-	// Use email as username if email is not provided
-	email := body.Email
-	if email == "" {
-		email = body.Username + "@example.com"
+	if strings.TrimSpace(body.Username) == "" || strings.Contains(body.Username, "@") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A username without @ is required"})
+	}
+	email, ok := validEmail(body.Email)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "A valid email address is required"})
+	}
+	if len(body.Password) < minPasswordLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Password must be at least 6 characters"})
 	}
 
 	hashed, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
@@ -44,14 +77,16 @@ func register(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to hash password"})
 	}
 	user := models.User{Username: body.Username, Email: email, Password: string(hashed)}
-	if err := db.DB.Create(&user).Error; err != nil {
+	if err := s.users.Create(c.UserContext(), &user); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
+	ctx := context.WithoutCancel(c.UserContext())
+	s.goAsync(func() { s.sendVerificationEmail(ctx, &user) })
 	return c.JSON(models.RegisterResponse{Message: "User registered successfully"})
 }
 
 // @Summary Login
-// @Description Login with username and password to get a JWT token
+// @Description Login with a username or email, in any case, and password to get a JWT token. The identifier may be sent as username or as email. Attempts are rate limited per address and per username. Repeated failures with one username or email make it wait before the next attempt, growing into a temporary lockout, whether or not an account has it. An account's username and email share one count; while it waits logins answer 429 with Retry-After.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -59,34 +94,139 @@ func register(c *fiber.Ctx) error {
 // @Success 200 {object} models.LoginResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/auth/login [post]
-func login(c *fiber.Ctx) error {
+func (s *Server) login(c *fiber.Ctx) error {
 	var body models.AuthRequest
 	if err := c.BodyParser(&body); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
-	var user models.User
-	if err := db.DB.Where("username = ?", body.Username).First(&user).Error; err != nil {
+	ctx := c.UserContext()
+	identifier := loginIdentifier(body)
+	// Unknown identifiers are throttled like accounts, so a failed lookup
+	// only changes what the failures are counted under
+	user, err := s.users.ByUsernameOrEmail(ctx, identifier)
+	if err != nil {
+		user = nil
+	}
+	hashes := loginHashes(identifier, user)
+	now := time.Now()
+	failed := false
+	var lockedUntil time.Time
+	for _, hash := range hashes {
+		failure, err := s.loginFailures.ByHash(ctx, hash)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check failed logins", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to log in"})
+		}
+		failed = true
+		if failure.LockedUntil != nil && failure.LockedUntil.After(lockedUntil) {
+			lockedUntil = *failure.LockedUntil
+		}
+	}
+	if now.Before(lockedUntil) {
+		c.Set(fiber.HeaderRetryAfter, retryAfter(lockedUntil.Sub(now)))
+		return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse{
+			Error: "Too many failed logins, try again later",
+			Code:  loginErrLocked,
+		})
+	}
+	if user == nil {
+		// Take as long as a wrong password and count the failure the same
+		// way, so neither tells which usernames exist
+		utils.CheckPassword("", body.Password)
+		s.recordFailedLogin(c, hashes, now)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	if !utils.CheckPassword(user.Password, body.Password) {
+		s.recordFailedLogin(c, hashes, now)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
 	}
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Println("JWT_SECRET not set")
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Server error"})
+	if failed {
+		if err := s.resetFailedLogins(ctx, user); err != nil {
+			slog.ErrorContext(ctx, "Failed to reset failed logins", "user_id", user.ID, "error", err)
+		}
 	}
 	claims := jwt.MapClaims{
 		"sub":      user.ID,
 		"username": user.Username,
-		"exp":      time.Now().Add(time.Hour * 72).Unix(),
+		"exp":      time.Now().Add(s.cfg.TokenTTL).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(secret))
+	signed, err := token.SignedString([]byte(s.cfg.JWTSecret))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
 	}
 	return c.JSON(models.LoginResponse{Token: signed})
 }
+
+// Error code of a login refused because the account has to wait
+const loginErrLocked = "login_locked"
+
+// loginHash is what failed logins with identifier are counted under
+func loginHash(identifier string) string {
+	return hashToken(models.NormalizeLogin(identifier))
+}
+
+// loginHashes are what a failed login with identifier counts against. Failures
+// with an account's username and with its email count against both, so the
+// account gets one budget of guesses whichever it is reached by.
+func loginHashes(identifier string, user *models.User) []string {
+	if user == nil {
+		return []string{loginHash(identifier)}
+	}
+	return []string{loginHash(user.Username), loginHash(user.Email)}
+}
+
+// recordFailedLogin counts a failed login against each of hashes and makes
+// them wait as the login policy says for the highest count, logging a lockout
+// as a security event. Identifiers without an account are treated the same.
+func (s *Server) recordFailedLogin(c *fiber.Ctx, hashes []string, now time.Time) {
+	ctx := c.UserContext()
+	policy := s.cfg.Login
+	failures := 0
+	for _, hash := range hashes {
+		count, err := s.loginFailures.Record(ctx, hash, now, policy.LockoutDuration)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record failed login", "error", err)
+			return
+		}
+		failures = max(failures, count)
+	}
+	wait, lockout := policy.Wait(failures)
+	if wait <= 0 {
+		return
+	}
+	until := now.Add(wait)
+	for _, hash := range hashes {
+		if err := s.loginFailures.LockUntil(ctx, hash, until); err != nil {
+			slog.ErrorContext(ctx, "Failed to delay logins", "error", err)
+			return
+		}
+	}
+	c.Set(fiber.HeaderRetryAfter, retryAfter(wait))
+	if lockout {
+		metrics.AccountLockouts.Inc()
+		slog.WarnContext(ctx, "Login locked after failed logins",
+			"event", "security.account_locked",
+			"login_hashes", hashes,
+			"ip", c.IP(),
+			"failures", failures,
+			"locked_until", until)
+	}
+}
+
+// resetFailedLogins clears the failures and any lock of the user's username
+// and email
+func (s *Server) resetFailedLogins(ctx context.Context, user *models.User) error {
+	return s.loginFailures.Reset(ctx, loginHash(user.Username), loginHash(user.Email))
+}
+
+// retryAfter formats wait as whole seconds, rounded up
+func retryAfter(wait time.Duration) string {
+	return strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/middleware"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+func (s *Server) registerModerationRoutes(app *fiber.App) {
+	moderation := app.Group("/api/moderation", middleware.JWTMiddleware(s.cfg.JWTSecret), middleware.ModeratorMiddleware(s.users))
+	moderation.Get("/banned-media", s.ListBannedMedia)
+	moderation.Post("/banned-media", s.BanMedia)
+	moderation.Delete("/banned-media/:id", s.UnbanMedia)
+}
+
+// ListBannedMedia handles GET /api/moderation/banned-media
+// @Summary List banned media
+// @Description Lists the perceptual hashes of banned images, newest first. Moderators only.
+// @Tags moderation
+// @Produce json
+// @Success 200 {array} models.BannedMedia
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/moderation/banned-media [get]
+func (s *Server) ListBannedMedia(c *fiber.Ctx) error {
+	banned, err := s.bans.List(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to list banned media"})
+	}
+	for i := range banned {
+		fillBannedHex(&banned[i])
+	}
+	return c.JSON(banned)
+}
+
+// BanMedia handles POST /api/moderation/banned-media
+// @Summary Ban media
+// @Description Bans an image by the URL of an upload or by hex-encoded pHash and dHash. Uploads and posts whose image is within BANNED_HASH_DISTANCE bits of both hashes are rejected from then on, and existing posts using a matching image are flagged. Moderators only.
+// @Tags moderation
+// @Accept json
+// @Produce json
+// @Param ban body models.BanMediaRequest true "Image to ban"
+// @Success 201 {object} models.BannedMedia
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/moderation/banned-media [post]
+func (s *Server) BanMedia(c *fiber.Ctx) error {
+	var req models.BanMediaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid request body"})
+	}
+
+	ctx := c.UserContext()
+	var phash, dhash uint64
+	var key string
+	var err error
+	switch {
+	case req.MediaURL != "":
+		key, phash, dhash, err = s.hashesForURL(ctx, req.MediaURL)
+	case req.PHash != "" && req.DHash != "":
+		if phash, err = strconv.ParseUint(req.PHash, 16, 64); err == nil {
+			dhash, err = strconv.ParseUint(req.DHash, 16, 64)
+		}
+		if err != nil {
+			err = fmt.Errorf("Hashes must be 64-bit hex numbers")
+		}
+	default:
+		err = fmt.Errorf("media_url or both phash and dhash are required")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+
+	userID, _ := c.Locals("user_id").(int64)
+	banned := models.BannedMedia{
+		PHash:     int64(phash),
+		DHash:     int64(dhash),
+		Reason:    req.Reason,
+		CreatedBy: uint(userID),
+	}
+	if err := s.bans.Create(ctx, &banned); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to ban media"})
+	}
+	err = s.flagBannedMedia(ctx, &banned)
+	if err == nil && key != "" {
+		// Uploads from before content addressing have no Media row to match
+		err = s.posts.FlagUsing(ctx, []string{key})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to flag matching posts"})
+	}
+	fillBannedHex(&banned)
+	return c.Status(fiber.StatusCreated).JSON(banned)
+}
+
+// UnbanMedia handles DELETE /api/moderation/banned-media/:id
+// @Summary Unban media
+// @Description Removes an entry from the banned list. Posts it flagged stay flagged. Moderators only.
+// @Tags moderation
+// @Param id path int true "Banned media ID"
+// @Success 204
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/moderation/banned-media/{id} [delete]
+func (s *Server) UnbanMedia(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Invalid banned media ID"})
+	}
+	err = s.bans.Delete(c.UserContext(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Banned media not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to unban media"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// fillBannedHex sets the hex forms of a ban's hashes
+func fillBannedHex(banned *models.BannedMedia) {
+	banned.PHashHex = fmt.Sprintf("%016x", uint64(banned.PHash))
+	banned.DHashHex = fmt.Sprintf("%016x", uint64(banned.DHash))
+}
+
+// hashesForURL returns the storage key and perceptual hashes of the image
+// behind a media URL, computing them from the stored file for uploads that
+// predate hashing
+func (s *Server) hashesForURL(ctx context.Context, mediaURL string) (string, uint64, uint64, error) {
+	key, ok := s.mediaKeyForURL(mediaURL)
+	if !ok {
+		return "", 0, 0, fmt.Errorf("media_url does not point at an uploaded file")
+	}
+	media, err := s.mediaByKey(ctx, key)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if media != nil && media.MediaType != models.MediaTypeImage {
+		return "", 0, 0, fmt.Errorf("Only images can be banned; ban a video's poster instead")
+	}
+	if media != nil && hasPerceptualHashes(media) {
+		return key, uint64(media.PHash), uint64(media.DHash), nil
+	}
+
+	keys, err := storage.RenditionKeys(ctx, s.store, key)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("media_url does not point at an uploaded file")
+	}
+	rc, err := s.store.Open(ctx, keys.Full)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("media_url does not point at an uploaded file")
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	phash, dhash, err := utils.PerceptualHashes(data)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("media_url does not point at an image")
+	}
+	if media != nil {
+		if err := s.media.SetHashes(ctx, media.ID, int64(phash), int64(dhash)); err != nil {
+			return "", 0, 0, err
+		}
+	}
+	return key, phash, dhash, nil
+}
+
+// hasPerceptualHashes reports whether hashes were computed for media
+func hasPerceptualHashes(media *models.Media) bool {
+	return media.PHash != 0 || media.DHash != 0
+}
+
+// bannedHashMatch reports whether an image's hashes are within the configured
+// distance of a ban. Both hashes must match, which keeps false positives rare.
+func (s *Server) bannedHashMatch(banned *models.BannedMedia, phash, dhash uint64) bool {
+	distance := s.cfg.BannedHashDistance
+	return utils.HammingDistance(uint64(banned.PHash), phash) <= distance &&
+		utils.HammingDistance(uint64(banned.DHash), dhash) <= distance
+}
+
+// matchBannedMedia returns the ban an image's hashes match, if any. Callers
+// must reject the image when the bans cannot be read.
+func (s *Server) matchBannedMedia(ctx context.Context, phash, dhash uint64) (*models.BannedMedia, error) {
+	banned, err := s.bans.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load banned media: %w", err)
+	}
+	for i := range banned {
+		if s.bannedHashMatch(&banned[i], phash, dhash) {
+			return &banned[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// bannedMediaError rejects content matching a banned image
+func bannedMediaError() *utils.MediaError {
+	return &utils.MediaError{Code: utils.MediaErrBanned, Message: "This image matches content removed by moderators"}
+}
+
+// checkBannedMedia rejects stored media whose image matches a ban
+func (s *Server) checkBannedMedia(ctx context.Context, media *models.Media) error {
+	if media == nil || !hasPerceptualHashes(media) {
+		return nil
+	}
+	match, err := s.matchBannedMedia(ctx, uint64(media.PHash), uint64(media.DHash))
+	if err != nil {
+		return err
+	}
+	if match != nil {
+		return bannedMediaError()
+	}
+	return nil
+}
+
+// checkBannedItem rejects a carousel item whose image or poster was uploaded
+// before a matching image was banned
+func (s *Server) checkBannedItem(ctx context.Context, item *models.PostMedia) error {
+	for _, key := range []string{item.MediaKey, item.PosterKey} {
+		media, err := s.mediaByKey(ctx, key)
+		if err != nil {
+			return err
+		}
+		if err := s.checkBannedMedia(ctx, media); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flagBannedMedia marks every stored image matching a new ban as flagged, and
+// with it the posts and carousel items that use it
+func (s *Server) flagBannedMedia(ctx context.Context, banned *models.BannedMedia) error {
+	hashed, err := s.media.HashedImages(ctx)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	var ids []uint
+	for _, media := range hashed {
+		if s.bannedHashMatch(banned, uint64(media.PHash), uint64(media.DHash)) {
+			keys = append(keys, media.Key)
+			ids = append(ids, media.ID)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := s.media.Flag(ctx, ids); err != nil {
+		return err
+	}
+	return s.posts.FlagUsing(ctx, keys)
+}
@@ -1,28 +1,28 @@
 package api
 
 import (
-	"fmt"
+	"errors"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/middleware"
 	"github.com/umutdeveloper/instagram-light/backend/models"
-	"github.com/umutdeveloper/instagram-light/backend/utils"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
 )
 
 // RegisterCommentRoutes registers comment-related routes under posts
-func RegisterCommentRoutes(app *fiber.App) {
-	comments := app.Group("/api/posts/:post_id/comments", middleware.JWTMiddleware())
-	comments.Get("/", GetComments)
-	comments.Post("/", CreateComment)
-	comments.Delete(":comment_id", DeleteComment)
+func (s *Server) RegisterCommentRoutes(app *fiber.App) {
+	comments := app.Group("/api/posts/:post_id/comments", middleware.JWTMiddleware(s.cfg.JWTSecret))
+	comments.Get("/", s.GetComments)
+	comments.Post("/", s.rateLimit(ratelimit.Rule{Policy: "comment", Limit: s.cfg.RateLimits.Comments, Key: ratelimit.ByUser}), s.CreateComment)
+	comments.Delete(":comment_id", s.DeleteComment)
 }
 
 // DeleteComment handles DELETE /api/posts/:post_id/comments/:comment_id
 // @Summary Delete a comment
-// @Description Delete a comment by its ID (only by the comment owner)
+// @Description Delete a comment by its ID (only by the comment owner). Deleted comments are kept for TRASH_RETENTION before being purged.
 // @Tags comments
 // @Param post_id path int true "Post ID"
 // @Param comment_id path int true "Comment ID"
@@ -34,12 +34,12 @@ func RegisterCommentRoutes(app *fiber.App) {
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/posts/{post_id}/comments/{comment_id} [delete]
-func DeleteComment(c *fiber.Ctx) error {
-	postID, err := strconv.ParseInt(c.Params("post_id"), 10, 64)
+func (s *Server) DeleteComment(c *fiber.Ctx) error {
+	postID, err := strconv.ParseUint(c.Params("post_id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid post_id"})
 	}
-	commentID, err := strconv.ParseInt(c.Params("comment_id"), 10, 64)
+	commentID, err := strconv.ParseUint(c.Params("comment_id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid comment_id"})
 	}
@@ -48,15 +48,14 @@ func DeleteComment(c *fiber.Ctx) error {
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 	}
-	var comment models.Comment
-	err = db.DB.Where("id = ? AND post_id = ?", commentID, postID).First(&comment).Error
+	comment, err := s.comments.ByID(c.UserContext(), uint(postID), uint(commentID))
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Comment not found"})
 	}
-	if comment.UserID != userID {
+	if comment.UserID != uint(userID) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You can only delete your own comment"})
 	}
-	if err := db.DB.Delete(&comment).Error; err != nil {
+	if err := s.comments.Delete(c.UserContext(), comment); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete comment"})
 	}
 	return c.SendStatus(fiber.StatusNoContent)
@@ -74,11 +73,13 @@ func DeleteComment(c *fiber.Ctx) error {
 // @Success 201 {object} models.Comment
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/posts/{post_id}/comments [post]
-func CreateComment(c *fiber.Ctx) error {
-	postID, err := strconv.ParseInt(c.Params("post_id"), 10, 64)
+func (s *Server) CreateComment(c *fiber.Ctx) error {
+	postID, err := strconv.ParseUint(c.Params("post_id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid post_id"})
 	}
@@ -93,26 +94,29 @@ func CreateComment(c *fiber.Ctx) error {
 	if !ok {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 	}
+	// Trashed posts take no new comments
+	post, err := s.posts.ByIDWithTrashed(c.UserContext(), uint(postID))
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && post.DeletedAt.Valid) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create comment"})
+	}
 	comment := &models.Comment{
-		PostID:    postID,
-		UserID:    userID,
+		PostID:    uint(postID),
+		UserID:    uint(userID),
 		Text:      req.Text,
 		CreatedAt: time.Now(),
 	}
-	if err := db.DB.Create(comment).Error; err != nil {
+	if err := s.comments.Create(c.UserContext(), comment); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create comment"})
 	}
 
-	var postOwner models.Post
-	if err := db.DB.First(&postOwner, postID).Error; err == nil {
-		wsEvent := models.WSEvent{
-			Type:    "new_comment",
-			Payload: comment,
-		}
-		go func(userID uint, event models.WSEvent) {
-			_ = utils.WSManagerInstance.SendToUser(fmt.Sprintf("%d", userID), event)
-		}(postOwner.UserID, wsEvent)
+	wsEvent := models.WSEvent{
+		Type:    "new_comment",
+		Payload: comment,
 	}
+	ownerID, ctx := post.UserID, c.UserContext()
+	s.goAsync(func() { s.notifyUser(ctx, ownerID, wsEvent) })
 
 	return c.Status(fiber.StatusCreated).JSON(comment)
 }
@@ -129,13 +133,12 @@ func CreateComment(c *fiber.Ctx) error {
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/posts/{post_id}/comments [get]
-func GetComments(c *fiber.Ctx) error {
-	postID, err := strconv.ParseInt(c.Params("post_id"), 10, 64)
+func (s *Server) GetComments(c *fiber.Ctx) error {
+	postID, err := strconv.ParseUint(c.Params("post_id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid post_id"})
 	}
-	var comments []models.Comment
-	err = db.DB.Where("post_id = ?", postID).Order("created_at asc").Find(&comments).Error
+	comments, err := s.comments.ListByPost(c.UserContext(), uint(postID))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch comments"})
 	}
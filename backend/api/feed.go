@@ -4,15 +4,14 @@ import (
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/middleware"
 	"github.com/umutdeveloper/instagram-light/backend/models"
 )
 
 // RegisterFeedRoutes registers the feed route
-func RegisterFeedRoutes(app *fiber.App) {
-	feed := app.Group("/api/feed", middleware.JWTMiddleware())
-	feed.Get("/", GetFeed)
+func (s *Server) RegisterFeedRoutes(app *fiber.App) {
+	feed := app.Group("/api/feed", middleware.JWTMiddleware(s.cfg.JWTSecret))
+	feed.Get("/", s.GetFeed)
 }
 
 // GetFeed handles GET /api/feed
@@ -28,7 +27,7 @@ func RegisterFeedRoutes(app *fiber.App) {
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/feed [get]
-func GetFeed(c *fiber.Ctx) error {
+func (s *Server) GetFeed(c *fiber.Ctx) error {
 	userIDParam := c.Query("user_id")
 	if userIDParam == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id query parameter is required"})
@@ -54,31 +53,29 @@ func GetFeed(c *fiber.Ctx) error {
 	var posts []models.PostWithLikes
 
 	// Get followed user IDs
-	var followedIDs []uint
-	db.DB.Model(&models.Follow{}).Where("follower_id = ?", userID).Pluck("following_id", &followedIDs)
+	ctx := c.UserContext()
+	followedIDs, _ := s.follows.FollowingIDs(ctx, uint(userID))
 	followedIDs = append(followedIDs, uint(userID)) // include self
 
 	// Get posts from followed users
-	var dbPosts []models.Post
-	if err := db.DB.Where("user_id IN ?", followedIDs).Order("created_at desc").Limit(limit).Offset(offset).Find(&dbPosts).Error; err != nil {
+	dbPosts, err := s.posts.ByAuthors(ctx, followedIDs, limit, offset)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch feed posts"})
 	}
 
 	// Get likes count and check if current user liked each post
 	for _, post := range dbPosts {
-		var likesCount int64
-		db.DB.Model(&models.Like{}).Where("post_id = ?", post.ID).Count(&likesCount)
+		s.presentPost(c, &post)
+
+		likesCount, _ := s.likes.Count(ctx, post.ID)
 
 		// Check if current user liked this post
-		var isLiked bool
-		var likeExists int64
-		db.DB.Model(&models.Like{}).Where("post_id = ? AND user_id = ?", post.ID, userID).Count(&likeExists)
-		isLiked = likeExists > 0
+		_, err := s.likes.Find(ctx, uint(userID), post.ID)
+		isLiked := err == nil
 
 		// Get username for this post
-		var user models.User
 		username := ""
-		if err := db.DB.First(&user, post.UserID).Error; err == nil {
+		if user, err := s.users.ByID(ctx, post.UserID); err == nil {
 			username = user.Username
 		}
 
@@ -88,8 +85,12 @@ func GetFeed(c *fiber.Ctx) error {
 			Username:   username,
 			Caption:    post.Caption,
 			MediaURL:   post.MediaURL,
+			MediaType:  post.MediaType,
+			Renditions: post.Renditions,
+			Media:      post.Media,
 			Flagged:    post.Flagged,
 			CreatedAt:  post.CreatedAt,
+			EditedAt:   post.EditedAt,
 			LikesCount: int(likesCount),
 			IsLiked:    isLiked,
 		})
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+)
+
+// How long a single dependency check may take before it counts as down
+const healthCheckTimeout = 2 * time.Second
+
+// healthCheck checks one dependency. Checks that are not critical only
+// degrade readiness: posts are still accepted while the AI service is down,
+// they just go unmoderated.
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) (details interface{}, err error)
+}
+
+func (s *Server) healthChecks() []healthCheck {
+	return []healthCheck{
+		{name: "database", critical: true, check: func(ctx context.Context) (interface{}, error) {
+			return nil, db.Ping(ctx)
+		}},
+		{name: "storage", critical: true, check: func(ctx context.Context) (interface{}, error) {
+			return nil, storage.Probe(ctx, s.store)
+		}},
+		{name: "ai_service", check: func(ctx context.Context) (interface{}, error) {
+			return nil, s.cfg.AI.Ping(ctx)
+		}},
+		{name: "websocket", critical: true, check: func(ctx context.Context) (interface{}, error) {
+			stats := s.ws.Stats()
+			if stats.Closing {
+				return stats, errors.New("shutting down")
+			}
+			return stats, nil
+		}},
+	}
+}
+
+// registerHealthRoutes registers the health endpoints, which need no token
+func (s *Server) registerHealthRoutes(app *fiber.App) {
+	app.Get("/health", s.Liveness)
+	app.Get("/health/live", s.Liveness)
+	app.Get("/health/ready", s.Readiness)
+}
+
+// Liveness handles GET /health and GET /health/live
+// @Summary Liveness check
+// @Description Reports that the process is up and serving requests. It checks no dependencies, so a failing database does not get the server restarted.
+// @Tags health
+// @Produce json
+// @Success 200 {object} models.HealthResponse
+// @Router /health/live [get]
+func (s *Server) Liveness(c *fiber.Ctx) error {
+	return c.JSON(models.HealthResponse{Status: models.HealthOK})
+}
+
+// Readiness handles GET /health/ready
+// @Summary Readiness check
+// @Description Checks the database, media storage, AI service and WebSocket manager and reports the status and latency of each. Responds 503 when the database or storage is down or the server is shutting down; an unreachable AI service only marks the server degraded.
+// @Tags health
+// @Produce json
+// @Success 200 {object} models.ReadinessResponse
+// @Failure 503 {object} models.ReadinessResponse
+// @Router /health/ready [get]
+func (s *Server) Readiness(c *fiber.Ctx) error {
+	report := s.checkHealth(c.UserContext())
+	status := fiber.StatusOK
+	if report.Status == models.HealthUnavailable {
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(report)
+}
+
+// checkHealth runs every health check concurrently
+func (s *Server) checkHealth(ctx context.Context) models.ReadinessResponse {
+	checks := s.healthChecks()
+	results := make([]models.ComponentHealth, len(checks))
+	var wg sync.WaitGroup
+	for i, hc := range checks {
+		wg.Add(1)
+		go func(i int, hc healthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, hc)
+		}(i, hc)
+	}
+	wg.Wait()
+
+	report := models.ReadinessResponse{Status: models.HealthOK, Components: make(map[string]models.ComponentHealth, len(checks))}
+	for i, hc := range checks {
+		report.Components[hc.name] = results[i]
+		if results[i].Status == models.HealthOK {
+			continue
+		}
+		if hc.critical {
+			report.Status = models.HealthUnavailable
+		} else if report.Status == models.HealthOK {
+			report.Status = models.HealthDegraded
+		}
+	}
+	return report
+}
+
+func runHealthCheck(ctx context.Context, hc healthCheck) models.ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	details, err := hc.check(ctx)
+	result := models.ComponentHealth{
+		Status:    models.HealthOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Details:   details,
+	}
+	if err != nil {
+		result.Status = models.HealthDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"image"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+const (
+	// How long the AI service gets to fetch an image it was asked to moderate
+	moderationURLTTL = 5 * time.Minute
+	// Most media items a single carousel post may hold
+	maxPostMedia = 10
+)
+
+func (s *Server) registerMediaRoutes(app *fiber.App) {
+	app.Get("/"+storage.MediaURLPrefix+"/*", s.ServeMedia)
+}
+
+// ServeMedia handles GET /media/*
+// @Summary Fetch media
+// @Description Streams an uploaded file for a signed, unexpired URL, provided the viewer it was issued to may still see it. Media of deleted posts, flagged items (except for the owner) and private accounts (except for followers) is not served.
+// @Tags media
+// @Produce octet-stream
+// @Param key path string true "Media key"
+// @Param exp query int true "Expiry as a Unix timestamp"
+// @Param uid query int true "Viewer user ID"
+// @Param sig query string true "URL signature"
+// @Success 200 {file} file
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /media/{key} [get]
+func (s *Server) ServeMedia(c *fiber.Ctx) error {
+	key := c.Params("*")
+	if !storage.ValidKey(key) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Media not found"})
+	}
+	query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{Error: "Invalid media URL"})
+	}
+	viewerID, err := s.signer.Verify(key, query, time.Now())
+	if errors.Is(err, storage.ErrExpiredURL) {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{Error: "Media URL has expired"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{Error: "Invalid media URL"})
+	}
+	if !s.canViewMedia(c.UserContext(), key, viewerID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Media not found"})
+	}
+
+	rc, err := s.store.Open(c.UserContext(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Media not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to read media"})
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		c.Set(fiber.HeaderContentType, contentType)
+	}
+	c.Set(fiber.HeaderCacheControl, "private, max-age=300")
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	return c.SendStream(rc)
+}
+
+// canViewMedia decides whether viewerID may fetch key right now. Media that
+// belongs to no visible post is only available to the users who uploaded it.
+func (s *Server) canViewMedia(ctx context.Context, key string, viewerID uint) bool {
+	// Viewer 0 is only ever signed for internal callers
+	if viewerID == 0 {
+		return true
+	}
+	keys := []string{key, storage.BaseKey(key)}
+
+	items, err := s.posts.ItemsUsing(ctx, keys)
+	if err != nil {
+		return false
+	}
+	for _, item := range items {
+		post, err := s.posts.ByIDWithTrashed(ctx, item.PostID)
+		if err != nil || post.DeletedAt.Valid || !s.postMayShow(ctx, key, post.UserID) {
+			continue
+		}
+		if s.canViewPost(ctx, *post, item.Flagged, viewerID) {
+			return true
+		}
+	}
+
+	// Posts created before carousels keep their only media on the post row
+	posts, err := s.posts.UsingMedia(ctx, keys, storage.BaseKey(key))
+	if err != nil {
+		return false
+	}
+	for _, post := range posts {
+		if s.postMayShow(ctx, key, post.UserID) && s.canViewPost(ctx, post, post.Flagged, viewerID) {
+			return true
+		}
+	}
+
+	// Uploaders keep access to content they hold a copy of anyway
+	if storage.IsContentKey(key) {
+		return s.uploadedBy(ctx, key, viewerID)
+	}
+	if len(items) > 0 || len(posts) > 0 {
+		return false
+	}
+	return s.uploadedBy(ctx, key, viewerID)
+}
+
+// postMayShow reports whether a post by authorID may show the media stored
+// under key. A post only shares media its author uploaded, except that keys
+// from before uploads were attributed name no uploader and belong to the
+// posts using them.
+func (s *Server) postMayShow(ctx context.Context, key string, authorID uint) bool {
+	if !storage.IsContentKey(key) {
+		if _, ok := storage.KeyOwner(key); !ok {
+			return true
+		}
+	}
+	return s.uploadedBy(ctx, key, authorID)
+}
+
+// uploadedBy reports whether userID uploaded the media stored under key or
+// one of its renditions. Keys from before content addressing name their
+// uploader in the first path segment. Failed lookups count as not uploaded.
+func (s *Server) uploadedBy(ctx context.Context, key string, userID uint) bool {
+	if storage.IsContentKey(key) {
+		owns, err := s.media.IsOwner(ctx, storage.BaseKey(key), userID)
+		return err == nil && owns
+	}
+	owner, ok := storage.KeyOwner(key)
+	return ok && owner == userID
+}
+
+// canViewPost applies moderation and account privacy to a post's media
+func (s *Server) canViewPost(ctx context.Context, post models.Post, flagged bool, viewerID uint) bool {
+	if post.UserID == viewerID {
+		return true
+	}
+	if flagged {
+		return false
+	}
+	author, err := s.users.ByID(ctx, post.UserID)
+	if err != nil {
+		return false
+	}
+	if !author.IsPrivate {
+		return true
+	}
+	follows, err := s.follows.IsFollowing(ctx, viewerID, post.UserID)
+	return err == nil && follows
+}
+
+// mediaKeyForURL finds the storage key behind a media URL, accepting signed
+// and unsigned API URLs as well as URLs the storage backend issued itself
+func (s *Server) mediaKeyForURL(mediaURL string) (string, bool) {
+	if key, ok := storage.KeyFromMediaURL(mediaURL); ok {
+		return key, true
+	}
+	return s.store.KeyForURL(mediaURL)
+}
+
+// mediaTypeOf guesses whether a media URL points at a video or an image
+func mediaTypeOf(mediaURL string) string {
+	if u, err := url.Parse(mediaURL); err == nil {
+		mediaURL = u.Path
+	}
+	if strings.HasPrefix(mime.TypeByExtension(path.Ext(mediaURL)), "video/") {
+		return models.MediaTypeVideo
+	}
+	return models.MediaTypeImage
+}
+
+// errMediaNotOwned is returned by attachMedia for uploads of other users
+var errMediaNotOwned = errors.New("media was not uploaded by the post author")
+
+// attachMedia links a carousel item to the stored upload its MediaURL points
+// at, recording its type, dimensions and the unsigned paths of its renditions.
+// Video items may name a poster image uploaded alongside in PosterURL. Both
+// must have been uploaded by userID.
+func (s *Server) attachMedia(ctx context.Context, item *models.PostMedia, userID uint) error {
+	// Everything but the URLs and alt text is derived from the stored uploads
+	posterURL := item.PosterURL
+	*item = models.PostMedia{Position: item.Position, MediaURL: item.MediaURL, AltText: item.AltText}
+	item.MediaType = mediaTypeOf(item.MediaURL)
+	key, ok := s.mediaKeyForURL(item.MediaURL)
+	if !ok {
+		return nil
+	}
+	if !s.uploadedBy(ctx, key, userID) {
+		return errMediaNotOwned
+	}
+	item.MediaKey = key
+	item.MediaURL = storage.MediaPath(key)
+	if keys, err := storage.RenditionKeys(ctx, s.store, key); err == nil {
+		item.Renditions = models.MediaRenditions{
+			Thumb:  storage.MediaPath(keys.Thumb),
+			Medium: storage.MediaPath(keys.Medium),
+			Full:   storage.MediaPath(keys.Full),
+		}
+	}
+
+	media, err := s.mediaByKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	if media != nil {
+		item.MediaType = media.MediaType
+		item.Width, item.Height = media.Width, media.Height
+		item.DurationMs, item.Codec = media.DurationMs, media.Codec
+	} else if item.MediaType == models.MediaTypeImage {
+		item.Width, item.Height = s.imageDimensions(ctx, key)
+	} else if video := s.videoMetadata(ctx, key); video != nil {
+		item.Width, item.Height = video.Width, video.Height
+		item.DurationMs = video.Duration.Milliseconds()
+		item.Codec = video.Codec
+	}
+
+	if item.MediaType == models.MediaTypeVideo && posterURL != "" {
+		if posterKey, ok := s.mediaKeyForURL(posterURL); ok {
+			poster, err := s.mediaByKey(ctx, posterKey)
+			if err != nil {
+				return err
+			}
+			if poster != nil {
+				if !s.uploadedBy(ctx, posterKey, userID) {
+					return errMediaNotOwned
+				}
+				item.PosterKey = posterKey
+				item.PosterURL = storage.MediaPath(posterKey)
+			}
+		}
+	}
+	return nil
+}
+
+// imageDimensions reads the size of a stored image from its header
+func (s *Server) imageDimensions(ctx context.Context, key string) (int, int) {
+	rc, err := s.store.Open(ctx, key)
+	if err != nil {
+		return 0, 0
+	}
+	defer rc.Close()
+	cfg, _, err := image.DecodeConfig(rc)
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// videoMetadata parses the container of a stored video
+func (s *Server) videoMetadata(ctx context.Context, key string) *utils.VideoInfo {
+	rc, err := s.store.Open(ctx, key)
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, s.cfg.Uploads.MaxVideoSize))
+	if err != nil {
+		return nil
+	}
+	video, err := utils.ParseVideo(mime.TypeByExtension(path.Ext(key)), data)
+	if err != nil {
+		return nil
+	}
+	return video
+}
+
+// internalMediaURL returns a URL services such as the AI moderator can fetch
+func (s *Server) internalMediaURL(mediaURL, key string) string {
+	if key == "" {
+		return mediaURL
+	}
+	return s.signer.Sign(key, 0, moderationURLTTL)
+}
+
+// presentPost replaces a post's stored media paths with URLs signed for the
+// current viewer. Posts created before carousels get their single media as
+// the only item.
+func (s *Server) presentPost(c *fiber.Ctx, post *models.Post) {
+	userID, _ := c.Locals("user_id").(int64)
+	viewerID := uint(userID)
+	ttl := s.signer.TTL()
+
+	if len(post.Media) == 0 {
+		post.Media = []models.PostMedia{{
+			PostID:     post.ID,
+			MediaType:  mediaTypeOf(post.MediaURL),
+			MediaURL:   post.MediaURL,
+			Flagged:    post.Flagged,
+			Renditions: post.Renditions,
+		}}
+		post.MediaType = post.Media[0].MediaType
+	}
+	s.signMediaURLs(&post.MediaURL, &post.Renditions, viewerID, ttl)
+	for i := range post.Media {
+		item := &post.Media[i]
+		s.signMediaURLs(&item.MediaURL, &item.Renditions, viewerID, ttl)
+		if item.PosterKey != "" {
+			item.PosterURL = s.signer.Sign(item.PosterKey, viewerID, ttl)
+		}
+	}
+}
+
+// signMediaURLs signs a media URL and its renditions for viewerID. Media
+// without renditions (external URLs, uploads from before renditions existed)
+// gets the media URL for every size.
+func (s *Server) signMediaURLs(mediaURL *string, renditions *models.MediaRenditions, viewerID uint, ttl time.Duration) {
+	if key, ok := s.mediaKeyForURL(*mediaURL); ok {
+		*mediaURL = s.signer.Sign(key, viewerID, ttl)
+	}
+	if renditions.Full == "" {
+		*renditions = models.MediaRenditions{Thumb: *mediaURL, Medium: *mediaURL, Full: *mediaURL}
+		return
+	}
+	for _, rendition := range []*string{&renditions.Thumb, &renditions.Medium, &renditions.Full} {
+		if key, ok := s.mediaKeyForURL(*rendition); ok {
+			*rendition = s.signer.Sign(key, viewerID, ttl)
+		}
+	}
+}
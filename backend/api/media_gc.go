@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+)
+
+// Most media rows a single sweep looks at
+const mediaGCBatchSize = 500
+
+// MediaGCOptions configures a sweep for orphaned media
+type MediaGCOptions struct {
+	// How long media must have gone unreferenced and un-uploaded before it is deleted
+	Grace time.Duration
+	// Report what would be deleted without deleting anything
+	DryRun bool
+}
+
+// MediaGCStats reports what a sweep deleted, or would have in dry-run mode
+type MediaGCStats struct {
+	Scanned        int
+	Deleted        int
+	BytesReclaimed int64
+}
+
+var (
+	mediaGCMu     sync.Mutex
+	mediaGCTotals MediaGCStats
+)
+
+// MediaGCTotals returns what all sweeps of this process have deleted so far.
+// Dry runs are not counted.
+func MediaGCTotals() MediaGCStats {
+	mediaGCMu.Lock()
+	defer mediaGCMu.Unlock()
+	return mediaGCTotals
+}
+
+// SweepOrphanedMedia deletes media no post references, which nobody uploaded
+// or released within the grace period: uploads that were never attached and
+// the files of deleted posts. Files uploaded before content addressing have no
+// Media row and are not collected.
+func (s *Server) SweepOrphanedMedia(ctx context.Context, opts MediaGCOptions) (MediaGCStats, error) {
+	var stats MediaGCStats
+	cutoff := time.Now().Add(-opts.Grace)
+	candidates, err := s.media.Orphaned(ctx, cutoff, mediaGCBatchSize)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, media := range candidates {
+		stats.Scanned++
+		refs, err := s.media.CountRefs(ctx, media.Key)
+		if err != nil {
+			return stats, err
+		}
+		if refs > 0 {
+			// The count drifted; trust the posts and keep the file
+			if err := s.media.SetRefCount(ctx, media.ID, refs); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		size := media.StoredSize
+		if size == 0 {
+			size = media.Size
+		}
+		if opts.DryRun {
+			slog.InfoContext(ctx, "Media GC dry run: would delete", "media_key", media.Key, "bytes", size)
+			stats.Deleted++
+			stats.BytesReclaimed += size
+			continue
+		}
+
+		// Delete the row first so an upload racing with the sweep stores the
+		// objects again instead of reusing ones about to disappear
+		deleted, err := s.media.DeleteOrphan(ctx, media.ID, cutoff)
+		if err != nil {
+			return stats, err
+		}
+		if !deleted {
+			continue
+		}
+		for _, name := range []string{"thumb", "medium", "full"} {
+			if err := s.store.Delete(ctx, storage.RenditionKey(media.Key, name)); err != nil {
+				return stats, err
+			}
+		}
+		stats.Deleted++
+		stats.BytesReclaimed += size
+	}
+
+	if !opts.DryRun {
+		mediaGCMu.Lock()
+		mediaGCTotals.Scanned += stats.Scanned
+		mediaGCTotals.Deleted += stats.Deleted
+		mediaGCTotals.BytesReclaimed += stats.BytesReclaimed
+		mediaGCMu.Unlock()
+		metrics.MediaGCDeleted.Add(float64(stats.Deleted))
+		metrics.MediaGCBytesReclaimed.Add(float64(stats.BytesReclaimed))
+	}
+	return stats, nil
+}
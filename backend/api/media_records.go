@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"time"
+
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+// storeMedia stores validated upload data under a key derived from its
+// SHA-256, reusing the existing objects when the same bytes were uploaded
+// before, and returns the Media row describing it. Images matching a banned
+// image are rejected.
+func (s *Server) storeMedia(ctx context.Context, data []byte, info *utils.MediaInfo) (*models.Media, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	media, err := s.media.ByHash(ctx, hash)
+	switch {
+	case err == nil:
+		// The image may have been banned since it was first uploaded
+		if err := s.checkBannedMedia(ctx, media); err != nil {
+			return nil, err
+		}
+		if ok, err := s.store.Exists(ctx, media.Key); err != nil || ok {
+			if err == nil {
+				// A fresh upload restarts the garbage collection grace period
+				err = s.media.Touch(ctx, media.ID, time.Now())
+			}
+			return media, err
+		}
+		// The row outlived its objects; store them again under the same key
+	case !errors.Is(err, repository.ErrNotFound):
+		return nil, err
+	}
+
+	media = &models.Media{
+		Hash:        hash,
+		MediaType:   models.MediaTypeImage,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		Width:       info.Width,
+		Height:      info.Height,
+		LastUsedAt:  time.Now(),
+	}
+	if info.IsImage() {
+		phash, dhash, err := utils.PerceptualHashes(data)
+		if err != nil {
+			return nil, &utils.MediaError{Code: utils.MediaErrMalformed, Message: "Failed to process image"}
+		}
+		match, err := s.matchBannedMedia(ctx, phash, dhash)
+		if err != nil {
+			return nil, err
+		}
+		if match != nil {
+			return nil, bannedMediaError()
+		}
+		media.PHash, media.DHash = int64(phash), int64(dhash)
+
+		// Images are re-encoded into sized renditions without metadata and the
+		// original is discarded
+		renditions, ext, err := utils.ProcessImage(data)
+		if err != nil {
+			return nil, &utils.MediaError{Code: utils.MediaErrMalformed, Message: "Failed to process image"}
+		}
+		media.Key = storage.ContentKey(hash, ext)
+		media.ContentType = mime.TypeByExtension(ext)
+		for _, rendition := range renditions {
+			key := storage.RenditionKey(media.Key, rendition.Name)
+			if err := s.store.Put(ctx, key, bytes.NewReader(rendition.Data), int64(len(rendition.Data)), media.ContentType); err != nil {
+				return nil, err
+			}
+			media.StoredSize += int64(len(rendition.Data))
+			if rendition.Name == "full" {
+				media.Width, media.Height = rendition.Width, rendition.Height
+			}
+		}
+	} else {
+		// Videos are stored as uploaded
+		media.Key = storage.ContentKey(hash, info.Ext)
+		media.MediaType = models.MediaTypeVideo
+		media.DurationMs = info.Duration.Milliseconds()
+		media.Codec = info.Codec
+		if err := s.store.Put(ctx, media.Key, bytes.NewReader(data), int64(len(data)), info.ContentType); err != nil {
+			return nil, err
+		}
+		media.StoredSize = int64(len(data))
+	}
+
+	// Identical uploads may race to create the row; whichever wins
+	// describes the same objects
+	if err := s.media.Save(ctx, media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// mediaByKey returns the Media row for a storage key, or nil for external
+// URLs and uploads from before content addressing
+func (s *Server) mediaByKey(ctx context.Context, key string) (*models.Media, error) {
+	if key == "" {
+		return nil, nil
+	}
+	media, err := s.media.ByKey(ctx, key)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	return media, err
+}
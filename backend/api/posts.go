@@ -1,24 +1,33 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
 	"github.com/umutdeveloper/instagram-light/backend/middleware"
 	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
 	"github.com/umutdeveloper/instagram-light/backend/utils"
 )
 
 // RegisterPostRoutes registers post-related routes
-func RegisterPostRoutes(app *fiber.App) {
-	posts := app.Group("/api/posts", middleware.JWTMiddleware())
-	posts.Get("/", GetPosts)
-	posts.Post("/", CreatePost)
-	posts.Get(":id", GetPostByID)
-	posts.Delete(":id", DeletePostByID)
-	posts.Post(":id/like", ToggleLike)
+func (s *Server) RegisterPostRoutes(app *fiber.App) {
+	posts := app.Group("/api/posts", middleware.JWTMiddleware(s.cfg.JWTSecret))
+	posts.Get("/", s.GetPosts)
+	posts.Post("/", s.CreatePost)
+	posts.Get(":id", s.GetPostByID)
+	posts.Patch(":id", s.UpdatePost)
+	posts.Get(":id/revisions", s.GetPostRevisions)
+	posts.Delete(":id", s.DeletePostByID)
+	posts.Post(":id/restore", s.RestorePost)
+	posts.Post(":id/like", s.rateLimit(ratelimit.Rule{Policy: "like", Limit: s.cfg.RateLimits.Likes, Key: ratelimit.ByUser}), s.ToggleLike)
 }
 
 // GetPosts handles GET /api/posts
@@ -32,7 +41,7 @@ func RegisterPostRoutes(app *fiber.App) {
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/posts [get]
-func GetPosts(c *fiber.Ctx) error {
+func (s *Server) GetPosts(c *fiber.Ctx) error {
 	// Pagination
 	pageParam := c.Query("page", "1")
 	limitParam := c.Query("limit", "20")
@@ -46,11 +55,13 @@ func GetPosts(c *fiber.Ctx) error {
 	}
 	offset := (page - 1) * limit
 
-	var posts []models.Post
-	tx := db.DB.Order("created_at DESC").Limit(limit).Offset(offset)
-	if err := tx.Find(&posts).Error; err != nil {
+	posts, err := s.posts.List(c.UserContext(), limit, offset)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch posts"})
 	}
+	for i := range posts {
+		s.presentPost(c, &posts[i])
+	}
 	return c.JSON(models.PostsResponse{
 		Page:  page,
 		Limit: limit,
@@ -60,36 +71,68 @@ func GetPosts(c *fiber.Ctx) error {
 
 // CreatePost handles POST /api/posts
 // @Summary Create a post
-// @Description Create a new post
+// @Description Create a new post for the authenticated user from up to 10 media items they uploaded, given in order as `media`. A single `media_url` is accepted as a one-item post. Each item is moderated separately, and items matching a banned image are rejected.
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Param post body models.Post true "Post data"
 // @Success 201 {object} models.Post
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/posts [post]
-func CreatePost(c *fiber.Ctx) error {
+func (s *Server) CreatePost(c *fiber.Ctx) error {
 	var post models.Post
 	if err := c.BodyParser(&post); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
 	}
-	if post.UserID == 0 || post.MediaURL == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "UserID and MediaURL are required"})
+	// Posts are always created as the authenticated user
+	userID, _ := c.Locals("user_id").(int64)
+	post.UserID = uint(userID)
+	// Single-media clients only send media_url
+	if len(post.Media) == 0 && post.MediaURL != "" {
+		post.Media = []models.PostMedia{{MediaURL: post.MediaURL}}
 	}
-
-	aiResponse, err := utils.ModerateImage(post.MediaURL)
-	if err != nil {
-		fmt.Printf("AI moderation failed: %v\n", err)
-	} else {
-		post.Flagged = aiResponse.NSFW
-		fmt.Printf("AI moderation result: NSFW=%v, Score=%.3f\n", aiResponse.NSFW, aiResponse.Score)
+	if len(post.Media) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Media is required"})
+	}
+	if len(post.Media) > maxPostMedia {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("A post can hold at most %d media items", maxPostMedia)})
+	}
+	post.Flagged = false
+	post.EditedAt = nil
+	for i := range post.Media {
+		item := &post.Media[i]
+		if item.MediaURL == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Every media item needs a media_url"})
+		}
+		item.Position = i
+		if err := s.attachMedia(c.UserContext(), item, post.UserID); errors.Is(err, errMediaNotOwned) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Posts can only use media you uploaded"})
+		} else if err != nil {
+			slog.ErrorContext(c.UserContext(), "Failed to attach media", "media_url", item.MediaURL, "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to attach media"})
+		}
+		if err := s.checkBannedItem(c.UserContext(), item); err != nil {
+			var mediaErr *utils.MediaError
+			if errors.As(err, &mediaErr) {
+				return mediaErrorResponse(c, err)
+			}
+			slog.ErrorContext(c.UserContext(), "Failed to check media against bans", "media_key", item.MediaKey, "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check media"})
+		}
+		s.moderateMedia(c.UserContext(), item)
+		post.Flagged = post.Flagged || item.Flagged
 	}
+	cover := post.Media[0]
+	post.MediaURL, post.MediaKey, post.MediaType, post.Renditions = cover.MediaURL, cover.MediaKey, cover.MediaType, cover.Renditions
 
-	if err := db.DB.Create(&post).Error; err != nil {
+	if err := s.posts.Create(c.UserContext(), &post); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create post"})
 	}
+	s.presentPost(c, &post)
 	return c.Status(fiber.StatusCreated).JSON(post)
 }
 
@@ -104,36 +147,182 @@ func CreatePost(c *fiber.Ctx) error {
 // @Failure 404 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/posts/{id} [get]
-func GetPostByID(c *fiber.Ctx) error {
+func (s *Server) GetPostByID(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid post ID"})
 	}
-	var post models.Post
-	if err := db.DB.First(&post, id).Error; err != nil {
+	post, err := s.posts.ByID(c.UserContext(), uint(id))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
+	}
+	s.presentPost(c, post)
+	return c.JSON(post)
+}
+
+// UpdatePost handles PATCH /api/posts/:id
+// @Summary Edit a post
+// @Description Lets the author change the caption and the alt text of carousel items, given by item ID. The previous version is kept as a revision, and followers who have the post open get a post_updated WebSocket event.
+// @Tags posts
+// @Accept json
+// @Produce json
+// @Param id path int true "Post ID"
+// @Param post body models.UpdatePostRequest true "Fields to change"
+// @Success 200 {object} models.Post
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/posts/{id} [patch]
+func (s *Server) UpdatePost(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+	userID, _ := c.Locals("user_id").(int64)
+	var req models.UpdatePostRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Caption == nil && len(req.Media) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "caption or media is required"})
+	}
+
+	post, err := s.posts.ByID(c.UserContext(), uint(id))
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
 	}
+	if post.UserID != uint(userID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the author can edit a post"})
+	}
+
+	revision := models.PostRevision{PostID: post.ID, Caption: post.Caption, AltTexts: map[uint]string{}, EditedBy: uint(userID)}
+	items := map[uint]*models.PostMedia{}
+	for i := range post.Media {
+		items[post.Media[i].ID] = &post.Media[i]
+		revision.AltTexts[post.Media[i].ID] = post.Media[i].AltText
+	}
+	changed := false
+	if req.Caption != nil && *req.Caption != post.Caption {
+		post.Caption = *req.Caption
+		changed = true
+	}
+	for _, edit := range req.Media {
+		item, ok := items[edit.ID]
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("Post has no media item %d", edit.ID)})
+		}
+		if item.AltText != edit.AltText {
+			item.AltText = edit.AltText
+			changed = true
+		}
+	}
+
+	if changed {
+		now := time.Now()
+		post.EditedAt = &now
+		if err := s.posts.Update(c.UserContext(), post, &revision); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update post"})
+		}
+		updated, ctx := *post, c.UserContext()
+		s.goAsync(func() { s.notifyPostUpdated(ctx, updated) })
+	}
+	s.presentPost(c, post)
 	return c.JSON(post)
 }
 
+// GetPostRevisions handles GET /api/posts/:id/revisions
+// @Summary Get post edit history
+// @Description Lists earlier versions of a post's caption and alt text, newest first, to anyone who can see the post
+// @Tags posts
+// @Produce json
+// @Param id path int true "Post ID"
+// @Success 200 {array} models.PostRevision
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/posts/{id}/revisions [get]
+func (s *Server) GetPostRevisions(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+	userID, _ := c.Locals("user_id").(int64)
+	post, err := s.posts.ByID(c.UserContext(), uint(id))
+	if err != nil || !s.canViewPost(c.UserContext(), *post, post.Flagged, uint(userID)) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
+	}
+	revisions, err := s.posts.Revisions(c.UserContext(), post.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch revisions"})
+	}
+	return c.JSON(revisions)
+}
+
+// notifyPostUpdated sends a post_updated event to the author's followers who
+// currently have the post open
+func (s *Server) notifyPostUpdated(ctx context.Context, post models.Post) {
+	var viewerIDs []uint
+	for _, viewer := range s.ws.PostViewers(post.ID) {
+		if id, err := strconv.ParseUint(viewer, 10, 64); err == nil {
+			viewerIDs = append(viewerIDs, uint(id))
+		}
+	}
+	if len(viewerIDs) == 0 {
+		return
+	}
+	followerIDs, err := s.follows.FollowersAmong(ctx, post.UserID, viewerIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find followers viewing post", "post_id", post.ID, "error", err)
+		return
+	}
+
+	payload := models.PostUpdatedPayload{PostID: post.ID, Caption: post.Caption, Media: []models.PostMediaAltText{}, EditedAt: *post.EditedAt}
+	for _, item := range post.Media {
+		payload.Media = append(payload.Media, models.PostMediaAltText{ID: item.ID, AltText: item.AltText})
+	}
+	event := models.WSEvent{Type: "post_updated", Payload: payload}
+	for _, followerID := range followerIDs {
+		s.notifyUser(ctx, followerID, event)
+	}
+}
+
 // DeletePostByID handles DELETE /api/posts/:id
 // @Summary Delete post by ID
-// @Description Delete a post by its ID
+// @Description Moves a post to the trash, hiding it along with its comments and likes. Only the author can delete a post. It can be restored within TRASH_RETENTION (30 days by default) and is permanently deleted afterwards.
 // @Tags posts
 // @Param id path int true "Post ID"
 // @Success 204 {string} string "No Content"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/posts/{id} [delete]
-func DeletePostByID(c *fiber.Ctx) error {
+func (s *Server) DeletePostByID(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid post ID"})
 	}
-	if err := db.DB.Delete(&models.Post{}, id).Error; err != nil {
+	userID, _ := c.Locals("user_id").(int64)
+	ctx := c.UserContext()
+	post, err := s.posts.ByIDWithTrashed(ctx, uint(id))
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && post.DeletedAt.Valid) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete post"})
+	}
+	if post.UserID != uint(userID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the author can delete a post"})
+	}
+	// Media stays referenced until the post is purged, so it can be restored
+	if err := s.posts.Trash(ctx, post.ID); errors.Is(err, repository.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
+	} else if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete post"})
 	}
 	return c.SendStatus(fiber.StatusNoContent)
@@ -148,10 +337,11 @@ func DeletePostByID(c *fiber.Ctx) error {
 // @Success 200 {object} models.ToggleLikeResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/posts/{id}/like [post]
-func ToggleLike(c *fiber.Ctx) error {
+func (s *Server) ToggleLike(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
@@ -162,37 +352,77 @@ func ToggleLike(c *fiber.Ctx) error {
 	if !ok || userID == 0 {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 	}
+	ctx := c.UserContext()
 	// Check if post exists
-	var post models.Post
-	if err := db.DB.First(&post, id).Error; err != nil {
+	post, err := s.posts.ByID(ctx, uint(id))
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
 	}
 	// Check if like exists
-	var like models.Like
-	result := db.DB.Where("user_id = ? AND post_id = ?", userID, id).First(&like)
-	if result.Error == nil {
+	if like, err := s.likes.Find(ctx, uint(userID), post.ID); err == nil {
 		// Like exists, so unlike (delete)
-		if err := db.DB.Delete(&like).Error; err != nil {
+		if err := s.likes.Delete(ctx, like); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to unlike post"})
 		}
 		return c.JSON(models.ToggleLikeResponse{Liked: false})
 	}
 	// Like does not exist, so like (create)
 	newLike := models.Like{UserID: uint(userID), PostID: uint(id)}
-	if err := db.DB.Create(&newLike).Error; err != nil {
+	if err := s.likes.Create(ctx, &newLike); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to like post"})
 	}
 
-	var postOwner models.Post
-	if err := db.DB.First(&postOwner, id).Error; err == nil {
-		wsEvent := models.WSEvent{
-			Type:    "new_like",
-			Payload: newLike,
-		}
-		go func(userID uint, event models.WSEvent) {
-			_ = utils.WSManagerInstance.SendToUser(fmt.Sprintf("%d", userID), event)
-		}(postOwner.UserID, wsEvent)
+	wsEvent := models.WSEvent{
+		Type:    "new_like",
+		Payload: newLike,
 	}
+	ownerID := post.UserID
+	s.goAsync(func() { s.notifyUser(ctx, ownerID, wsEvent) })
 
 	return c.JSON(models.ToggleLikeResponse{Liked: true})
 }
+
+// moderateMedia asks the AI service whether an item is NSFW. Videos are judged
+// by their poster image; videos without one are not moderated. Verdicts are
+// cached on the Media row, so identical content is only classified once.
+func (s *Server) moderateMedia(ctx context.Context, item *models.PostMedia) {
+	mediaURL, key := item.MediaURL, item.MediaKey
+	if item.MediaType == models.MediaTypeVideo {
+		if item.PosterKey == "" {
+			return
+		}
+		mediaURL, key = item.PosterURL, item.PosterKey
+	}
+	media, err := s.mediaByKey(ctx, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up moderation verdict", "media_key", key, "error", err)
+	}
+	if media != nil && media.Moderated {
+		item.Flagged = media.Flagged
+		metrics.AIModerations.WithLabelValues("cached").Inc()
+		return
+	}
+
+	start := time.Now()
+	aiResponse, err := s.cfg.AI.ModerateImage(ctx, s.internalMediaURL(mediaURL, key))
+	outcome := "clean"
+	switch {
+	case err != nil:
+		outcome = "error"
+	case aiResponse.NSFW:
+		outcome = "flagged"
+	}
+	metrics.AIModerations.WithLabelValues(outcome).Inc()
+	metrics.AIModerationDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	if err != nil {
+		slog.ErrorContext(ctx, "AI moderation failed", "media_key", key, "error", err)
+		return
+	}
+	item.Flagged = aiResponse.NSFW
+	slog.InfoContext(ctx, "AI moderation result", "media_key", key, "nsfw", aiResponse.NSFW, "score", aiResponse.Score)
+	if media != nil {
+		if err := s.media.SetModeration(ctx, media.ID, aiResponse.NSFW, aiResponse.Score); err != nil {
+			slog.ErrorContext(ctx, "Failed to cache moderation verdict", "media_key", key, "error", err)
+		}
+	}
+}
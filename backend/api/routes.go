@@ -2,17 +2,19 @@ package api
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
 )
 
-func RegisterRoutes(app *fiber.App) {
-	app.Get("/health", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"status": "ok"})
-	})
-
-	RegisterAuthRoutes(app)
-	RegisterUserRoutes(app)
-	RegisterPostRoutes(app)
-	RegisterCommentRoutes(app)
-	RegisterFeedRoutes(app)
-	registerUploadRoutes(app)
+// RegisterRoutes registers every API route
+func (s *Server) RegisterRoutes(app *fiber.App) {
+	s.registerHealthRoutes(app)
+	app.Get("/metrics", metrics.Handler)
+	s.RegisterAuthRoutes(app)
+	s.RegisterUserRoutes(app)
+	s.RegisterPostRoutes(app)
+	s.RegisterCommentRoutes(app)
+	s.RegisterFeedRoutes(app)
+	s.registerUploadRoutes(app)
+	s.registerMediaRoutes(app)
+	s.registerModerationRoutes(app)
 }
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/config"
+	"github.com/umutdeveloper/instagram-light/backend/mail"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+// Server holds the configuration and the repositories the HTTP handlers
+// read and write through
+type Server struct {
+	cfg      *config.Config
+	users    repository.Users
+	posts    repository.Posts
+	comments repository.Comments
+	likes    repository.Likes
+	follows  repository.Follows
+	tokens   repository.AuthTokens
+	// loginFailures throttles password guessing per username or email
+	loginFailures repository.LoginFailures
+	media         repository.Media
+	uploads       repository.UploadSessions
+	bans          repository.BannedMedia
+	// limits counts requests for the rate limited routes
+	limits ratelimit.Store
+
+	store  storage.Storage
+	signer *storage.Signer
+	mailer mail.Mailer
+	ws     *utils.WSManager
+
+	// Background work started by handlers, waited for by Drain
+	async sync.WaitGroup
+}
+
+// Services are what a Server uses besides its repositories
+type Services struct {
+	// Media stores uploads
+	Media storage.Storage
+	// Signer signs the media URLs handed to clients
+	Signer *storage.Signer
+	// Mail sends verification and password reset emails
+	Mail mail.Mailer
+	// WS tracks the connected WebSocket clients notifications go to
+	WS *utils.WSManager
+	// Limits counts requests for the rate limited routes
+	Limits ratelimit.Store
+}
+
+// NewServices sets up media storage, URL signing and mail as cfg describes,
+// with a WebSocket manager no one is connected to yet. Rate limits are
+// counted in limits, which the caller picks because it may need the database.
+func NewServices(cfg *config.Config, limits ratelimit.Store) (Services, error) {
+	media, err := storage.New(cfg.Storage)
+	if err != nil {
+		return Services{}, fmt.Errorf("media storage: %w", err)
+	}
+	mailer, err := mail.New(cfg.Mail)
+	if err != nil {
+		return Services{}, fmt.Errorf("mail: %w", err)
+	}
+	return Services{
+		Media:  media,
+		Signer: storage.NewSigner(cfg.Storage.SigningSecret, cfg.Storage.URLTTL),
+		Mail:   mailer,
+		WS:     utils.NewWSManager(),
+		Limits: limits,
+	}, nil
+}
+
+// NewServer returns a Server using cfg, repos and services
+func NewServer(cfg *config.Config, repos *repository.Repositories, services Services) *Server {
+	return &Server{
+		cfg:           cfg,
+		users:         repos.Users,
+		posts:         repos.Posts,
+		comments:      repos.Comments,
+		likes:         repos.Likes,
+		follows:       repos.Follows,
+		tokens:        repos.AuthTokens,
+		loginFailures: repos.LoginFailures,
+		media:         repos.Media,
+		uploads:       repos.UploadSessions,
+		bans:          repos.BannedMedia,
+		limits:        services.Limits,
+		store:         services.Media,
+		signer:        services.Signer,
+		mailer:        services.Mail,
+		ws:            services.WS,
+	}
+}
+
+// rateLimit limits the routes it is used on by rules
+func (s *Server) rateLimit(rules ...ratelimit.Rule) fiber.Handler {
+	return ratelimit.Middleware(s.limits, rules...)
+}
+
+// goAsync runs fn in the background without holding up the response
+func (s *Server) goAsync(fn func()) {
+	s.async.Add(1)
+	go func() {
+		defer s.async.Done()
+		fn()
+	}()
+}
+
+// Drain waits for the background work handlers started, such as WebSocket
+// notifications, until ctx is done
+func (s *Server) Drain(ctx context.Context) error {
+	return utils.WaitContext(ctx, &s.async)
+}
+
+// CloseWebSockets tells every connected client the server is going away and
+// refuses new connections
+func (s *Server) CloseWebSockets() {
+	s.ws.CloseAll()
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Most trashed posts a single purge removes
+const trashPurgeBatchSize = 500
+
+// RestorePost handles POST /api/posts/:id/restore
+// @Summary Restore a deleted post
+// @Description Brings a post back from the trash with the comments and likes it had when it was deleted. Only the author can restore a post, and only within TRASH_RETENTION (30 days by default) of deleting it.
+// @Tags posts
+// @Produce json
+// @Param id path int true "Post ID"
+// @Success 200 {object} models.Post
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 410 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/posts/{id}/restore [post]
+func (s *Server) RestorePost(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid post ID"})
+	}
+	userID, _ := c.Locals("user_id").(int64)
+	ctx := c.UserContext()
+	post, err := s.posts.ByIDWithTrashed(ctx, uint(id))
+	if err != nil || post.UserID != uint(userID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
+	}
+	if !post.DeletedAt.Valid {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Post is not deleted"})
+	}
+	if time.Since(post.DeletedAt.Time) > s.cfg.TrashRetention {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{"error": "Post can no longer be restored"})
+	}
+
+	if err := s.posts.Restore(ctx, post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to restore post"})
+	}
+	if post, err = s.posts.ByID(ctx, post.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to restore post"})
+	}
+	s.presentPost(c, post)
+	return c.JSON(post)
+}
+
+// PurgeTrash permanently deletes posts and comments that have been in the
+// trash for longer than retention, releasing the media of purged posts.
+// It returns how many posts were purged.
+func (s *Server) PurgeTrash(ctx context.Context, retention time.Duration) (int, error) {
+	cutoff := time.Now().Add(-retention)
+	ids, err := s.posts.TrashedBefore(ctx, cutoff, trashPurgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		if err := s.posts.Purge(ctx, id); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	// Comments deleted on their own
+	return purged, s.comments.PurgeTrashed(ctx, cutoff)
+}
@@ -1,73 +1,211 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"time"
+	"io"
+	"mime/multipart"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
 	"github.com/umutdeveloper/instagram-light/backend/middleware"
 	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/tracing"
 	"github.com/umutdeveloper/instagram-light/backend/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func registerUploadRoutes(app *fiber.App) {
-	upload := app.Group("/api/upload", middleware.JWTMiddleware())
-	upload.Post("/", UploadMedia)
+func (s *Server) registerUploadRoutes(app *fiber.App) {
+	upload := app.Group("/api/upload", middleware.JWTMiddleware(s.cfg.JWTSecret))
+	upload.Post("/", s.uploadRateLimit(), s.UploadMedia)
+	s.registerUploadSessionRoutes(upload)
+}
+
+// uploadRateLimit counts the bytes each user sends, whether in one request or
+// in the chunks of an upload session
+func (s *Server) uploadRateLimit() fiber.Handler {
+	return s.rateLimit(ratelimit.Rule{
+		Policy: "upload_bytes",
+		Limit:  s.cfg.RateLimits.UploadBytes,
+		Key:    ratelimit.ByUser,
+		Cost:   ratelimit.BodySize,
+	})
 }
 
 // UploadMedia handles POST /api/upload
 // @Summary Upload media file
-// @Description Uploads a media file and returns its storage path
+// @Description Uploads a media file (JPEG, PNG, WebP, GIF, MP4 or WebM) after validating its content and returns a signed URL. Files are stored by content hash, so uploading identical bytes again reuses the stored copy. Images are stripped of metadata, oriented upright and stored as thumb, medium and full renditions; animated GIFs keep every frame. Videos are checked against size and duration limits and may come with a poster image, which is also what gets moderated. Images matching a banned image are rejected.
 // @Tags upload
 // @Accept multipart/form-data
 // @Produce json
 // @Param file formData file true "Media file to upload"
+// @Param poster formData file false "Poster image for a video"
 // @Success 200 {object} models.UploadResponse
 // @Failure 400 {object} models.ErrorResponse
-// @Failure 400 {object} models.ErrorResponse
+// @Failure 413 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/upload [post]
-func UploadMedia(c *fiber.Ctx) error {
+func (s *Server) UploadMedia(c *fiber.Ctx) error {
 	// Get file from form
 	file, err := c.FormFile("file")
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "No file uploaded"})
 	}
 
-	uploadDir := utils.GetEnv("MEDIA_PATH", "tmp/uploads/")
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to create upload directory"})
+	limits := s.cfg.Uploads
+	data, err := readUpload(file, limits.MaxSize())
+	if err != nil {
+		return mediaErrorResponse(c, err)
+	}
+	var poster []byte
+	if posterFile, err := c.FormFile("poster"); err == nil {
+		if poster, err = readUpload(posterFile, limits.MaxImageSize); err != nil {
+			return mediaErrorResponse(c, err)
+		}
 	}
+	return s.saveMedia(c, data, poster)
+}
+
+// saveMedia validates an uploaded file and its optional video poster, stores
+// them in the media backend and responds with URLs signed for the uploader
+func (s *Server) saveMedia(c *fiber.Ctx, data, poster []byte) error {
+	limits := s.cfg.Uploads
 
-	// Generate unique, sanitized filename
-	timestamp := time.Now().UnixNano()
-	originalName := file.Filename
-	ext := ""
-	if dot := len(originalName) - len(filepath.Ext(originalName)); dot > 0 {
-		ext = filepath.Ext(originalName)
+	// Validate content and use the sniffed type's extension instead of the client's
+	info, err := utils.ValidateMedia(data, limits)
+	if err != nil {
+		return mediaErrorResponse(c, err)
 	}
-	// Remove spaces and non-alphanumeric (except dot, dash, underscore) from base name
-	base := originalName[:len(originalName)-len(ext)]
-	baseSanitized := ""
-	for _, r := range base {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
-			baseSanitized += string(r)
+	var posterInfo *utils.MediaInfo
+	if poster != nil {
+		if info.IsImage() {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Only videos can have a poster image"})
 		}
+		if posterInfo, err = validatePoster(poster, limits); err != nil {
+			return mediaErrorResponse(c, err)
+		}
+	}
+
+	userID, _ := c.Locals("user_id").(int64)
+	ctx := c.UserContext()
+	media, err := s.storeUpload(ctx, data, info, uint(userID))
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+	metrics.UploadBytes.WithLabelValues(media.MediaType).Add(float64(len(data)))
+	ttl := s.signer.TTL()
+	response := models.UploadResponse{
+		MediaType:  media.MediaType,
+		Width:      media.Width,
+		Height:     media.Height,
+		DurationMs: media.DurationMs,
+		Codec:      media.Codec,
+	}
+	if poster != nil {
+		posterMedia, err := s.storeUpload(ctx, poster, posterInfo, uint(userID))
+		if err != nil {
+			return storeErrorResponse(c, err)
+		}
+		metrics.UploadBytes.WithLabelValues(posterMedia.MediaType).Add(float64(len(poster)))
+		response.PosterURL = s.signer.Sign(posterMedia.Key, uint(userID), ttl)
+	}
+
+	keys, err := storage.RenditionKeys(ctx, s.store, media.Key)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to resolve media URL"})
+	}
+	// Return URLs signed for the uploader; MediaURL is what CreatePost expects
+	response.MediaURL = s.signer.Sign(media.Key, uint(userID), ttl)
+	response.Renditions = models.MediaRenditions{
+		Thumb:  s.signer.Sign(keys.Thumb, uint(userID), ttl),
+		Medium: s.signer.Sign(keys.Medium, uint(userID), ttl),
+		Full:   s.signer.Sign(keys.Full, uint(userID), ttl),
+	}
+	return c.JSON(response)
+}
+
+// validatePoster validates the poster image of a video
+func validatePoster(poster []byte, limits utils.MediaLimits) (*utils.MediaInfo, error) {
+	info, err := utils.ValidateMedia(poster, limits)
+	if err != nil {
+		return nil, err
 	}
-	if baseSanitized == "" {
-		baseSanitized = "file"
+	if !info.IsImage() {
+		return nil, &utils.MediaError{Code: utils.MediaErrUnsupported, Message: "Poster must be an image"}
 	}
-	uniqueName := fmt.Sprintf("%d_%s%s", timestamp, baseSanitized, ext)
-	filePath := filepath.Join(uploadDir, uniqueName)
+	return info, nil
+}
+
+// storeUpload stores validated data content-addressed and records userID as
+// one of its uploaders
+func (s *Server) storeUpload(ctx context.Context, data []byte, info *utils.MediaInfo, userID uint) (_ *models.Media, err error) {
+	ctx, span := tracing.Start(ctx, "media.store", trace.WithAttributes(
+		attribute.String("media.content_type", info.ContentType), attribute.Int("media.size", len(data))))
+	defer func() { tracing.End(span, err) }()
 
-	// Save file
-	if err := c.SaveFile(file, filePath); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to save file"})
+	media, err := s.storeMedia(ctx, data, info)
+	if err != nil {
+		return nil, err
 	}
+	if err := s.media.AddOwner(ctx, media.ID, userID); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// storeErrorResponse reports a failure to store an upload; media errors mean
+// the content itself was unusable
+func storeErrorResponse(c *fiber.Ctx, err error) error {
+	var mediaErr *utils.MediaError
+	if errors.As(err, &mediaErr) {
+		return mediaErrorResponse(c, err)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to save file"})
+}
 
-	// Return the path to be used in MediaURL
-	return c.JSON(models.UploadResponse{MediaURL: filePath})
+// readUpload reads a multipart file, refusing anything larger than maxSize
+func readUpload(file *multipart.FileHeader, maxSize int64) ([]byte, error) {
+	tooLarge := &utils.MediaError{Code: utils.MediaErrTooLarge, Message: fmt.Sprintf("File exceeds the maximum size of %d bytes", maxSize)}
+	if file.Size > maxSize {
+		return nil, tooLarge
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, errors.New("Failed to read uploaded file")
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, maxSize+1))
+	if err != nil {
+		return nil, errors.New("Failed to read uploaded file")
+	}
+	if int64(len(data)) > maxSize {
+		return nil, tooLarge
+	}
+	return data, nil
+}
+
+// mediaErrorResponse maps a media validation error to an HTTP status and structured body
+func mediaErrorResponse(c *fiber.Ctx, err error) error {
+	var mediaErr *utils.MediaError
+	if !errors.As(err, &mediaErr) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: err.Error()})
+	}
+	status := fiber.StatusBadRequest
+	switch mediaErr.Code {
+	case utils.MediaErrTooLarge, utils.MediaErrTooLong:
+		status = fiber.StatusRequestEntityTooLarge
+	case utils.MediaErrUnsupported:
+		status = fiber.StatusUnsupportedMediaType
+	case utils.MediaErrBanned:
+		status = fiber.StatusUnprocessableEntity
+	}
+	return c.Status(status).JSON(models.ErrorResponse{Error: mediaErr.Message, Code: mediaErr.Code})
 }
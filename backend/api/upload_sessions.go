@@ -0,0 +1,331 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+const (
+	// Most unfinished uploads a user may have at once
+	maxOpenUploadSessions = 10
+	// Header carrying the offset a chunk starts at
+	uploadOffsetHeader = "Upload-Offset"
+	// Error code for a completed upload whose content does not match its checksum
+	uploadErrChecksum = "checksum_mismatch"
+)
+
+func (s *Server) registerUploadSessionRoutes(upload fiber.Router) {
+	upload.Post("/sessions", s.CreateUploadSession)
+	upload.Get("/sessions/:id", s.GetUploadSession)
+	upload.Put("/sessions/:id", s.uploadRateLimit(), s.PutUploadChunk)
+	upload.Post("/sessions/:id/complete", s.CompleteUploadSession)
+	upload.Delete("/sessions/:id", s.AbortUploadSession)
+}
+
+// CreateUploadSession handles POST /api/upload/sessions
+// @Summary Start a resumable upload
+// @Description Opens an upload session for a file of the given size. Chunks are then sent with PUT and the upload is finished with a checksum. Idle sessions expire.
+// @Tags upload
+// @Accept json
+// @Produce json
+// @Param session body models.CreateUploadSessionRequest true "File name and size"
+// @Success 201 {object} models.UploadSessionResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 413 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/upload/sessions [post]
+func (s *Server) CreateUploadSession(c *fiber.Ctx) error {
+	var req models.CreateUploadSessionRequest
+	if err := c.BodyParser(&req); err != nil || req.Size <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "A positive size is required"})
+	}
+	if maxSize := s.cfg.Uploads.MaxSize(); req.Size > maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("File exceeds the maximum size of %d bytes", maxSize),
+			Code:  utils.MediaErrTooLarge,
+		})
+	}
+	userID, _ := c.Locals("user_id").(int64)
+	ctx := c.UserContext()
+
+	open, err := s.uploads.CountOpen(ctx, uint(userID), time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to create upload session"})
+	}
+	if open >= maxOpenUploadSessions {
+		return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse{Error: "Too many unfinished uploads"})
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to create upload session"})
+	}
+	session := models.UploadSession{
+		ID:        hex.EncodeToString(id),
+		UserID:    uint(userID),
+		Filename:  req.Filename,
+		Size:      req.Size,
+		ExpiresAt: time.Now().Add(s.cfg.UploadSessionTTL),
+	}
+	if err := s.uploads.Create(ctx, &session); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to create upload session"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(s.uploadSessionResponse(session))
+}
+
+// GetUploadSession handles GET /api/upload/sessions/:id
+// @Summary Get upload progress
+// @Description Returns how many bytes of a resumable upload were received, so an interrupted client knows where to resume
+// @Tags upload
+// @Produce json
+// @Param id path string true "Upload session ID"
+// @Success 200 {object} models.UploadSessionResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/upload/sessions/{id} [get]
+func (s *Server) GetUploadSession(c *fiber.Ctx) error {
+	session, err := s.findUploadSession(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Upload session not found"})
+	}
+	return c.JSON(s.uploadSessionResponse(*session))
+}
+
+// PutUploadChunk handles PUT /api/upload/sessions/:id
+// @Summary Upload a chunk
+// @Description Appends the request body to a resumable upload. The Upload-Offset header must equal the number of bytes received so far; otherwise 409 is returned with the current offset.
+// @Tags upload
+// @Accept octet-stream
+// @Produce json
+// @Param id path string true "Upload session ID"
+// @Param Upload-Offset header int true "Offset of the chunk within the file"
+// @Success 200 {object} models.UploadSessionResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.UploadSessionResponse
+// @Failure 413 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/upload/sessions/{id} [put]
+func (s *Server) PutUploadChunk(c *fiber.Ctx) error {
+	session, err := s.findUploadSession(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Upload session not found"})
+	}
+	offset, err := strconv.ParseInt(c.Get(uploadOffsetHeader), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Upload-Offset header is required"})
+	}
+	chunk := c.Body()
+	if len(chunk) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Chunk is empty"})
+	}
+	if chunkSize := s.cfg.UploadChunkSize; int64(len(chunk)) > chunkSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Chunks may be at most %d bytes", chunkSize),
+			Code:  utils.MediaErrTooLarge,
+		})
+	}
+	if offset != session.Offset {
+		return c.Status(fiber.StatusConflict).JSON(s.uploadSessionResponse(*session))
+	}
+	if offset+int64(len(chunk)) > session.Size {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Chunk extends past the announced size"})
+	}
+
+	// The unique (session, offset) index lets only one of two racing requests
+	// for the same offset store its chunk
+	ctx := c.UserContext()
+	record := models.UploadChunk{SessionID: session.ID, Offset: offset, Size: int64(len(chunk))}
+	if err := s.uploads.AddChunk(ctx, &record); err != nil {
+		if current, err := s.uploads.ByID(ctx, session.ID); err == nil {
+			session = current
+		}
+		return c.Status(fiber.StatusConflict).JSON(s.uploadSessionResponse(*session))
+	}
+	key := storage.ChunkKey(session.ID, offset)
+	if err := s.store.Put(ctx, key, bytes.NewReader(chunk), int64(len(chunk)), "application/octet-stream"); err != nil {
+		s.uploads.DeleteChunk(ctx, &record)
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to save chunk"})
+	}
+
+	session.Offset = offset + int64(len(chunk))
+	session.ExpiresAt = time.Now().Add(s.cfg.UploadSessionTTL)
+	if err := s.uploads.Advance(ctx, session.ID, session.Offset, session.ExpiresAt); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to save chunk"})
+	}
+	return c.JSON(s.uploadSessionResponse(*session))
+}
+
+// CompleteUploadSession handles POST /api/upload/sessions/:id/complete
+// @Summary Finish a resumable upload
+// @Description Checks the assembled file against its SHA-256, then validates and stores it exactly like a direct upload. A video's poster image, which is what gets moderated, is sent along as the `poster` file of a multipart request with the checksum in its `sha256` field. The session is removed unless storing failed.
+// @Tags upload
+// @Accept json,mpfd
+// @Produce json
+// @Param id path string true "Upload session ID"
+// @Param checksum body models.CompleteUploadRequest true "Checksum of the whole file"
+// @Param poster formData file false "Poster image for a video"
+// @Success 200 {object} models.UploadResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 413 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/upload/sessions/{id}/complete [post]
+func (s *Server) CompleteUploadSession(c *fiber.Ctx) error {
+	session, err := s.findUploadSession(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Upload session not found"})
+	}
+	var req models.CompleteUploadRequest
+	if err := c.BodyParser(&req); err != nil || req.SHA256 == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "sha256 is required"})
+	}
+	if session.Offset != session.Size {
+		return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+			Error: fmt.Sprintf("Upload is incomplete: %d of %d bytes received", session.Offset, session.Size),
+		})
+	}
+	// A bad poster is checked before the video, so it does not cost the session
+	var poster []byte
+	if posterFile, err := c.FormFile("poster"); err == nil {
+		if poster, err = readUpload(posterFile, s.cfg.Uploads.MaxImageSize); err != nil {
+			return mediaErrorResponse(c, err)
+		}
+		if _, err := validatePoster(poster, s.cfg.Uploads); err != nil {
+			return mediaErrorResponse(c, err)
+		}
+	}
+
+	ctx := c.UserContext()
+	data, err := s.assembleUpload(ctx, session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to read uploaded chunks"})
+	}
+	sum := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), req.SHA256) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: "Checksum does not match the uploaded data", Code: uploadErrChecksum})
+	}
+
+	if err := s.saveMedia(c, data, poster); err != nil {
+		return err
+	}
+	// A rejected file cannot become valid by retrying; only storage failures keep the session
+	if c.Response().StatusCode() < fiber.StatusInternalServerError {
+		s.deleteUploadSession(ctx, session)
+	}
+	return nil
+}
+
+// AbortUploadSession handles DELETE /api/upload/sessions/:id
+// @Summary Abort a resumable upload
+// @Description Discards an upload session and the chunks received so far
+// @Tags upload
+// @Param id path string true "Upload session ID"
+// @Success 204 {string} string "No Content"
+// @Failure 404 {object} models.ErrorResponse
+// @Security BearerAuth
+// @Router /api/upload/sessions/{id} [delete]
+func (s *Server) AbortUploadSession(c *fiber.Ctx) error {
+	session, err := s.findUploadSession(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{Error: "Upload session not found"})
+	}
+	if err := s.deleteUploadSession(c.UserContext(), session); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{Error: "Failed to abort upload"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// PurgeExpiredUploadSessions deletes sessions that went idle past their expiry
+// together with their chunks, returning how many were removed
+func (s *Server) PurgeExpiredUploadSessions(ctx context.Context) (int, error) {
+	sessions, err := s.uploads.Expired(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for i := range sessions {
+		if err := s.deleteUploadSession(ctx, &sessions[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// findUploadSession loads the caller's unexpired session named in the path
+func (s *Server) findUploadSession(c *fiber.Ctx) (*models.UploadSession, error) {
+	userID, _ := c.Locals("user_id").(int64)
+	return s.uploads.Find(c.UserContext(), c.Params("id"), uint(userID), time.Now())
+}
+
+// assembleUpload reads a complete session's chunks back in order
+func (s *Server) assembleUpload(ctx context.Context, session *models.UploadSession) ([]byte, error) {
+	chunks, err := s.uploads.Chunks(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, session.Size)
+	for _, chunk := range chunks {
+		if chunk.Offset != int64(len(data)) {
+			return nil, errors.New("upload chunks are not contiguous")
+		}
+		rc, err := s.store.Open(ctx, storage.ChunkKey(session.ID, chunk.Offset))
+		if err != nil {
+			return nil, err
+		}
+		buf := bytes.NewBuffer(data)
+		_, err = buf.ReadFrom(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	if int64(len(data)) != session.Size {
+		return nil, errors.New("upload chunks do not add up to the announced size")
+	}
+	return data, nil
+}
+
+// deleteUploadSession removes a session's chunks from storage and its rows
+func (s *Server) deleteUploadSession(ctx context.Context, session *models.UploadSession) error {
+	chunks, err := s.uploads.Chunks(ctx, session.ID)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err := s.store.Delete(ctx, storage.ChunkKey(session.ID, chunk.Offset)); err != nil {
+			return err
+		}
+	}
+	return s.uploads.Delete(ctx, session.ID)
+}
+
+func (s *Server) uploadSessionResponse(session models.UploadSession) models.UploadSessionResponse {
+	return models.UploadSessionResponse{
+		ID:        session.ID,
+		Size:      session.Size,
+		Offset:    session.Offset,
+		ChunkSize: s.cfg.UploadChunkSize,
+		ExpiresAt: session.ExpiresAt,
+	}
+}
@@ -4,18 +4,16 @@ import (
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/middleware"
-	"github.com/umutdeveloper/instagram-light/backend/models"
 )
 
 // register user routes
-func RegisterUserRoutes(app *fiber.App) {
-	user := app.Group("/api/users", middleware.JWTMiddleware())
-	user.Get("/search", SearchUsers)
-	user.Get(":id", GetUserByID)
-	user.Get(":id/followers", GetFollowers)
-	user.Get(":id/following", GetFollowing)
+func (s *Server) RegisterUserRoutes(app *fiber.App) {
+	user := app.Group("/api/users", middleware.JWTMiddleware(s.cfg.JWTSecret))
+	user.Get("/search", s.SearchUsers)
+	user.Get(":id", s.GetUserByID)
+	user.Get(":id/followers", s.GetFollowers)
+	user.Get(":id/following", s.GetFollowing)
 }
 
 // SearchUsers handles GET /api/users/search?q=query
@@ -29,17 +27,13 @@ func RegisterUserRoutes(app *fiber.App) {
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/users/search [get]
-func SearchUsers(c *fiber.Ctx) error {
+func (s *Server) SearchUsers(c *fiber.Ctx) error {
 	query := c.Query("q")
 	if query == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Search query is required"})
 	}
 
-	var users []models.User
-	searchPattern := "%" + query + "%"
-	err := db.DB.Where("username LIKE ? OR email LIKE ?", searchPattern, searchPattern).
-		Limit(20).
-		Find(&users).Error
+	users, err := s.users.Search(c.UserContext(), query, 20)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to search users"})
 	}
@@ -63,19 +57,14 @@ func SearchUsers(c *fiber.Ctx) error {
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/users/{id}/followers [get]
-func GetFollowers(c *fiber.Ctx) error {
+func (s *Server) GetFollowers(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	var followers []models.User
-	err = db.DB.Table("users").
-		Select("users.*").
-		Joins("JOIN follows ON follows.follower_id = users.id").
-		Where("follows.following_id = ?", id).
-		Find(&followers).Error
+	followers, err := s.follows.Followers(c.UserContext(), uint(id))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch followers"})
 	}
@@ -99,19 +88,14 @@ func GetFollowers(c *fiber.Ctx) error {
 // @Failure 500 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/users/{id}/following [get]
-func GetFollowing(c *fiber.Ctx) error {
+func (s *Server) GetFollowing(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	var following []models.User
-	err = db.DB.Table("users").
-		Select("users.*").
-		Joins("JOIN follows ON follows.following_id = users.id").
-		Where("follows.follower_id = ?", id).
-		Find(&following).Error
+	following, err := s.follows.Following(c.UserContext(), uint(id))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch following"})
 	}
@@ -135,15 +119,15 @@ func GetFollowing(c *fiber.Ctx) error {
 // @Failure 404 {object} models.ErrorResponse
 // @Security BearerAuth
 // @Router /api/users/{id} [get]
-func GetUserByID(c *fiber.Ctx) error {
+func (s *Server) GetUserByID(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	var user models.User
-	if err := db.DB.First(&user, id).Error; err != nil {
+	user, err := s.users.ByID(c.UserContext(), uint(id))
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
 	}
 
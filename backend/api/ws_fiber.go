@@ -1,66 +1,113 @@
 package api
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
+	"github.com/umutdeveloper/instagram-light/backend/logging"
 	"github.com/umutdeveloper/instagram-light/backend/middleware"
 	"github.com/umutdeveloper/instagram-light/backend/models"
-	"github.com/umutdeveloper/instagram-light/backend/utils"
+	"github.com/umutdeveloper/instagram-light/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // RegisterWebSocketRoutes registers WebSocket routes with Fiber
-func RegisterWebSocketRoutes(app *fiber.App) {
+func (s *Server) RegisterWebSocketRoutes(app *fiber.App) {
 	// WebSocket endpoint with upgrade check middleware
 	app.Get("/ws", func(c *fiber.Ctx) error {
 		// Check if it's a WebSocket upgrade request
 		if websocket.IsWebSocketUpgrade(c) {
 			// Upgrade to WebSocket
-			return websocket.New(handleWebSocket)(c)
+			return websocket.New(s.handleWebSocket)(c)
 		}
 		return fiber.ErrUpgradeRequired
 	})
 }
 
-func handleWebSocket(c *websocket.Conn) {
+func (s *Server) handleWebSocket(c *websocket.Conn) {
+	// Entries about the connection carry the ID of the upgrade request
+	requestID, _ := c.Locals("request_id").(string)
+	ctx := logging.WithRequestID(context.Background(), requestID)
+
 	// Get Authorization header from query or initial HTTP request
 	authHeader := c.Headers("Authorization")
 	if authHeader == "" {
-		log.Println("WebSocket: No authorization header")
+		slog.WarnContext(ctx, "WebSocket rejected: no authorization header")
 		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Unauthorized"))
 		return
 	}
 
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		log.Println("WebSocket: Invalid authorization format")
+		slog.WarnContext(ctx, "WebSocket rejected: invalid authorization format")
 		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Unauthorized"))
 		return
 	}
 
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	userID, err := middleware.ParseJWTUserID(tokenString)
+	userID, err := middleware.ParseJWTUserID(tokenString, s.cfg.JWTSecret)
 	if err != nil {
-		log.Printf("WebSocket: Invalid token: %v", err)
+		slog.WarnContext(ctx, "WebSocket rejected: invalid token", "error", err)
 		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Unauthorized"))
 		return
 	}
 
-	log.Printf("WebSocket: User %s connected", userID)
+	slog.InfoContext(ctx, "WebSocket connected", "user_id", userID)
 
-	// Add connection to manager
-	utils.WSManagerInstance.AddConnection(userID, c)
-	defer utils.WSManagerInstance.RemoveConnection(userID)
+	// Add connection to manager; refused while the server shuts down
+	if !s.ws.AddConnection(userID, c) {
+		return
+	}
+	defer s.ws.RemoveConnection(userID, c)
 	defer c.Close()
+	defer slog.InfoContext(ctx, "WebSocket disconnected", "user_id", userID)
 
 	// Read messages
 	for {
 		var msg models.WSEvent
 		if err := c.ReadJSON(&msg); err != nil {
-			log.Printf("WebSocket read error: %v", err)
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.DebugContext(ctx, "WebSocket read failed", "user_id", userID, "error", err)
+			}
 			break
 		}
-		log.Printf("WebSocket received message from user %s: %+v", userID, msg)
+		slog.DebugContext(ctx, "WebSocket event received", "user_id", userID, "type", msg.Type)
+		s.handleClientEvent(userID, msg)
+	}
+}
+
+// handleClientEvent acts on events sent by clients. view_post and leave_post
+// carry a post_id and tell the server which post a user has open.
+func (s *Server) handleClientEvent(userID string, msg models.WSEvent) {
+	payload, _ := msg.Payload.(map[string]interface{})
+	postID, _ := payload["post_id"].(float64)
+	if postID <= 0 {
+		return
+	}
+	switch msg.Type {
+	case "view_post":
+		s.ws.ViewPost(userID, uint(postID))
+	case "leave_post":
+		s.ws.LeavePost(userID, uint(postID))
+	}
+}
+
+// notifyUser sends event to every connection of userID, logging the delivery
+// under the request ID ctx carries
+func (s *Server) notifyUser(ctx context.Context, userID uint, event models.WSEvent) {
+	id := fmt.Sprintf("%d", userID)
+	ctx, span := tracing.Start(ctx, "ws.deliver "+event.Type,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("ws.user_id", id), attribute.String("ws.event_type", event.Type)))
+	err := s.ws.SendToUser(id, event)
+	tracing.End(span, err)
+	if err != nil {
+		slog.WarnContext(ctx, "WebSocket event not delivered", "user_id", id, "type", event.Type, "error", err)
+		return
 	}
+	slog.DebugContext(ctx, "WebSocket event sent", "user_id", id, "type", event.Type)
 }
@@ -0,0 +1,368 @@
+// Package config loads the server's settings once at startup. Values come
+// from the environment, then the file named by CONFIG_FILE, then .env; each
+// of these only fills in what the ones before it left unset.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/umutdeveloper/instagram-light/backend/mail"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/tracing"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+// Defaults for settings that are not part of a subsystem's own package
+const (
+	DefaultPort             = "8080"
+	DefaultTokenTTL         = 72 * time.Hour
+	DefaultCORSOrigins      = "http://localhost:3000,http://127.0.0.1:3000"
+	DefaultAIServiceURL     = "http://ai-service:8000"
+	DefaultBackendURL       = "http://backend:8080"
+	DefaultTrashRetention   = 30 * 24 * time.Hour
+	DefaultMediaGCGrace     = 24 * time.Hour
+	DefaultShutdownTimeout  = 15 * time.Second
+	DefaultLogFormat        = "json"
+	DefaultAppURL           = "http://localhost:3000"
+	DefaultEmailVerifyTTL   = 48 * time.Hour
+	DefaultPasswordResetTTL = time.Hour
+)
+
+// Config holds every setting of the server
+type Config struct {
+	Port string
+	// Prefork runs one process per CPU sharing the port
+	Prefork bool
+	// LogLevel is the least severe level logged; LogFormat is "json" or "text"
+	LogLevel  slog.Level
+	LogFormat string
+	Tracing   tracing.Config
+	// ShutdownTimeout bounds how long in-flight requests and background work
+	// may take to finish once the server is told to stop
+	ShutdownTimeout time.Duration
+	DatabaseURL     string
+	// AutoMigrate applies pending migrations at startup
+	AutoMigrate bool
+	// CORSOrigins are the browser origins allowed to call the API
+	CORSOrigins []string
+
+	JWTSecret string
+	// TokenTTL is how long a login token stays valid
+	TokenTTL time.Duration
+	// Login throttles failed logins per account
+	Login utils.LoginPolicy
+	// AppURL is the web app the links in emails open
+	AppURL string
+	// How long email verification and password reset links stay valid
+	EmailVerifyTTL   time.Duration
+	PasswordResetTTL time.Duration
+
+	Mail mail.Config
+
+	AI utils.AIService
+
+	Uploads          utils.MediaLimits
+	UploadChunkSize  int64
+	UploadSessionTTL time.Duration
+
+	Storage storage.Config
+
+	RateLimits ratelimit.Config
+
+	// TrashRetention is how long deleted posts and comments can be restored
+	TrashRetention time.Duration
+	MediaGCGrace   time.Duration
+	MediaGCDryRun  bool
+	// BannedHashDistance is how many hash bits may differ for an image to
+	// match a ban
+	BannedHashDistance int
+}
+
+// Load reads the configuration files into the environment, then parses and
+// validates the settings. The error lists every problem found.
+func Load() (*Config, error) {
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		if err := godotenv.Load(file); err != nil {
+			return nil, fmt.Errorf("CONFIG_FILE: %w", err)
+		}
+	}
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+	cfg, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// FromEnv parses the settings from the environment alone, without checking
+// that required ones are set. Unparsable values fall back to their defaults
+// and are reported in the error.
+func FromEnv() (*Config, error) {
+	r := &envReader{}
+	cfg := &Config{
+		Port:            r.string("PORT", DefaultPort),
+		Prefork:         r.bool("PREFORK", true),
+		ShutdownTimeout: r.duration("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout),
+		LogLevel:        r.level("LOG_LEVEL", slog.LevelInfo),
+		LogFormat:       r.oneOf("LOG_FORMAT", DefaultLogFormat, "json", "text"),
+		Tracing: tracing.Config{
+			Exporter:    r.oneOf("TRACING_EXPORTER", tracing.ExporterNone, tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP),
+			ServiceName: r.string("OTEL_SERVICE_NAME", tracing.DefaultServiceName),
+			SampleRatio: r.ratio("TRACING_SAMPLE_RATIO", 1),
+		},
+		DatabaseURL: r.string("DATABASE_URL", ""),
+		AutoMigrate: r.bool("AUTO_MIGRATE", true),
+		CORSOrigins: r.list("CORS_ORIGINS", DefaultCORSOrigins),
+
+		JWTSecret: r.string("JWT_SECRET", ""),
+		TokenTTL:  r.duration("JWT_TTL", DefaultTokenTTL),
+		Login: utils.LoginPolicy{
+			DelayAfter:      r.intInRange("LOGIN_DELAY_AFTER", utils.DefaultLoginDelayAfter, 0, 1000),
+			Delay:           r.duration("LOGIN_DELAY", utils.DefaultLoginDelay),
+			LockoutAfter:    r.intInRange("LOGIN_LOCKOUT_AFTER", utils.DefaultLoginLockoutAfter, 1, 1000),
+			LockoutDuration: r.duration("LOGIN_LOCKOUT_DURATION", utils.DefaultLoginLockoutDuration),
+		},
+
+		AppURL:           strings.TrimSuffix(r.string("APP_URL", DefaultAppURL), "/"),
+		EmailVerifyTTL:   r.duration("EMAIL_VERIFICATION_TTL", DefaultEmailVerifyTTL),
+		PasswordResetTTL: r.duration("PASSWORD_RESET_TTL", DefaultPasswordResetTTL),
+		Mail: mail.Config{
+			Driver: r.oneOf("MAIL_DRIVER", mail.DriverLog, mail.DriverLog, mail.DriverFile, mail.DriverSMTP),
+			From:   r.string("MAIL_FROM", mail.DefaultFrom),
+			Dir:    r.string("MAIL_DIR", mail.DefaultDir),
+			SMTP: mail.SMTPConfig{
+				Host:     r.string("SMTP_HOST", ""),
+				Port:     r.intInRange("SMTP_PORT", mail.DefaultSMTPPort, 1, 65535),
+				Username: r.string("SMTP_USERNAME", ""),
+				Password: r.string("SMTP_PASSWORD", ""),
+			},
+		},
+
+		AI: utils.AIService{
+			URL:        strings.TrimSuffix(r.string("AI_SERVICE_URL", DefaultAIServiceURL), "/"),
+			BackendURL: strings.TrimSuffix(r.string("BACKEND_URL", DefaultBackendURL), "/"),
+		},
+
+		Uploads: utils.MediaLimits{
+			MaxImageSize:     r.int64("MAX_UPLOAD_SIZE", utils.DefaultMaxUploadSize),
+			MaxVideoSize:     r.int64("MAX_VIDEO_SIZE", utils.DefaultMaxVideoSize),
+			MaxVideoDuration: r.duration("MAX_VIDEO_DURATION", utils.DefaultMaxVideoDuration),
+			MaxImagePixels:   r.int64("MAX_IMAGE_PIXELS", utils.DefaultMaxImagePixels),
+		},
+		UploadChunkSize:  r.int64("UPLOAD_CHUNK_SIZE", utils.DefaultUploadChunkSize),
+		UploadSessionTTL: r.duration("UPLOAD_SESSION_TTL", utils.DefaultUploadSessionTTL),
+
+		Storage: storage.Config{
+			Driver:    r.string("STORAGE_DRIVER", "local"),
+			MediaPath: r.string("MEDIA_PATH", storage.DefaultMediaPath),
+			URLTTL:    r.duration("MEDIA_URL_TTL", storage.DefaultMediaURLTTL),
+			S3: storage.S3Config{
+				Endpoint:        r.string("S3_ENDPOINT", ""),
+				Bucket:          r.string("S3_BUCKET", ""),
+				AccessKeyID:     r.string("S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: r.string("S3_SECRET_ACCESS_KEY", ""),
+				Region:          r.string("S3_REGION", "us-east-1"),
+				UseSSL:          r.bool("S3_USE_SSL", true),
+				PublicURL:       r.string("S3_PUBLIC_URL", ""),
+				URLTTL:          r.duration("S3_URL_TTL", 15*time.Minute),
+			},
+		},
+
+		RateLimits: ratelimit.Config{
+			Store:            r.oneOf("RATE_LIMIT_STORE", ratelimit.StoreMemory, ratelimit.StoreMemory, ratelimit.StorePostgres),
+			LoginPerIP:       r.limit("RATE_LIMIT_LOGIN_IP", ratelimit.DefaultLoginPerIP),
+			LoginPerUsername: r.limit("RATE_LIMIT_LOGIN_USERNAME", ratelimit.DefaultLoginPerUsername),
+			Likes:            r.limit("RATE_LIMIT_LIKES", ratelimit.DefaultLikes),
+			Comments:         r.limit("RATE_LIMIT_COMMENTS", ratelimit.DefaultComments),
+			UploadBytes:      r.limit("RATE_LIMIT_UPLOAD_BYTES", ratelimit.DefaultUploadBytes),
+		},
+
+		TrashRetention:     r.duration("TRASH_RETENTION", DefaultTrashRetention),
+		MediaGCGrace:       r.duration("MEDIA_GC_GRACE", DefaultMediaGCGrace),
+		MediaGCDryRun:      r.bool("MEDIA_GC_DRY_RUN", false),
+		BannedHashDistance: r.intInRange("BANNED_HASH_DISTANCE", utils.DefaultBannedHashDistance, 0, 64),
+	}
+	// Media URLs are signed with the token secret unless they get their own
+	cfg.Storage.SigningSecret = r.string("MEDIA_SIGNING_SECRET", cfg.JWTSecret)
+	return cfg, errors.Join(r.errs...)
+}
+
+// Validate checks that required settings are present and consistent
+func (c *Config) Validate() error {
+	var errs []error
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: must be a port number, got %q", c.Port))
+	}
+	if c.DatabaseURL == "" {
+		errs = append(errs, errors.New("DATABASE_URL: required"))
+	}
+	if c.JWTSecret == "" {
+		errs = append(errs, errors.New("JWT_SECRET: required"))
+	}
+	for _, origin := range c.CORSOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("CORS_ORIGINS: %q is not an origin like https://example.com", origin))
+		}
+	}
+	for key, value := range map[string]string{"AI_SERVICE_URL": c.AI.URL, "BACKEND_URL": c.AI.BackendURL, "APP_URL": c.AppURL} {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s: must be an absolute URL, got %q", key, value))
+		}
+	}
+	if _, err := netmail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("MAIL_FROM: must be an address such as App <no-reply@example.com>, got %q", c.Mail.From))
+	}
+	if c.Mail.Driver == mail.DriverSMTP && c.Mail.SMTP.Host == "" {
+		errs = append(errs, errors.New("SMTP_HOST: required for the smtp mail driver"))
+	}
+	switch c.Storage.Driver {
+	case "local":
+		if c.Storage.MediaPath == "" {
+			errs = append(errs, errors.New("MEDIA_PATH: required for the local storage driver"))
+		}
+	case "s3":
+		if c.Storage.S3.Bucket == "" {
+			errs = append(errs, errors.New("S3_BUCKET: required for the s3 storage driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_DRIVER: must be local or s3, got %q", c.Storage.Driver))
+	}
+	return errors.Join(errs...)
+}
+
+// envReader parses environment variables, collecting an error for each
+// value it cannot use. Unset and empty variables take their fallback.
+type envReader struct {
+	errs []error
+}
+
+func (r *envReader) string(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (r *envReader) bool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s: must be true or false, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (r *envReader) int64(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed <= 0 {
+		r.errs = append(r.errs, fmt.Errorf("%s: must be a positive integer, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (r *envReader) intInRange(key string, fallback, min, max int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < min || parsed > max {
+		r.errs = append(r.errs, fmt.Errorf("%s: must be an integer from %d to %d, got %q", key, min, max, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (r *envReader) duration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		r.errs = append(r.errs, fmt.Errorf("%s: must be a positive duration such as 90s or 24h, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (r *envReader) oneOf(key, fallback string, allowed ...string) string {
+	value := r.string(key, fallback)
+	if !slices.Contains(allowed, value) {
+		r.errs = append(r.errs, fmt.Errorf("%s: must be one of %s, got %q", key, strings.Join(allowed, ", "), value))
+		return fallback
+	}
+	return value
+}
+
+func (r *envReader) ratio(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || parsed > 1 {
+		r.errs = append(r.errs, fmt.Errorf("%s: must be a number from 0 to 1, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (r *envReader) limit(key string, fallback ratelimit.Limit) ratelimit.Limit {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s: %w", key, err))
+		return fallback
+	}
+	return parsed
+}
+
+func (r *envReader) level(key string, fallback slog.Level) slog.Level {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(value)); err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s: must be debug, info, warn or error, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// list splits a comma-separated value, dropping empty entries
+func (r *envReader) list(key, fallback string) []string {
+	var items []string
+	for _, item := range strings.Split(r.string(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,37 +1,64 @@
 package db
 
 import (
-	"log"
-	"os"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-
-	"github.com/umutdeveloper/instagram-light/backend/models"
+	"gorm.io/gorm/logger"
 )
 
 var DB *gorm.DB
 
-func InitDB() {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		log.Fatal("DATABASE_URL environment variable not set")
-	}
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Statements slower than this are logged as warnings
+const slowQueryThreshold = 200 * time.Millisecond
+
+// InitDB connects to the database. The schema is managed by the versioned
+// migrations in db/migrations; see MigrateUp.
+//
+// Failed and slow statements are logged through slog without their
+// parameters, which may hold personal data.
+func InitDB(dsn string) error {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.NewSlogLogger(slog.Default(), logger.Config{
+			LogLevel:                  logger.Warn,
+			SlowThreshold:             slowQueryThreshold,
+			IgnoreRecordNotFoundError: true,
+			ParameterizedQueries:      true,
+		}),
+	})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 	DB = db
+	slog.Info("Database connection established")
+	return nil
+}
 
-	// AutoMigrate all main tables
-	if err := DB.AutoMigrate(
-		&models.User{},
-		&models.Post{},
-		&models.Like{},
-		&models.Follow{},
-		&models.Comment{},
-	); err != nil {
-		log.Fatalf("Failed to migrate models: %v", err)
+// Close closes the connection pool
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Ping checks that the database answers
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("database is not connected")
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
 	}
-	log.Println("Database connection established successfully")
+	return sqlDB.PingContext(ctx)
 }
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS upload_chunks;
+DROP TABLE IF EXISTS upload_sessions;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS follows;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS banned_media;
+DROP TABLE IF EXISTS media_owners;
+DROP TABLE IF EXISTS media;
+DROP TABLE IF EXISTS post_revisions;
+DROP TABLE IF EXISTS post_media;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,181 @@
+-- Tables as AutoMigrate created them, so databases from before versioned
+-- migrations can adopt this one as their baseline. Those databases may have
+-- been created before some columns existed, so columns added since are added
+-- here too before anything indexes them.
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    email TEXT NOT NULL,
+    password TEXT NOT NULL,
+    is_private BOOLEAN DEFAULT false,
+    is_moderator BOOLEAN DEFAULT false,
+    created_at TIMESTAMPTZ
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_private BOOLEAN DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_moderator BOOLEAN DEFAULT false;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS posts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    caption TEXT,
+    media_url TEXT NOT NULL,
+    media_key TEXT,
+    media_type TEXT NOT NULL DEFAULT 'image',
+    flagged BOOLEAN DEFAULT false,
+    created_at TIMESTAMPTZ,
+    edited_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    rendition_thumb TEXT,
+    rendition_medium TEXT,
+    rendition_full TEXT
+);
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS media_key TEXT;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS media_type TEXT NOT NULL DEFAULT 'image';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS rendition_thumb TEXT;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS rendition_medium TEXT;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS rendition_full TEXT;
+CREATE INDEX IF NOT EXISTS idx_posts_user_id ON posts (user_id);
+CREATE INDEX IF NOT EXISTS idx_posts_media_key ON posts (media_key);
+CREATE INDEX IF NOT EXISTS idx_posts_deleted_at ON posts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS post_media (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL,
+    position BIGINT NOT NULL,
+    media_type TEXT NOT NULL DEFAULT 'image',
+    media_url TEXT NOT NULL,
+    media_key TEXT,
+    width BIGINT,
+    height BIGINT,
+    flagged BOOLEAN DEFAULT false,
+    rendition_thumb TEXT,
+    rendition_medium TEXT,
+    rendition_full TEXT,
+    alt_text TEXT,
+    duration_ms BIGINT,
+    codec TEXT,
+    poster_url TEXT,
+    poster_key TEXT,
+    created_at TIMESTAMPTZ
+);
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS alt_text TEXT;
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS codec TEXT;
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS poster_url TEXT;
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS poster_key TEXT;
+CREATE INDEX IF NOT EXISTS idx_post_media_post_id ON post_media (post_id);
+CREATE INDEX IF NOT EXISTS idx_post_media_media_key ON post_media (media_key);
+CREATE INDEX IF NOT EXISTS idx_post_media_poster_key ON post_media (poster_key);
+
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL,
+    caption TEXT,
+    alt_texts TEXT,
+    edited_by BIGINT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_post_revisions_post_id ON post_revisions (post_id);
+
+CREATE TABLE IF NOT EXISTS media (
+    id BIGSERIAL PRIMARY KEY,
+    hash VARCHAR(64) NOT NULL,
+    storage_key TEXT NOT NULL,
+    media_type TEXT NOT NULL DEFAULT 'image',
+    content_type TEXT,
+    size BIGINT,
+    width BIGINT,
+    height BIGINT,
+    duration_ms BIGINT,
+    codec TEXT,
+    p_hash BIGINT,
+    d_hash BIGINT,
+    stored_size BIGINT,
+    ref_count BIGINT NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMPTZ,
+    moderated BOOLEAN DEFAULT false,
+    flagged BOOLEAN DEFAULT false,
+    moderation_score NUMERIC,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+ALTER TABLE media ADD COLUMN IF NOT EXISTS p_hash BIGINT;
+ALTER TABLE media ADD COLUMN IF NOT EXISTS d_hash BIGINT;
+ALTER TABLE media ADD COLUMN IF NOT EXISTS stored_size BIGINT;
+ALTER TABLE media ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_media_hash ON media (hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_media_key ON media (storage_key);
+CREATE INDEX IF NOT EXISTS idx_media_last_used_at ON media (last_used_at);
+
+CREATE TABLE IF NOT EXISTS media_owners (
+    media_id BIGINT,
+    user_id BIGINT,
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (media_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_media_owners_user_id ON media_owners (user_id);
+
+CREATE TABLE IF NOT EXISTS banned_media (
+    id BIGSERIAL PRIMARY KEY,
+    p_hash BIGINT NOT NULL,
+    d_hash BIGINT NOT NULL,
+    reason TEXT,
+    created_by BIGINT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS likes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    post_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ
+);
+ALTER TABLE likes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_likes_post_id ON likes (post_id);
+CREATE INDEX IF NOT EXISTS idx_likes_deleted_at ON likes (deleted_at);
+
+CREATE TABLE IF NOT EXISTS follows (
+    id BIGSERIAL PRIMARY KEY,
+    follower_id BIGINT NOT NULL,
+    following_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_follows_following_id ON follows (following_id);
+
+CREATE TABLE IF NOT EXISTS comments (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    text TEXT NOT NULL,
+    created_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ
+);
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments (post_id);
+CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments (user_id);
+CREATE INDEX IF NOT EXISTS idx_comments_deleted_at ON comments (deleted_at);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    filename TEXT,
+    size BIGINT NOT NULL,
+    byte_offset BIGINT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_user_id ON upload_sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires_at ON upload_sessions (expires_at);
+
+CREATE TABLE IF NOT EXISTS upload_chunks (
+    id BIGSERIAL PRIMARY KEY,
+    session_id VARCHAR(32) NOT NULL,
+    byte_offset BIGINT NOT NULL,
+    size BIGINT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_upload_chunk_offset ON upload_chunks (session_id, byte_offset);
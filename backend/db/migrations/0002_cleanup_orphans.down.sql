@@ -0,0 +1 @@
+-- Deleted orphans cannot be brought back; reverting only unrecords the migration
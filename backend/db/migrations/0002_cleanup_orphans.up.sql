@@ -0,0 +1,25 @@
+-- Remove rows whose parent no longer exists, so foreign keys can be added.
+-- Posts go first, making their children orphans the later statements remove.
+DELETE FROM posts WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = posts.user_id);
+DELETE FROM post_media WHERE NOT EXISTS (SELECT 1 FROM posts WHERE posts.id = post_media.post_id);
+DELETE FROM post_revisions WHERE NOT EXISTS (SELECT 1 FROM posts WHERE posts.id = post_revisions.post_id);
+DELETE FROM comments
+WHERE NOT EXISTS (SELECT 1 FROM posts WHERE posts.id = comments.post_id)
+   OR NOT EXISTS (SELECT 1 FROM users WHERE users.id = comments.user_id);
+DELETE FROM likes
+WHERE NOT EXISTS (SELECT 1 FROM posts WHERE posts.id = likes.post_id)
+   OR NOT EXISTS (SELECT 1 FROM users WHERE users.id = likes.user_id);
+DELETE FROM follows
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = follows.follower_id)
+   OR NOT EXISTS (SELECT 1 FROM users WHERE users.id = follows.following_id);
+
+-- Duplicates would block the unique indexes; the oldest row is kept
+DELETE FROM likes WHERE id NOT IN (SELECT MIN(id) FROM likes GROUP BY user_id, post_id);
+DELETE FROM follows WHERE id NOT IN (SELECT MIN(id) FROM follows GROUP BY follower_id, following_id);
+
+-- Media of removed carousel items is no longer referenced; recount so the
+-- garbage collector can release it
+UPDATE media SET ref_count = (
+    SELECT COUNT(*) FROM post_media
+    WHERE post_media.media_key = media.storage_key OR post_media.poster_key = media.storage_key
+);
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_follow_pair;
+DROP INDEX IF EXISTS idx_like_user_post;
+
+ALTER TABLE follows DROP CONSTRAINT IF EXISTS fk_users_followers;
+ALTER TABLE follows DROP CONSTRAINT IF EXISTS fk_users_following;
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS fk_users_likes;
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS fk_posts_likes;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS fk_users_comments;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS fk_posts_comments;
+ALTER TABLE post_revisions DROP CONSTRAINT IF EXISTS fk_posts_revisions;
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS fk_users_posts;
+ALTER TABLE post_media DROP CONSTRAINT IF EXISTS fk_posts_media;
@@ -0,0 +1,32 @@
+-- Constraints AutoMigrate created before they cascaded are replaced
+ALTER TABLE post_media DROP CONSTRAINT IF EXISTS fk_posts_media;
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS fk_users_posts;
+ALTER TABLE post_revisions DROP CONSTRAINT IF EXISTS fk_posts_revisions;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS fk_posts_comments;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS fk_users_comments;
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS fk_posts_likes;
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS fk_users_likes;
+ALTER TABLE follows DROP CONSTRAINT IF EXISTS fk_users_following;
+ALTER TABLE follows DROP CONSTRAINT IF EXISTS fk_users_followers;
+
+ALTER TABLE posts ADD CONSTRAINT fk_users_posts
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE post_media ADD CONSTRAINT fk_posts_media
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE;
+ALTER TABLE post_revisions ADD CONSTRAINT fk_posts_revisions
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE;
+ALTER TABLE comments ADD CONSTRAINT fk_posts_comments
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE;
+ALTER TABLE comments ADD CONSTRAINT fk_users_comments
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE likes ADD CONSTRAINT fk_posts_likes
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE;
+ALTER TABLE likes ADD CONSTRAINT fk_users_likes
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE follows ADD CONSTRAINT fk_users_following
+    FOREIGN KEY (follower_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE follows ADD CONSTRAINT fk_users_followers
+    FOREIGN KEY (following_id) REFERENCES users (id) ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_like_user_post ON likes (user_id, post_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_follow_pair ON follows (follower_id, following_id);
@@ -0,0 +1,20 @@
+-- Usernames and emails become unique regardless of case. Where accounts
+-- differ only by case the oldest keeps its name; the others are renamed to
+-- <name>_<id>, and their emails get a duplicate-<id>- prefix until their
+-- owners change them.
+UPDATE users SET username = LOWER(TRIM(username)) || '_' || id
+WHERE EXISTS (
+    SELECT 1 FROM users older
+    WHERE LOWER(TRIM(older.username)) = LOWER(TRIM(users.username)) AND older.id < users.id
+);
+UPDATE users SET email = 'duplicate-' || id || '-' || LOWER(TRIM(email))
+WHERE EXISTS (
+    SELECT 1 FROM users older
+    WHERE LOWER(TRIM(older.email)) = LOWER(TRIM(users.email)) AND older.id < users.id
+);
+UPDATE users SET username = LOWER(TRIM(username)), email = LOWER(TRIM(email));
+
+DROP INDEX IF EXISTS idx_users_username;
+DROP INDEX IF EXISTS idx_users_email;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower ON users (LOWER(username));
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email));
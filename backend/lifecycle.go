@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+// How much longer than the shutdown timeout the prefork parent waits at most
+// for its children to finish draining before it exits and takes them down
+const preforkShutdownMargin = 2 * time.Second
+
+// How often the prefork parent checks whether its children finished draining
+const drainPollInterval = 100 * time.Millisecond
+
+// How long exporting the last spans may take once everything else stopped
+const tracingFlushTimeout = 5 * time.Second
+
+// workers runs the periodic background jobs
+type workers struct {
+	wg sync.WaitGroup
+}
+
+// every runs job once per interval until ctx is done. A job that is running
+// when ctx ends is left to finish.
+func (w *workers) every(ctx context.Context, interval time.Duration, job func()) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				job()
+			}
+		}
+	}()
+}
+
+// wait waits for running jobs to finish until ctx is done
+func (w *workers) wait(ctx context.Context) error {
+	return utils.WaitContext(ctx, &w.wg)
+}
+
+// children records the PIDs of the prefork child processes. A child that
+// finished draining leaves a marker in dir, the directory its prefork group
+// shares, since it must not exit before the parent does.
+type children struct {
+	dir  string
+	mu   sync.Mutex
+	pids []int
+}
+
+func (c *children) add(pid int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pids = append(c.pids, pid)
+	return nil
+}
+
+// signal sends sig to every child
+func (c *children) signal(sig os.Signal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pid := range c.pids {
+		if proc, err := os.FindProcess(pid); err == nil {
+			if err := proc.Signal(sig); err != nil {
+				slog.Error("Failed to signal prefork child", "pid", pid, "error", err)
+			}
+		}
+	}
+}
+
+// drainedMarker is the file child pid leaves once it finished draining
+func (c *children) drainedMarker(pid int) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%d.drained", pid))
+}
+
+// markDrained tells the parent this child finished draining
+func (c *children) markDrained() {
+	if err := os.WriteFile(c.drainedMarker(os.Getpid()), nil, 0o600); err != nil {
+		slog.Error("Failed to report the prefork child drained", "error", err)
+	}
+}
+
+// wait waits until every child finished draining or exited, until ctx is done
+func (c *children) wait(ctx context.Context) error {
+	c.mu.Lock()
+	pending := append([]int(nil), c.pids...)
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		running := pending[:0]
+		for _, pid := range pending {
+			if !c.drained(pid) {
+				running = append(running, pid)
+			}
+		}
+		if pending = running; len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d prefork children still draining: %w", len(pending), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// drained reports whether child pid left its marker or is gone
+func (c *children) drained(pid int) bool {
+	if _, err := os.Stat(c.drainedMarker(pid)); err == nil {
+		return true
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return true
+	}
+	return errors.Is(proc.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+// lifecycle shuts the process down in order once it is told to stop
+type lifecycle struct {
+	app      *fiber.App
+	server   *api.Server
+	workers  *workers
+	children *children
+	// stopMetrics stops sharing metrics with the rest of the prefork group
+	stopMetrics func()
+	// stopTracing flushes the spans not exported yet
+	stopTracing func(context.Context) error
+	prefork     bool
+	timeout     time.Duration
+}
+
+// shutdown stops accepting work, lets in-flight work finish within the
+// shutdown timeout and closes the database pool.
+//
+// A server process closes WebSockets with a going-away code, then waits for
+// requests and the notifications they started. The prefork parent serves
+// nothing; it passes the signal on to its children and stays up until they
+// report they drained, because fiber kills every child as soon as the parent
+// or any one child exits.
+func (l *lifecycle) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	childrenCtx, cancelChildren := context.WithTimeout(context.Background(), l.timeout+preforkShutdownMargin)
+	defer cancelChildren()
+
+	preforkParent := l.prefork && !fiber.IsChild()
+	if preforkParent {
+		l.children.signal(syscall.SIGTERM)
+	} else {
+		l.server.CloseWebSockets()
+		if err := l.app.ShutdownWithContext(ctx); err != nil {
+			slog.Error("Failed to stop the HTTP server in time", "error", err)
+		}
+		if err := l.server.Drain(ctx); err != nil {
+			slog.Error("Gave up waiting for background notifications", "error", err)
+		}
+	}
+
+	if err := l.workers.wait(ctx); err != nil {
+		slog.Error("Gave up waiting for background jobs", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Failed to close the database", "error", err)
+	}
+	l.stopMetrics()
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	if err := l.stopTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	cancelFlush()
+
+	switch {
+	case preforkParent:
+		if err := l.children.wait(childrenCtx); err != nil {
+			slog.Error("Gave up waiting for prefork children", "error", err)
+		}
+	case fiber.IsChild():
+		l.children.markDrained()
+		waitForParent()
+	}
+	slog.Info("Server stopped")
+}
+
+// waitForParent blocks a prefork child until the parent exits. Exiting first
+// would make fiber kill the children that are still draining.
+func waitForParent() {
+	parent := os.Getppid()
+	for os.Getppid() == parent {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
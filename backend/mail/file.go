@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileMailer writes every message to a .eml file, for local testing
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer returns a mailer writing to dir, which it creates
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send implements Mailer
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), safeName(msg.To))
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, format(m.from, msg, now), 0o600); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Mail written", "to", msg.To, "subject", msg.Subject, "file", path)
+	return nil
+}
+
+// safeName keeps the characters of an address that are safe in a file name
+func safeName(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '@' {
+			return r
+		}
+		return '_'
+	}, address)
+}
+
+// LogMailer logs the recipient and subject of every message instead of
+// sending it. The body is left out because it holds links with single-use
+// tokens; use the file driver to follow them locally.
+type LogMailer struct {
+	from string
+}
+
+// NewLogMailer returns a mailer logging messages as sent from from
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+// Send implements Mailer
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "Mail not sent, logged instead", "from", m.from, "to", msg.To, "subject", msg.Subject)
+	return nil
+}
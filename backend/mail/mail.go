@@ -0,0 +1,92 @@
+// Package mail sends the emails of account flows such as email verification
+// and password reset, over SMTP or, for local testing, to files or the log.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Drivers mail can be sent with
+const (
+	DriverLog  = "log"
+	DriverFile = "file"
+	DriverSMTP = "smtp"
+)
+
+// Defaults for mail settings
+const (
+	DefaultFrom     = "Instagram Light <no-reply@localhost>"
+	DefaultDir      = "tmp/mail/"
+	DefaultSMTPPort = 587
+)
+
+// Message is a plain text email to one recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config selects and configures the mailer
+type Config struct {
+	// Driver is "log", "file" or "smtp"
+	Driver string
+	// From is the sender of every message
+	From string
+	// Dir is where the file driver writes messages
+	Dir  string
+	SMTP SMTPConfig
+}
+
+// SMTPConfig locates and authenticates with the SMTP server. STARTTLS is used
+// whenever the server offers it.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// New returns the mailer cfg selects
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case DriverLog:
+		return NewLogMailer(cfg.From), nil
+	case DriverFile:
+		mailer, err := NewFileMailer(cfg.Dir, cfg.From)
+		if err != nil {
+			return nil, err
+		}
+		return mailer, nil
+	case DriverSMTP:
+		return NewSMTPMailer(cfg.SMTP, cfg.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// format renders msg as an RFC 5322 message. Line breaks are dropped from
+// header values so a recipient or subject cannot add headers.
+func format(from string, msg Message, date time.Time) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", header.Replace(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
@@ -12,49 +12,219 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	fiberSwagger "github.com/swaggo/fiber-swagger"
 	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/config"
 	"github.com/umutdeveloper/instagram-light/backend/db"
 	_ "github.com/umutdeveloper/instagram-light/backend/docs"
-	"github.com/umutdeveloper/instagram-light/backend/utils"
+	"github.com/umutdeveloper/instagram-light/backend/logging"
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
+	"github.com/umutdeveloper/instagram-light/backend/middleware"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"github.com/umutdeveloper/instagram-light/backend/tracing"
 )
 
 func main() {
-	if err := utils.LoadEnv(); err != nil {
-		log.Println("No .env file found, using system environment variables.")
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	logging.Setup(cfg.LogFormat, cfg.LogLevel)
+	stopTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
+	if err := db.InitDB(cfg.DatabaseURL); err != nil {
+		fatal("Failed to connect to database", "error", err)
+	}
+	if err := errors.Join(metrics.InstrumentDB(db.DB), tracing.InstrumentDB(db.DB)); err != nil {
+		fatal("Failed to instrument database", "error", err)
+	}
+	// Migrate before prefork children start; the advisory lock keeps other
+	// hosts from migrating at the same time
+	if !fiber.IsChild() && cfg.AutoMigrate {
+		if err := db.MigrateUp(context.Background()); err != nil {
+			fatal("Failed to migrate database", "error", err)
+		}
+	}
+	repos := repository.NewGorm(db.DB)
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	var sharedLimits *ratelimit.PostgresStore
+	if cfg.RateLimits.Store == ratelimit.StorePostgres {
+		sharedLimits = ratelimit.NewPostgresStore(db.DB)
+		limits = sharedLimits
+	}
+	services, err := api.NewServices(cfg, limits)
+	if err != nil {
+		fatal("Failed to configure services", "error", err)
 	}
 
-	db.InitDB()
+	// Stop on Ctrl-C and on SIGTERM from the container runtime
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Only the prefork parent sweeps abandoned uploads, orphaned media and the
+	// trash, so each runs once per host
+	server := api.NewServer(cfg, repos, services)
+	jobs := &workers{}
+	if !fiber.IsChild() {
+		gcOptions := api.MediaGCOptions{Grace: cfg.MediaGCGrace, DryRun: cfg.MediaGCDryRun}
+		jobs.every(ctx, time.Hour, func() { purgeUploadSessions(server) })
+		jobs.every(ctx, time.Hour, func() { sweepOrphanedMedia(server, gcOptions) })
+		jobs.every(ctx, time.Hour, func() { purgeTrash(server, cfg.TrashRetention) })
+		jobs.every(ctx, time.Hour, func() { purgeAuthTokens(repos.AuthTokens) })
+		jobs.every(ctx, time.Hour, func() { purgeLoginFailures(repos.LoginFailures, cfg.Login.LockoutDuration) })
+		if sharedLimits != nil {
+			jobs.every(ctx, time.Hour, func() { purgeRateLimits(sharedLimits) })
+		}
+	}
 
 	app := fiber.New(fiber.Config{
-		Prefork: true,
+		Prefork: cfg.Prefork,
+		// Leave room for a video poster and multipart overhead on top of the
+		// largest allowed upload
+		BodyLimit: int(cfg.Uploads.MaxSize()+cfg.Uploads.MaxImageSize) + 1<<20,
 	})
 
-	app.Use(logger.New())
+	app.Use(middleware.RequestID())
+	app.Use(tracing.Middleware())
+	app.Use(middleware.RequestLogger())
+	app.Use(metrics.Middleware())
 	app.Use(recover.New())
 	app.Use(cors.New(cors.Config{
-		AllowOrigins:     "http://localhost:3000, http://127.0.0.1:3000, http://192.168.1.46:3000",
+		AllowOrigins:     strings.Join(cfg.CORSOrigins, ","),
 		AllowCredentials: true,
 	}))
 
-	api.RegisterRoutes(app)
-	api.RegisterWebSocketRoutes(app) // WebSocket now integrated with Fiber
-
-	// Serve static files from tmp/uploads directory
-	app.Static("/tmp/uploads", "./tmp/uploads")
+	server.RegisterRoutes(app)
+	server.RegisterWebSocketRoutes(app) // WebSocket now integrated with Fiber
 
 	app.Get("/swagger/*", fiberSwagger.WrapHandler)
 
-	// Start Fiber API server with WebSocket support and Prefork enabled
-	port := utils.GetEnv("PORT", "8080")
-	log.Printf("Fiber API (with WebSocket at /ws) starting on port %s with Prefork enabled", port)
-	if err := app.Listen(":" + port); err != nil {
-		log.Fatalf("Error starting Fiber server: %v", err)
+	forks := &children{dir: metrics.GroupDir()}
+	app.Hooks().OnFork(forks.add)
+
+	// Prefork processes each count what they serve; share the counts so a
+	// scrape of any of them covers the whole group
+	stopMetrics := func() {}
+	if cfg.Prefork {
+		metricsDir := metrics.GroupDir()
+		if !fiber.IsChild() {
+			if err := os.MkdirAll(metricsDir, 0o700); err != nil {
+				fatal("Failed to create metrics directory", "error", err)
+			}
+			defer os.RemoveAll(metricsDir)
+		}
+		if stopMetrics, err = metrics.Share(metricsDir); err != nil {
+			fatal("Failed to share metrics", "error", err)
+		}
+	}
+
+	// Start Fiber API server with WebSocket support
+	slog.Info("Fiber API (with WebSocket at /ws) starting", "port", cfg.Port, "prefork", cfg.Prefork)
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- app.Listen(":" + cfg.Port) }()
+
+	select {
+	case err := <-listenErr:
+		fatal("Error starting Fiber server", "error", err)
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down", "timeout", cfg.ShutdownTimeout)
+	(&lifecycle{
+		app:         app,
+		server:      server,
+		workers:     jobs,
+		children:    forks,
+		stopMetrics: stopMetrics,
+		stopTracing: stopTracing,
+		prefork:     cfg.Prefork,
+		timeout:     cfg.ShutdownTimeout,
+	}).shutdown()
+}
+
+// purgeUploadSessions removes expired resumable uploads
+func purgeUploadSessions(server *api.Server) {
+	purged, err := server.PurgeExpiredUploadSessions(context.Background())
+	if err != nil {
+		slog.Error("Failed to purge upload sessions", "error", err)
+	} else if purged > 0 {
+		slog.Info("Purged expired upload sessions", "count", purged)
 	}
 }
+
+// purgeTrash deletes posts and comments past their restore window
+func purgeTrash(server *api.Server, retention time.Duration) {
+	purged, err := server.PurgeTrash(context.Background(), retention)
+	if err != nil {
+		slog.Error("Failed to purge trash", "error", err)
+	} else if purged > 0 {
+		slog.Info("Purged deleted posts", "count", purged)
+	}
+}
+
+// purgeAuthTokens deletes expired email verification and password reset tokens
+func purgeAuthTokens(tokens repository.AuthTokens) {
+	purged, err := tokens.PurgeExpired(context.Background(), time.Now())
+	if err != nil {
+		slog.Error("Failed to purge auth tokens", "error", err)
+	} else if purged > 0 {
+		slog.Info("Purged expired auth tokens", "count", purged)
+	}
+}
+
+// purgeLoginFailures deletes failed login counts that no longer delay anyone
+func purgeLoginFailures(failures repository.LoginFailures, window time.Duration) {
+	purged, err := failures.PurgeStale(context.Background(), time.Now(), window)
+	if err != nil {
+		slog.Error("Failed to purge failed logins", "error", err)
+	} else if purged > 0 {
+		slog.Info("Purged failed logins", "count", purged)
+	}
+}
+
+// purgeRateLimits deletes rate limit windows that ended
+func purgeRateLimits(limits *ratelimit.PostgresStore) {
+	purged, err := limits.PurgeExpired(context.Background())
+	if err != nil {
+		slog.Error("Failed to purge rate limits", "error", err)
+	} else if purged > 0 {
+		slog.Info("Purged expired rate limits", "count", purged)
+	}
+}
+
+// sweepOrphanedMedia deletes media no post uses any more
+func sweepOrphanedMedia(server *api.Server, opts api.MediaGCOptions) {
+	stats, err := server.SweepOrphanedMedia(context.Background(), opts)
+	if err != nil {
+		slog.Error("Failed to sweep orphaned media", "error", err)
+	}
+	if stats.Deleted > 0 {
+		slog.Info("Media GC swept orphaned files", "deleted", stats.Deleted, "scanned", stats.Scanned,
+			"bytes_reclaimed", stats.BytesReclaimed, "dry_run", opts.DryRun)
+	}
+}
+
+// fatal logs an error that keeps the server from running and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,97 @@
+// Package metrics collects the server's Prometheus metrics and serves them,
+// summed over every process of a prefork group.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registry holds every metric of the server. It leaves out the Go runtime and
+// process collectors, whose values cannot be summed across prefork processes.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by method, route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Time taken by database statements, by operation and table.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+	DBQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Database statements that failed, by operation and table. Missing records are not errors.",
+	}, []string{"operation", "table"})
+
+	WSConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_connections",
+		Help: "Open WebSocket connections.",
+	})
+	WSMessagesSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ws_messages_sent_total",
+		Help: "WebSocket messages written to clients.",
+	})
+	WSMessagesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_messages_dropped_total",
+		Help: "WebSocket messages not delivered, because the user was offline or the write failed.",
+	}, []string{"reason"})
+
+	AIModerations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ai_moderations_total",
+		Help: "Media moderation verdicts: flagged, clean, cached for content seen before, or error.",
+	}, []string{"outcome"})
+	AIModerationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ai_moderation_duration_seconds",
+		Help:    "Time taken by calls to the AI moderation service, by outcome.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"outcome"})
+
+	AccountLockouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "account_lockouts_total",
+		Help: "Accounts locked after too many failed logins.",
+	})
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limited_requests_total",
+		Help: "Requests rejected for going over a rate limit, by policy.",
+	}, []string{"policy"})
+
+	UploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "upload_bytes_total",
+		Help: "Bytes of media accepted from uploads, by media type.",
+	}, []string{"media_type"})
+
+	MediaGCDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "media_gc_deleted_total",
+		Help: "Orphaned media files deleted by the garbage collector.",
+	})
+	MediaGCBytesReclaimed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "media_gc_reclaimed_bytes_total",
+		Help: "Bytes of storage reclaimed by the media garbage collector.",
+	})
+)
+
+// WS messages drop reasons
+const (
+	DropOffline    = "offline"
+	DropWriteError = "write_error"
+)
+
+func init() {
+	Registry.MustRegister(
+		HTTPRequests, HTTPRequestDuration,
+		DBQueryDuration, DBQueryErrors,
+		WSConnections, WSMessagesSent, WSMessagesDropped,
+		AIModerations, AIModerationDuration,
+		AccountLockouts, RateLimited,
+		UploadBytes,
+		MediaGCDeleted, MediaGCBytesReclaimed,
+	)
+}
@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// With prefork every process counts only the requests it served. Each one
+// shares its metrics on a unix socket in a directory of the prefork group,
+// and whichever process is scraped sums them all.
+
+// How long gathering may wait for another process of the group
+const peerTimeout = 2 * time.Second
+
+var (
+	shareMu sync.RWMutex
+	// Directory of the group's sockets and this process's own socket; empty
+	// unless Share was called
+	shareDir    string
+	shareSocket string
+)
+
+var (
+	protoFormat = expfmt.NewFormat(expfmt.TypeProtoDelim)
+	textFormat  = expfmt.NewFormat(expfmt.TypeTextPlain)
+)
+
+// GroupDir returns the directory the processes of this prefork group share
+// their metrics through, named after the parent process
+func GroupDir() string {
+	parent := os.Getpid()
+	if fiber.IsChild() {
+		parent = os.Getppid()
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("instagram-light-metrics-%d", parent))
+}
+
+// Share serves this process's metrics to the rest of the group on a socket in
+// dir, which must exist. The returned function stops serving.
+func Share(dir string) (func(), error) {
+	socket := filepath.Join(dir, fmt.Sprintf("%d.sock", os.Getpid()))
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := Registry.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", string(protoFormat))
+		encodeAll(w, protoFormat, families)
+	})}
+	go server.Serve(listener)
+
+	shareMu.Lock()
+	shareDir, shareSocket = dir, socket
+	shareMu.Unlock()
+	return func() {
+		shareMu.Lock()
+		shareDir, shareSocket = "", ""
+		shareMu.Unlock()
+		server.Close()
+		_ = os.Remove(socket)
+	}, nil
+}
+
+// Handler serves the metrics of every process in the group in the
+// Prometheus text format
+func Handler(c *fiber.Ctx) error {
+	families, err := Gather(c.UserContext())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	c.Set(fiber.HeaderContentType, string(textFormat))
+	return encodeAll(c, textFormat, families)
+}
+
+// Gather returns this process's metrics summed with those of the rest of the
+// group. Processes that do not answer, having exited, are left out.
+func Gather(ctx context.Context) ([]*dto.MetricFamily, error) {
+	own, err := Registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	shareMu.RLock()
+	dir, self := shareDir, shareSocket
+	shareMu.RUnlock()
+	if dir == "" {
+		return own, nil
+	}
+
+	sockets, _ := filepath.Glob(filepath.Join(dir, "*.sock"))
+	sets := [][]*dto.MetricFamily{own}
+	for _, socket := range sockets {
+		if socket == self {
+			continue
+		}
+		peer, err := gatherPeer(ctx, socket)
+		if err != nil {
+			slog.WarnContext(ctx, "Metrics: skipping unreachable process", "socket", filepath.Base(socket), "error", err)
+			continue
+		}
+		sets = append(sets, peer)
+	}
+	return merge(sets...), nil
+}
+
+// gatherPeer fetches the metrics another process shares on socket
+func gatherPeer(ctx context.Context, socket string) ([]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(ctx, peerTimeout)
+	defer cancel()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://peer/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var families []*dto.MetricFamily
+	decoder := expfmt.NewDecoder(resp.Body, protoFormat)
+	for {
+		family := &dto.MetricFamily{}
+		if err := decoder.Decode(family); errors.Is(err, io.EOF) {
+			return families, nil
+		} else if err != nil {
+			return nil, err
+		}
+		families = append(families, family)
+	}
+}
+
+func encodeAll(w io.Writer, format expfmt.Format, families []*dto.MetricFamily) error {
+	encoder := expfmt.NewEncoder(w, format)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge sums the series with the same name and labels across sets. Counters,
+// gauges and histograms are all summed; a gauge such as open connections
+// adds up across processes too.
+func merge(sets ...[]*dto.MetricFamily) []*dto.MetricFamily {
+	families := make(map[string]*dto.MetricFamily)
+	series := make(map[string]*dto.Metric)
+	for _, set := range sets {
+		for _, family := range set {
+			merged, ok := families[family.GetName()]
+			if !ok {
+				merged = &dto.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type}
+				families[family.GetName()] = merged
+			}
+			for _, metric := range family.Metric {
+				key := family.GetName() + labelKey(metric)
+				if existing, ok := series[key]; ok {
+					add(existing, metric)
+					continue
+				}
+				series[key] = metric
+				merged.Metric = append(merged.Metric, metric)
+			}
+		}
+	}
+
+	result := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		sort.Slice(family.Metric, func(i, j int) bool {
+			return labelKey(family.Metric[i]) < labelKey(family.Metric[j])
+		})
+		result = append(result, family)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].GetName() < result[j].GetName() })
+	return result
+}
+
+func labelKey(metric *dto.Metric) string {
+	var b strings.Builder
+	for _, label := range metric.Label {
+		fmt.Fprintf(&b, "{%s=%q}", label.GetName(), label.GetValue())
+	}
+	return b.String()
+}
+
+// add adds src's values to dst, a series of the same type. Histograms of the
+// same metric have the same buckets in every process.
+func add(dst, src *dto.Metric) {
+	switch {
+	case dst.Counter != nil && src.Counter != nil:
+		dst.Counter.Value = ptr(dst.Counter.GetValue() + src.Counter.GetValue())
+	case dst.Gauge != nil && src.Gauge != nil:
+		dst.Gauge.Value = ptr(dst.Gauge.GetValue() + src.Gauge.GetValue())
+	case dst.Untyped != nil && src.Untyped != nil:
+		dst.Untyped.Value = ptr(dst.Untyped.GetValue() + src.Untyped.GetValue())
+	case dst.Histogram != nil && src.Histogram != nil:
+		dst.Histogram.SampleCount = ptr(dst.Histogram.GetSampleCount() + src.Histogram.GetSampleCount())
+		dst.Histogram.SampleSum = ptr(dst.Histogram.GetSampleSum() + src.Histogram.GetSampleSum())
+		for i, bucket := range dst.Histogram.Bucket {
+			if i < len(src.Histogram.Bucket) && src.Histogram.Bucket[i].GetUpperBound() == bucket.GetUpperBound() {
+				bucket.CumulativeCount = ptr(bucket.GetCumulativeCount() + src.Histogram.Bucket[i].GetCumulativeCount())
+			}
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -2,15 +2,13 @@ package middleware
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func parseJWTClaims(tokenStr string) (jwt.MapClaims, error) {
-	secret := os.Getenv("JWT_SECRET")
+func parseJWTClaims(tokenStr, secret string) (jwt.MapClaims, error) {
 	if secret == "" {
 		return nil, fiber.ErrInternalServerError
 	}
@@ -30,14 +28,15 @@ func parseJWTClaims(tokenStr string) (jwt.MapClaims, error) {
 	return claims, nil
 }
 
-func JWTMiddleware() fiber.Handler {
+// JWTMiddleware accepts requests carrying a bearer token signed with secret
+func JWTMiddleware(secret string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid Authorization header"})
 		}
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		claims, err := parseJWTClaims(tokenStr)
+		claims, err := parseJWTClaims(tokenStr, secret)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
 		}
@@ -52,8 +51,9 @@ func JWTMiddleware() fiber.Handler {
 	}
 }
 
-func ParseJWTUserID(tokenStr string) (string, error) {
-	claims, err := parseJWTClaims(tokenStr)
+// ParseJWTUserID returns the user ID of a token signed with secret
+func ParseJWTUserID(tokenStr, secret string) (string, error) {
+	claims, err := parseJWTClaims(tokenStr, secret)
 	if err != nil {
 		return "", err
 	}
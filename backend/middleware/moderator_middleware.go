@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+)
+
+// ModeratorMiddleware only lets moderators through. It must run after
+// JWTMiddleware, and looks the flag up in users so revoking it takes effect
+// immediately.
+func ModeratorMiddleware(users repository.Users) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, _ := c.Locals("user_id").(int64)
+		user, err := users.ByID(c.UserContext(), uint(userID))
+		if err != nil || !user.IsModerator {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Moderator access required"})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/logging"
+)
+
+// Request IDs set by a proxy are kept if they are this sane
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID tags every request with an ID, reusing the one a proxy sent. The
+// ID is echoed in the response, stored in the "request_id" local and carried
+// by the request's user context into log entries and outbound calls.
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(logging.RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set(logging.RequestIDHeader, id)
+		c.Locals("request_id", id)
+		c.SetUserContext(logging.WithRequestID(c.UserContext(), id))
+		return c.Next()
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// ResponseStatus returns the status code a request handled with err is
+// answered with. Errors returned by handlers are turned into responses only
+// after every middleware has returned.
+func ResponseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// Route reported for requests no route matched, so scanners probing random
+// paths cannot grow the number of metric series or span names
+const unmatchedRoute = "unmatched"
+
+// RoutePattern returns the pattern of the route that handled a request, or
+// "unmatched" when fiber's router found none and answered with err
+func RoutePattern(c *fiber.Ctx, err error) string {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusNotFound &&
+		strings.HasPrefix(fiberErr.Message, "Cannot "+c.Method()+" ") {
+		return unmatchedRoute
+	}
+	return c.Route().Path
+}
+
+// RequestLogger logs every request once it is handled. It logs the path
+// without the query string, which may hold signed URL parameters, and no
+// headers.
+func RequestLogger() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := ResponseStatus(c, err)
+		level := slog.LevelInfo
+		if status >= fiber.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Method()),
+			slog.String("path", c.Path()),
+			slog.String("route", RoutePattern(c, err)),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("ip", c.IP()),
+			slog.String("user_agent", c.Get(fiber.HeaderUserAgent)),
+		}
+		if userID, ok := c.Locals("user_id").(int64); ok {
+			attrs = append(attrs, slog.Int64("user_id", userID))
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		slog.LogAttrs(c.UserContext(), level, "Request handled", attrs...)
+		return err
+	}
+}
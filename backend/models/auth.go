@@ -14,6 +14,31 @@ type RegisterResponse struct {
 	Message string `json:"message"`
 }
 
+// MessageResponse confirms a request that returns nothing else
+// swagger:model
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// VerifyEmailRequest carries the token mailed to confirm an address
+// swagger:model
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ForgotPasswordRequest asks for a password reset link
+// swagger:model
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest sets a new password with the token mailed for it
+// swagger:model
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 // LoginResponse represents a successful login response
 // swagger:model
 type LoginResponse struct {
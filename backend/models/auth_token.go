@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Purposes an AuthToken can be used for
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// AuthToken is a single-use token mailed to a user. Only its SHA-256 is
+// stored, so the table cannot be used to take over accounts.
+type AuthToken struct {
+	ID        uint       `gorm:"primaryKey" json:"-"`
+	UserID    uint       `gorm:"not null;index" json:"-"`
+	Purpose   string     `gorm:"size:32;not null" json:"-"`
+	Hash      string     `gorm:"column:token_hash;size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"-"`
+	UsedAt    *time.Time `json:"-"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"-"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// BannedMedia is the perceptual fingerprint of an image moderators removed.
+// Uploads within the configured Hamming distance of it are rejected.
+type BannedMedia struct {
+	ID    uint  `gorm:"primaryKey" json:"id"`
+	PHash int64 `gorm:"not null" json:"-"`
+	DHash int64 `gorm:"not null" json:"-"`
+	// Hex forms of the hashes for API clients
+	PHashHex  string    `gorm:"-" json:"phash"`
+	DHashHex  string    `gorm:"-" json:"dhash"`
+	Reason    string    `json:"reason"`
+	CreatedBy uint      `gorm:"not null" json:"created_by"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// BanMediaRequest adds an image to the banned list, either by the URL of an
+// uploaded image or by hex-encoded hashes shared from elsewhere
+// swagger:model
+type BanMediaRequest struct {
+	MediaURL string `json:"media_url"`
+	PHash    string `json:"phash"`
+	DHash    string `json:"dhash"`
+	Reason   string `json:"reason"`
+}
@@ -2,12 +2,15 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Comment struct {
-	ID        int64     `json:"id" db:"id"`
-	PostID    int64     `json:"post_id" db:"post_id"`
-	UserID    int64     `json:"user_id" db:"user_id"`
-	Text      string    `json:"text" db:"text"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	PostID    uint           `gorm:"not null;index" json:"post_id"`
+	UserID    uint           `gorm:"not null;index" json:"user_id"`
+	Text      string         `gorm:"type:text;not null" json:"text"`
+	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
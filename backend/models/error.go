@@ -3,4 +3,5 @@ package models
 // ErrorResponse represents a standard error response for the API
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
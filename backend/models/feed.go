@@ -5,15 +5,19 @@ import "time"
 // PostWithLikes represents a post with like count for the feed
 // swagger:model
 type PostWithLikes struct {
-	ID         uint      `json:"id"`
-	UserID     uint      `json:"user_id"`
-	Username   string    `json:"username"`
-	Caption    string    `json:"caption"`
-	MediaURL   string    `json:"media_url"`
-	Flagged    bool      `json:"flagged"`
-	CreatedAt  time.Time `json:"created_at"`
-	LikesCount int       `json:"likes_count"`
-	IsLiked    bool      `json:"is_liked"`
+	ID         uint            `json:"id"`
+	UserID     uint            `json:"user_id"`
+	Username   string          `json:"username"`
+	Caption    string          `json:"caption"`
+	MediaURL   string          `json:"media_url"`
+	MediaType  string          `json:"media_type"`
+	Renditions MediaRenditions `json:"renditions"`
+	Media      []PostMedia     `json:"media"`
+	Flagged    bool            `json:"flagged"`
+	CreatedAt  time.Time       `json:"created_at"`
+	EditedAt   *time.Time      `json:"edited_at"`
+	LikesCount int             `json:"likes_count"`
+	IsLiked    bool            `json:"is_liked"`
 }
 
 // FeedResponse represents the paginated feed response
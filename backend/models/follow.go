@@ -4,7 +4,7 @@ import "time"
 
 type Follow struct {
 	ID          uint      `gorm:"primaryKey"`
-	FollowerID  uint      `gorm:"not null;index"` // The user who follows
-	FollowingID uint      `gorm:"not null;index"` // The user being followed
+	FollowerID  uint      `gorm:"not null;uniqueIndex:idx_follow_pair"`       // The user who follows
+	FollowingID uint      `gorm:"not null;uniqueIndex:idx_follow_pair;index"` // The user being followed
 	CreatedAt   time.Time `gorm:"autoCreateTime"`
 }
@@ -0,0 +1,32 @@
+package models
+
+// Health statuses of the server and of its components
+const (
+	HealthOK          = "ok"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+	HealthDown        = "down"
+)
+
+// HealthResponse represents the response of the liveness check
+type HealthResponse struct {
+	Status string `json:"status" example:"ok"`
+}
+
+// ComponentHealth is the result of checking one dependency
+type ComponentHealth struct {
+	// Status is "ok" or "down"
+	Status    string  `json:"status" example:"ok"`
+	LatencyMS float64 `json:"latency_ms" example:"1.5"`
+	Error     string  `json:"error,omitempty"`
+	// Details carries component specific figures, such as connection counts
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ReadinessResponse represents the response of the readiness check. Status
+// is "ok", "degraded" when an optional component is down, or "unavailable"
+// when a required one is.
+type ReadinessResponse struct {
+	Status     string                     `json:"status" example:"ok"`
+	Components map[string]ComponentHealth `json:"components"`
+}
@@ -1,10 +1,16 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Like struct {
 	ID        uint      `gorm:"primaryKey"`
-	UserID    uint      `gorm:"not null;index"`
-	PostID    uint      `gorm:"not null;index"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_like_user_post"`
+	PostID    uint      `gorm:"not null;uniqueIndex:idx_like_user_post;index"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
+	// Set while the liked post is in the trash
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
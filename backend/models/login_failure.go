@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// LoginFailure counts the failed logins with one username or email, whether
+// or not an account has it, so throttling does not tell which accounts exist.
+// Only the SHA-256 of the normalized identifier is stored.
+type LoginFailure struct {
+	IdentifierHash string `gorm:"primaryKey;size:64" json:"-"`
+	// Failures since the last success; older ones are forgotten
+	Failures     int       `gorm:"not null;default:0" json:"-"`
+	LastFailedAt time.Time `gorm:"not null;index" json:"-"`
+	// Logins with the identifier are refused until LockedUntil
+	LockedUntil *time.Time `json:"-"`
+}
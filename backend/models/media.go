@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Media is one stored upload, keyed by the SHA-256 of its uploaded bytes so
+// identical files share storage and moderation results
+type Media struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Hash string `gorm:"size:64;not null;uniqueIndex" json:"hash"`
+	// Storage key of the full object; renditions are derived from it
+	Key         string `gorm:"column:storage_key;not null;uniqueIndex" json:"-"`
+	MediaType   string `gorm:"not null;default:image" json:"media_type"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	DurationMs  int64  `json:"duration_ms,omitempty"`
+	Codec       string `json:"codec,omitempty"`
+	// Perceptual hashes of images, compared against BannedMedia. Stored as the
+	// bits of the unsigned hash since SQL has no unsigned 64-bit integers.
+	PHash int64 `json:"-"`
+	DHash int64 `json:"-"`
+	// Bytes the stored objects (renditions included) take up in the backend
+	StoredSize int64 `json:"stored_size"`
+	// Number of post items (and video posters) that reference this media
+	RefCount int `gorm:"not null;default:0" json:"ref_count"`
+	// Last upload or reference change; unreferenced media is collected once
+	// this is older than the grace period
+	LastUsedAt time.Time `gorm:"index" json:"last_used_at"`
+	// Cached AI moderation verdict, reused for every post of the same content
+	Moderated       bool      `gorm:"default:false" json:"moderated"`
+	Flagged         bool      `gorm:"default:false" json:"flagged"`
+	ModerationScore float64   `json:"moderation_score"`
+	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// MediaOwner records that a user uploaded a media file. Several users may
+// upload the same content; each may use it until it is attached to a post.
+type MediaOwner struct {
+	MediaID   uint      `gorm:"primaryKey" json:"media_id"`
+	UserID    uint      `gorm:"primaryKey;index" json:"user_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // ToggleLikeResponse represents the response for the like toggle API
@@ -10,10 +12,26 @@ type ToggleLikeResponse struct {
 }
 
 type Post struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    uint      `gorm:"not null;index" json:"user_id"`
-	Caption   string    `gorm:"type:text" json:"caption"`
-	MediaURL  string    `gorm:"not null" json:"media_url"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	UserID   uint   `gorm:"not null;index" json:"user_id"`
+	Caption  string `gorm:"type:text" json:"caption"`
+	MediaURL string `gorm:"not null" json:"media_url"`
+	// Storage key of the upload behind MediaURL; empty for external URLs
+	MediaKey  string    `gorm:"index" json:"-"`
+	MediaType string    `gorm:"not null;default:image" json:"media_type"`
 	Flagged   bool      `gorm:"default:false" json:"flagged"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	// When the caption or alt text last changed; null for unedited posts
+	EditedAt *time.Time `json:"edited_at"`
+	// Set while the post is in the trash
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	// Sized copies of MediaURL, filled in by the server from the upload
+	Renditions MediaRenditions `gorm:"embedded;embeddedPrefix:rendition_" json:"renditions"`
+	// Carousel items; MediaURL, MediaType and Renditions mirror the first one
+	Media []PostMedia `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"media"`
+
+	// Purging a post deletes what hangs off it
+	Comments  []Comment      `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"-"`
+	Likes     []Like         `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"-"`
+	Revisions []PostRevision `gorm:"foreignKey:PostID;constraint:OnDelete:CASCADE" json:"-"`
 }
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Media types of a post item
+const (
+	MediaTypeImage = "image"
+	MediaTypeVideo = "video"
+)
+
+// PostMedia is one item of a post's media carousel, ordered by Position
+type PostMedia struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	PostID    uint   `gorm:"not null;index" json:"post_id"`
+	Position  int    `gorm:"not null" json:"position"`
+	MediaType string `gorm:"not null;default:image" json:"media_type"`
+	MediaURL  string `gorm:"not null" json:"media_url"`
+	// Storage key of the upload behind MediaURL; empty for external URLs
+	MediaKey   string          `gorm:"index" json:"-"`
+	Width      int             `json:"width"`
+	Height     int             `json:"height"`
+	Flagged    bool            `gorm:"default:false" json:"flagged"`
+	Renditions MediaRenditions `gorm:"embedded;embeddedPrefix:rendition_" json:"renditions"`
+	// Description of the image for screen readers
+	AltText string `gorm:"type:text" json:"alt_text"`
+	// Video items only: length, codec and a still shown before playback
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Codec      string    `json:"codec,omitempty"`
+	PosterURL  string    `json:"poster_url,omitempty"`
+	PosterKey  string    `gorm:"index" json:"-"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// PostRevision records what a post looked like before one of its edits
+type PostRevision struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	PostID  uint   `gorm:"not null;index" json:"post_id"`
+	Caption string `gorm:"type:text" json:"caption"`
+	// Alt text of each carousel item, by item ID
+	AltTexts  map[uint]string `gorm:"serializer:json" json:"alt_texts"`
+	EditedBy  uint            `gorm:"not null" json:"edited_by"`
+	CreatedAt time.Time       `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// PostMediaAltText sets the alt text of one carousel item
+type PostMediaAltText struct {
+	ID      uint   `json:"id"`
+	AltText string `json:"alt_text"`
+}
+
+// UpdatePostRequest edits a post; omitted fields are left unchanged
+// swagger:model
+type UpdatePostRequest struct {
+	Caption *string            `json:"caption"`
+	Media   []PostMediaAltText `json:"media"`
+}
+
+// PostUpdatedPayload is sent with post_updated WebSocket events
+type PostUpdatedPayload struct {
+	PostID   uint               `json:"post_id"`
+	Caption  string             `json:"caption"`
+	Media    []PostMediaAltText `json:"media"`
+	EditedAt time.Time          `json:"edited_at"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// RateLimit is the count of one rate limit key in its current window
+type RateLimit struct {
+	// Bucket is the policy and the client, such as "login_ip:203.0.113.7"
+	Bucket string `gorm:"primaryKey;size:255"`
+	Count  int64  `gorm:"not null"`
+	// ResetAt is when the window ends, in Unix milliseconds
+	ResetAt int64 `gorm:"not null;index"`
+}
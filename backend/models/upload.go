@@ -1,7 +1,23 @@
 package models
 
+// MediaRenditions holds the URLs of the processed sizes of an uploaded image
+// swagger:model
+type MediaRenditions struct {
+	Thumb  string `json:"thumb"`
+	Medium string `json:"medium"`
+	Full   string `json:"full"`
+}
+
 // UploadResponse represents the response for a successful upload
 // swagger:model
 type UploadResponse struct {
-	MediaURL string `json:"media_url"`
+	MediaURL   string          `json:"media_url"`
+	Renditions MediaRenditions `json:"renditions"`
+	MediaType  string          `json:"media_type"`
+	Width      int             `json:"width"`
+	Height     int             `json:"height"`
+	// Video uploads only
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Codec      string `json:"codec,omitempty"`
+	PosterURL  string `json:"poster_url,omitempty"`
 }
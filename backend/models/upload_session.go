@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// UploadSession tracks a resumable upload whose chunks are stored in the media
+// backend until it is completed, aborted or expires
+type UploadSession struct {
+	ID       string `gorm:"primaryKey;size:32" json:"id"`
+	UserID   uint   `gorm:"not null;index" json:"user_id"`
+	Filename string `json:"filename"`
+	// Total size announced when the session was created
+	Size int64 `gorm:"not null" json:"size"`
+	// Bytes received so far; the next chunk must start here
+	Offset    int64     `gorm:"column:byte_offset;not null;default:0" json:"offset"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// UploadChunk records one chunk of an upload session stored in the media backend
+type UploadChunk struct {
+	ID        uint   `gorm:"primaryKey" json:"-"`
+	SessionID string `gorm:"size:32;not null;uniqueIndex:idx_upload_chunk_offset" json:"-"`
+	Offset    int64  `gorm:"column:byte_offset;not null;uniqueIndex:idx_upload_chunk_offset" json:"-"`
+	Size      int64  `gorm:"not null" json:"-"`
+}
+
+// CreateUploadSessionRequest starts a resumable upload
+// swagger:model
+type CreateUploadSessionRequest struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// UploadSessionResponse describes the state of a resumable upload
+// swagger:model
+type UploadSessionResponse struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	ChunkSize int64     `json:"chunk_size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CompleteUploadRequest finalizes a resumable upload
+// swagger:model
+type CompleteUploadRequest struct {
+	// Hex-encoded SHA-256 of the whole file
+	SHA256 string `json:"sha256" form:"sha256"`
+}
@@ -1,13 +1,33 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
+// Usernames and emails are stored lower-cased and unique regardless of case
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Username  string    `gorm:"uniqueIndex;not null" json:"username"`
-	Email     string    `gorm:"uniqueIndex;not null" json:"email"`
-	Password  string    `gorm:"not null" json:"password"`
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Username string `gorm:"not null;index:idx_users_username_lower,unique,expression:LOWER(username)" json:"username"`
+	Email    string `gorm:"not null;index:idx_users_email_lower,unique,expression:LOWER(email)" json:"email"`
+	Password string `gorm:"not null" json:"password"`
+	// Media of private accounts is only served to their followers
+	IsPrivate bool `gorm:"default:false" json:"is_private"`
+	// Moderators manage the banned-media list
+	IsModerator bool      `gorm:"default:false" json:"is_moderator"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	// EmailVerifiedAt is set once the user follows the link mailed to them
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+
+	// Deleting a user deletes everything they created
+	Posts     []Post    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Comments  []Comment `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Likes     []Like    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Following []Follow  `gorm:"foreignKey:FollowerID;constraint:OnDelete:CASCADE" json:"-"`
+	Followers []Follow  `gorm:"foreignKey:FollowingID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// NormalizeLogin returns a username or email in the form it is stored in
+func NormalizeLogin(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
 }
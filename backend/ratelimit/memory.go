@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often the memory store drops windows that ended
+const sweepInterval = time.Minute
+
+// MemoryStore keeps counts in the process. With prefork every process counts
+// separately, so each client gets the limit once per process.
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryWindow
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+type memoryWindow struct {
+	count int64
+	reset time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{windows: make(map[string]*memoryWindow), now: time.Now}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(_ context.Context, key string, cost int64, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.After(s.nextSweep) {
+		for k, w := range s.windows {
+			if !now.Before(w.reset) {
+				delete(s.windows, k)
+			}
+		}
+		s.nextSweep = now.Add(sweepInterval)
+	}
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &memoryWindow{reset: now.Add(limit.Window)}
+		s.windows[key] = w
+	}
+	if w.count+cost > limit.Max {
+		return Result{Remaining: limit.Max - w.count, Reset: w.reset}, nil
+	}
+	w.count += cost
+	return Result{Allowed: true, Remaining: limit.Max - w.count, Reset: w.reset}, nil
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+)
+
+// Rule is one policy a route is limited by
+type Rule struct {
+	// Policy names the rule in keys, metrics and logs
+	Policy string
+	Limit  Limit
+	// Key returns the client the request is counted for; an empty key is not
+	// limited
+	Key func(c *fiber.Ctx) string
+	// Cost returns how much the request takes; nil counts one per request
+	Cost func(c *fiber.Ctx) int64
+}
+
+// ByIP counts requests per client address
+func ByIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// ByUser counts requests per authenticated user. It must run after
+// JWTMiddleware.
+func ByUser(c *fiber.Ctx) string {
+	if userID, ok := c.Locals("user_id").(int64); ok {
+		return strconv.FormatInt(userID, 10)
+	}
+	return ""
+}
+
+// BodySize costs a request the size of its body
+func BodySize(c *fiber.Ctx) int64 {
+	return int64(len(c.Body()))
+}
+
+// Middleware rejects requests over any of rules with 429 Too Many Requests
+// and a Retry-After header. Every response carries the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers of the rule closest to its
+// limit. When store fails the request is let through.
+func Middleware(store Store, rules ...Rule) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var tightest *Rule
+		var tightestResult Result
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.Limit.Enabled() {
+				continue
+			}
+			key := rule.Key(c)
+			if key == "" {
+				continue
+			}
+			cost := int64(1)
+			if rule.Cost != nil {
+				cost = rule.Cost(c)
+			}
+
+			var result Result
+			if cost > rule.Limit.Max {
+				// More than a whole window allows can never be taken
+				result = Result{Remaining: rule.Limit.Max, Reset: time.Now().Add(rule.Limit.Window)}
+			} else {
+				var err error
+				result, err = store.Take(c.UserContext(), rule.Policy+":"+key, cost, rule.Limit)
+				if err != nil {
+					slog.WarnContext(c.UserContext(), "Rate limit store failed; allowing request", "policy", rule.Policy, "error", err)
+					continue
+				}
+			}
+			if !result.Allowed {
+				metrics.RateLimited.WithLabelValues(rule.Policy).Inc()
+				slog.InfoContext(c.UserContext(), "Rate limited", "policy", rule.Policy, "cost", cost)
+				setHeaders(c, rule.Limit, result)
+				c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(secondsUntil(result.Reset), 10))
+				return c.Status(fiber.StatusTooManyRequests).JSON(models.ErrorResponse{
+					Error: "Too many requests, try again later",
+					Code:  "rate_limited",
+				})
+			}
+			if tightest == nil || float64(result.Remaining)/float64(rule.Limit.Max) < float64(tightestResult.Remaining)/float64(tightest.Limit.Max) {
+				tightest, tightestResult = rule, result
+			}
+		}
+		if tightest != nil {
+			setHeaders(c, tightest.Limit, tightestResult)
+		}
+		return c.Next()
+	}
+}
+
+func setHeaders(c *fiber.Ctx, limit Limit, result Result) {
+	c.Set("RateLimit-Limit", strconv.FormatInt(limit.Max, 10))
+	c.Set("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	c.Set("RateLimit-Reset", strconv.FormatInt(secondsUntil(result.Reset), 10))
+	c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Max, int64(limit.Window/time.Second)))
+}
+
+// secondsUntil rounds up, so a client waiting that long finds the window over
+func secondsUntil(t time.Time) int64 {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return 0
+	}
+	return int64((wait + time.Second - 1) / time.Second)
+}
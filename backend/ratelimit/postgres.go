@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"gorm.io/gorm"
+)
+
+// PostgresStore keeps counts in the rate_limits table, shared by every
+// process using the database
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresStore returns a store backed by db
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// The count is raised in one statement, so concurrent requests cannot both
+// take the last unit. The conditional update leaves a full window untouched
+// and returns no row.
+const takeSQL = `INSERT INTO rate_limits (bucket, count, reset_at) VALUES (@bucket, @cost, @reset)
+ON CONFLICT (bucket) DO UPDATE SET
+	count = CASE WHEN rate_limits.reset_at <= @now THEN excluded.count ELSE rate_limits.count + excluded.count END,
+	reset_at = CASE WHEN rate_limits.reset_at <= @now THEN excluded.reset_at ELSE rate_limits.reset_at END
+WHERE rate_limits.reset_at <= @now OR rate_limits.count + excluded.count <= @max
+RETURNING count, reset_at`
+
+// Take implements Store
+func (s *PostgresStore) Take(ctx context.Context, key string, cost int64, limit Limit) (Result, error) {
+	now := time.Now()
+	var rows []models.RateLimit
+	err := s.db.WithContext(ctx).Raw(takeSQL, map[string]interface{}{
+		"bucket": key,
+		"cost":   cost,
+		"reset":  now.Add(limit.Window).UnixMilli(),
+		"now":    now.UnixMilli(),
+		"max":    limit.Max,
+	}).Scan(&rows).Error
+	if err != nil {
+		return Result{}, err
+	}
+	if len(rows) == 1 {
+		return Result{Allowed: true, Remaining: limit.Max - rows[0].Count, Reset: time.UnixMilli(rows[0].ResetAt)}, nil
+	}
+
+	// Denied; the current window is only read to tell the client when to retry
+	var current models.RateLimit
+	if err := s.db.WithContext(ctx).Where("bucket = ?", key).First(&current).Error; err != nil {
+		return Result{}, err
+	}
+	return Result{Remaining: max(limit.Max-current.Count, 0), Reset: time.UnixMilli(current.ResetAt)}, nil
+}
+
+// PurgeExpired deletes the windows that ended
+func (s *PostgresStore) PurgeExpired(ctx context.Context) (int64, error) {
+	result := s.db.WithContext(ctx).Where("reset_at <= ?", time.Now().UnixMilli()).Delete(&models.RateLimit{})
+	return result.RowsAffected, result.Error
+}
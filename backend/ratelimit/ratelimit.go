@@ -0,0 +1,96 @@
+// Package ratelimit caps how often a client may call an endpoint. Each policy
+// counts requests, or bytes, per key in fixed windows kept in memory or in
+// Postgres.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stores counts can be kept in
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// Limit allows Max units per Window. The zero Limit allows everything.
+type Limit struct {
+	Max    int64
+	Window time.Duration
+}
+
+// Enabled reports whether the limit restricts anything
+func (l Limit) Enabled() bool {
+	return l.Max > 0 && l.Window > 0
+}
+
+// String formats l the way ParseLimit reads it
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Max, l.Window)
+}
+
+// ParseLimit reads a limit written as max/window, such as 10/15m, or "off"
+func ParseLimit(s string) (Limit, error) {
+	if s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+	maxText, windowText, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%q is not a limit such as 10/15m", s)
+	}
+	max, err := strconv.ParseInt(maxText, 10, 64)
+	if err != nil || max <= 0 {
+		return Limit{}, fmt.Errorf("%q does not start with a positive count", s)
+	}
+	window, err := time.ParseDuration(windowText)
+	if err != nil || window <= 0 {
+		return Limit{}, fmt.Errorf("%q does not end with a positive duration", s)
+	}
+	return Limit{Max: max, Window: window}, nil
+}
+
+// Default policies
+var (
+	DefaultLoginPerIP       = Limit{Max: 30, Window: 15 * time.Minute}
+	DefaultLoginPerUsername = Limit{Max: 10, Window: 15 * time.Minute}
+	DefaultLikes            = Limit{Max: 60, Window: time.Minute}
+	DefaultComments         = Limit{Max: 20, Window: time.Minute}
+	DefaultUploadBytes      = Limit{Max: 2 << 30, Window: 24 * time.Hour}
+)
+
+// Config selects the store and the limit of every policy
+type Config struct {
+	// Store is "memory", counting per process, or "postgres", shared by
+	// every process and host using the database
+	Store            string
+	LoginPerIP       Limit
+	LoginPerUsername Limit
+	// Likes and Comments are counted per user
+	Likes    Limit
+	Comments Limit
+	// UploadBytes caps the bytes each user uploads
+	UploadBytes Limit
+}
+
+// Result is the state of a key's window after a Take
+type Result struct {
+	Allowed bool
+	// Remaining is what may still be taken in the window
+	Remaining int64
+	// Reset is when the window ends and the count starts over
+	Reset time.Time
+}
+
+// Store counts what each key took in its current window
+type Store interface {
+	// Take adds cost to key's count if that keeps it within limit. A key's
+	// window starts with the first Take after the previous one ended.
+	Take(ctx context.Context, key string, cost int64, limit Limit) (Result, error)
+}
@@ -0,0 +1,682 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGorm returns repositories backed by db
+func NewGorm(db *gorm.DB) *Repositories {
+	return &Repositories{
+		Users:          &gormUsers{db: db},
+		Posts:          &gormPosts{db: db},
+		Comments:       &gormComments{db: db},
+		Likes:          &gormLikes{db: db},
+		Follows:        &gormFollows{db: db},
+		AuthTokens:     &gormAuthTokens{db: db},
+		LoginFailures:  &gormLoginFailures{db: db},
+		Media:          &gormMedia{db: db},
+		UploadSessions: &gormUploadSessions{db: db},
+		BannedMedia:    &gormBannedMedia{db: db},
+	}
+}
+
+// notFound translates GORM's missing-record error into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// WithMedia preloads a post's carousel items in display order
+func WithMedia(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Media", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("position")
+	})
+}
+
+// adjustMediaRefs changes the reference count of the media behind each
+// item's upload and poster by delta
+func adjustMediaRefs(tx *gorm.DB, items []models.PostMedia, delta int) error {
+	for _, item := range items {
+		for _, key := range []string{item.MediaKey, item.PosterKey} {
+			if key == "" {
+				continue
+			}
+			err := tx.Model(&models.Media{}).Where("storage_key = ?", key).UpdateColumns(map[string]interface{}{
+				"ref_count":    gorm.Expr("ref_count + ?", delta),
+				"last_used_at": time.Now(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r *gormUsers) Create(ctx context.Context, user *models.User) error {
+	user.Username = models.NormalizeLogin(user.Username)
+	user.Email = models.NormalizeLogin(user.Email)
+	return r.db.WithContext(ctx).Create(user).Error
+}
+
+func (r *gormUsers) ByID(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) ByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("LOWER(username) = ?", models.NormalizeLogin(username)).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) ByUsernameOrEmail(ctx context.Context, identifier string) (*models.User, error) {
+	if !strings.Contains(identifier, "@") {
+		return r.ByUsername(ctx, identifier)
+	}
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("LOWER(email) = ?", models.NormalizeLogin(identifier)).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) SetPassword(ctx context.Context, id uint, hash string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("password", hash).Error
+}
+
+func (r *gormUsers) MarkEmailVerified(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("email_verified_at", at.UTC()).Error
+}
+
+func (r *gormUsers) Search(ctx context.Context, query string, limit int) ([]models.User, error) {
+	var users []models.User
+	// Usernames and emails are stored lower-cased
+	pattern := "%" + strings.ToLower(query) + "%"
+	err := r.db.WithContext(ctx).Where("username LIKE ? OR email LIKE ?", pattern, pattern).
+		Limit(limit).
+		Find(&users).Error
+	return users, err
+}
+
+type gormPosts struct {
+	db *gorm.DB
+}
+
+func (r *gormPosts) List(ctx context.Context, limit, offset int) ([]models.Post, error) {
+	var posts []models.Post
+	err := WithMedia(r.db.WithContext(ctx)).Order("created_at DESC").Limit(limit).Offset(offset).Find(&posts).Error
+	return posts, err
+}
+
+func (r *gormPosts) ByAuthors(ctx context.Context, userIDs []uint, limit, offset int) ([]models.Post, error) {
+	var posts []models.Post
+	err := WithMedia(r.db.WithContext(ctx)).Where("user_id IN ?", userIDs).
+		Order("created_at desc").Limit(limit).Offset(offset).Find(&posts).Error
+	return posts, err
+}
+
+func (r *gormPosts) ByID(ctx context.Context, id uint) (*models.Post, error) {
+	var post models.Post
+	if err := WithMedia(r.db.WithContext(ctx)).First(&post, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &post, nil
+}
+
+func (r *gormPosts) ByIDWithTrashed(ctx context.Context, id uint) (*models.Post, error) {
+	var post models.Post
+	if err := r.db.WithContext(ctx).Unscoped().First(&post, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &post, nil
+}
+
+func (r *gormPosts) Create(ctx context.Context, post *models.Post) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(post).Error; err != nil {
+			return err
+		}
+		return adjustMediaRefs(tx, post.Media, 1)
+	})
+}
+
+func (r *gormPosts) Update(ctx context.Context, post *models.Post, revision *models.PostRevision) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(revision).Error; err != nil {
+			return err
+		}
+		for _, item := range post.Media {
+			if item.AltText == revision.AltTexts[item.ID] {
+				continue
+			}
+			if err := tx.Model(&item).UpdateColumn("alt_text", item.AltText).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(post).UpdateColumns(map[string]interface{}{"caption": post.Caption, "edited_at": post.EditedAt}).Error
+	})
+}
+
+func (r *gormPosts) Revisions(ctx context.Context, postID uint) ([]models.PostRevision, error) {
+	revisions := []models.PostRevision{}
+	err := r.db.WithContext(ctx).Where("post_id = ?", postID).Order("created_at DESC, id DESC").Find(&revisions).Error
+	return revisions, err
+}
+
+// Trash stamps the post, its comments and its likes with the same time so a
+// restore brings back exactly those
+func (r *gormPosts) Trash(ctx context.Context, id uint) error {
+	now := trashStamp()
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Post{}).Where("id = ?", id).UpdateColumn("deleted_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if err := tx.Model(&models.Comment{}).Where("post_id = ?", id).UpdateColumn("deleted_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Like{}).Where("post_id = ?", id).UpdateColumn("deleted_at", now).Error
+	})
+}
+
+func (r *gormPosts) Restore(ctx context.Context, post *models.Post) error {
+	deletedAt := post.DeletedAt.Time
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Comment{}, &models.Like{}} {
+			err := tx.Unscoped().Model(model).Where("post_id = ? AND deleted_at = ?", post.ID, deletedAt).
+				UpdateColumn("deleted_at", nil).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Model(post).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		post.DeletedAt = gorm.DeletedAt{}
+		return nil
+	})
+}
+
+func (r *gormPosts) TrashedBefore(ctx context.Context, cutoff time.Time, limit int) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.Post{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Order("deleted_at").Limit(limit).Pluck("id", &ids).Error
+	return ids, err
+}
+
+func (r *gormPosts) Purge(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var items []models.PostMedia
+		if err := tx.Where("post_id = ?", id).Find(&items).Error; err != nil {
+			return err
+		}
+		if err := adjustMediaRefs(tx, items, -1); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.PostMedia{}, &models.PostRevision{}, &models.Comment{}, &models.Like{}} {
+			if err := tx.Unscoped().Where("post_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&models.Post{}, id).Error
+	})
+}
+
+func (r *gormPosts) ItemsUsing(ctx context.Context, keys []string) ([]models.PostMedia, error) {
+	var items []models.PostMedia
+	err := r.db.WithContext(ctx).Where("media_key IN ? OR poster_key IN ?", keys, keys).Find(&items).Error
+	return items, err
+}
+
+func (r *gormPosts) UsingMedia(ctx context.Context, keys []string, legacyPath string) ([]models.Post, error) {
+	var posts []models.Post
+	err := r.db.WithContext(ctx).Where("media_key IN ?", keys).
+		Or("media_key = '' AND media_url LIKE ?", "%/"+legacyPath).
+		Find(&posts).Error
+	return posts, err
+}
+
+func (r *gormPosts) FlagUsing(ctx context.Context, keys []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var postIDs []uint
+		err := tx.Model(&models.PostMedia{}).Where("media_key IN ? OR poster_key IN ?", keys, keys).
+			Pluck("post_id", &postIDs).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.PostMedia{}).Where("media_key IN ? OR poster_key IN ?", keys, keys).
+			UpdateColumn("flagged", true).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Post{}).Where("id IN ? OR media_key IN ?", append(postIDs, 0), keys).
+			UpdateColumn("flagged", true).Error
+	})
+}
+
+type gormComments struct {
+	db *gorm.DB
+}
+
+func (r *gormComments) Create(ctx context.Context, comment *models.Comment) error {
+	return r.db.WithContext(ctx).Create(comment).Error
+}
+
+func (r *gormComments) ByID(ctx context.Context, postID, id uint) (*models.Comment, error) {
+	var comment models.Comment
+	if err := r.db.WithContext(ctx).Where("id = ? AND post_id = ?", id, postID).First(&comment).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &comment, nil
+}
+
+func (r *gormComments) ListByPost(ctx context.Context, postID uint) ([]models.Comment, error) {
+	var comments []models.Comment
+	err := r.db.WithContext(ctx).Where("post_id = ?", postID).Order("created_at asc").Find(&comments).Error
+	return comments, err
+}
+
+func (r *gormComments) Delete(ctx context.Context, comment *models.Comment) error {
+	return r.db.WithContext(ctx).Delete(comment).Error
+}
+
+func (r *gormComments) PurgeTrashed(ctx context.Context, cutoff time.Time) error {
+	return r.db.WithContext(ctx).Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Delete(&models.Comment{}).Error
+}
+
+type gormLikes struct {
+	db *gorm.DB
+}
+
+func (r *gormLikes) Create(ctx context.Context, like *models.Like) error {
+	return r.db.WithContext(ctx).Create(like).Error
+}
+
+func (r *gormLikes) Find(ctx context.Context, userID, postID uint) (*models.Like, error) {
+	var like models.Like
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND post_id = ?", userID, postID).First(&like).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &like, nil
+}
+
+// Delete is permanent: soft-deleted likes only exist while their post is in
+// the trash, and would block liking it again
+func (r *gormLikes) Delete(ctx context.Context, like *models.Like) error {
+	return r.db.WithContext(ctx).Unscoped().Delete(like).Error
+}
+
+func (r *gormLikes) Count(ctx context.Context, postID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Like{}).Where("post_id = ?", postID).Count(&count).Error
+	return count, err
+}
+
+type gormFollows struct {
+	db *gorm.DB
+}
+
+func (r *gormFollows) Followers(ctx context.Context, userID uint) ([]models.User, error) {
+	var followers []models.User
+	err := r.db.WithContext(ctx).Table("users").
+		Select("users.*").
+		Joins("JOIN follows ON follows.follower_id = users.id").
+		Where("follows.following_id = ?", userID).
+		Find(&followers).Error
+	return followers, err
+}
+
+func (r *gormFollows) Following(ctx context.Context, userID uint) ([]models.User, error) {
+	var following []models.User
+	err := r.db.WithContext(ctx).Table("users").
+		Select("users.*").
+		Joins("JOIN follows ON follows.following_id = users.id").
+		Where("follows.follower_id = ?", userID).
+		Find(&following).Error
+	return following, err
+}
+
+func (r *gormFollows) FollowingIDs(ctx context.Context, followerID uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Model(&models.Follow{}).Where("follower_id = ?", followerID).Pluck("following_id", &ids).Error
+	return ids, err
+}
+
+func (r *gormFollows) FollowersAmong(ctx context.Context, userID uint, candidateIDs []uint) ([]uint, error) {
+	var ids []uint
+	if len(candidateIDs) == 0 {
+		return ids, nil
+	}
+	err := r.db.WithContext(ctx).Model(&models.Follow{}).
+		Where("following_id = ? AND follower_id IN ?", userID, candidateIDs).
+		Pluck("follower_id", &ids).Error
+	return ids, err
+}
+
+func (r *gormFollows) IsFollowing(ctx context.Context, followerID, followingID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Follow{}).
+		Where("follower_id = ? AND following_id = ?", followerID, followingID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+type gormAuthTokens struct {
+	db *gorm.DB
+}
+
+func (r *gormAuthTokens) Create(ctx context.Context, token *models.AuthToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+func (r *gormAuthTokens) Use(ctx context.Context, hash, purpose string, at time.Time) (*models.AuthToken, error) {
+	var token models.AuthToken
+	err := r.db.WithContext(ctx).Where("token_hash = ? AND purpose = ? AND used_at IS NULL", hash, purpose).First(&token).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if !at.Before(token.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	// Only the call that flips used_at gets the token
+	result := r.db.WithContext(ctx).Model(&models.AuthToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", at.UTC())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+func (r *gormAuthTokens) DeleteForUser(ctx context.Context, userID uint, purpose string) error {
+	return r.db.WithContext(ctx).Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&models.AuthToken{}).Error
+}
+
+func (r *gormAuthTokens) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at <= ?", before.UTC()).Delete(&models.AuthToken{})
+	return result.RowsAffected, result.Error
+}
+
+type gormLoginFailures struct {
+	db *gorm.DB
+}
+
+func (r *gormLoginFailures) ByHash(ctx context.Context, hash string) (*models.LoginFailure, error) {
+	var failure models.LoginFailure
+	if err := r.db.WithContext(ctx).Where("identifier_hash = ?", hash).First(&failure).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &failure, nil
+}
+
+func (r *gormLoginFailures) Record(ctx context.Context, hash string, at time.Time, window time.Duration) (int, error) {
+	// Counted in one statement so concurrent guesses are all counted
+	at = at.UTC()
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "identifier_hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":       gorm.Expr("CASE WHEN login_failures.last_failed_at > ? THEN login_failures.failures + 1 ELSE 1 END", at.Add(-window)),
+			"last_failed_at": at,
+		}),
+	}).Create(&models.LoginFailure{IdentifierHash: hash, Failures: 1, LastFailedAt: at}).Error
+	if err != nil {
+		return 0, err
+	}
+	failure, err := r.ByHash(ctx, hash)
+	if err != nil {
+		return 0, err
+	}
+	return failure.Failures, nil
+}
+
+func (r *gormLoginFailures) LockUntil(ctx context.Context, hash string, until time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.LoginFailure{}).Where("identifier_hash = ?", hash).Update("locked_until", until.UTC()).Error
+}
+
+func (r *gormLoginFailures) Reset(ctx context.Context, hashes ...string) error {
+	return r.db.WithContext(ctx).Where("identifier_hash IN ?", hashes).Delete(&models.LoginFailure{}).Error
+}
+
+func (r *gormLoginFailures) PurgeStale(ctx context.Context, now time.Time, window time.Duration) (int64, error) {
+	now = now.UTC()
+	result := r.db.WithContext(ctx).
+		Where("last_failed_at <= ? AND (locked_until IS NULL OR locked_until <= ?)", now.Add(-window), now).
+		Delete(&models.LoginFailure{})
+	return result.RowsAffected, result.Error
+}
+
+type gormMedia struct {
+	db *gorm.DB
+}
+
+func (r *gormMedia) ByHash(ctx context.Context, hash string) (*models.Media, error) {
+	var media models.Media
+	if err := r.db.WithContext(ctx).Where("hash = ?", hash).First(&media).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &media, nil
+}
+
+func (r *gormMedia) ByKey(ctx context.Context, key string) (*models.Media, error) {
+	var media models.Media
+	if err := r.db.WithContext(ctx).Where("storage_key = ?", key).First(&media).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &media, nil
+}
+
+// Save lets identical uploads race to create the row; whichever wins
+// describes the same objects
+func (r *gormMedia) Save(ctx context.Context, media *models.Media) error {
+	upsert := clause.OnConflict{
+		Columns: []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_used_at": media.LastUsedAt,
+			"p_hash":       media.PHash,
+			"d_hash":       media.DHash,
+		}),
+	}
+	if err := r.db.WithContext(ctx).Clauses(upsert).Create(media).Error; err != nil {
+		return err
+	}
+	return r.db.WithContext(ctx).Where("hash = ?", media.Hash).First(media).Error
+}
+
+func (r *gormMedia) Touch(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.Media{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+}
+
+func (r *gormMedia) AddOwner(ctx context.Context, mediaID, userID uint) error {
+	owner := models.MediaOwner{MediaID: mediaID, UserID: userID}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&owner).Error
+}
+
+func (r *gormMedia) IsOwner(ctx context.Context, key string, userID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.MediaOwner{}).
+		Joins("JOIN media ON media.id = media_owners.media_id").
+		Where("media.storage_key = ? AND media_owners.user_id = ?", key, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *gormMedia) SetHashes(ctx context.Context, id uint, phash, dhash int64) error {
+	return r.db.WithContext(ctx).Model(&models.Media{}).Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{"p_hash": phash, "d_hash": dhash}).Error
+}
+
+func (r *gormMedia) SetModeration(ctx context.Context, id uint, flagged bool, score float64) error {
+	return r.db.WithContext(ctx).Model(&models.Media{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"moderated":        true,
+		"flagged":          flagged,
+		"moderation_score": score,
+	}).Error
+}
+
+func (r *gormMedia) HashedImages(ctx context.Context) ([]models.Media, error) {
+	var hashed []models.Media
+	err := r.db.WithContext(ctx).Where("media_type = ? AND (p_hash <> 0 OR d_hash <> 0)", models.MediaTypeImage).Find(&hashed).Error
+	return hashed, err
+}
+
+func (r *gormMedia) Flag(ctx context.Context, ids []uint) error {
+	return r.db.WithContext(ctx).Model(&models.Media{}).Where("id IN ?", ids).
+		UpdateColumns(map[string]interface{}{"moderated": true, "flagged": true}).Error
+}
+
+func (r *gormMedia) Orphaned(ctx context.Context, cutoff time.Time, limit int) ([]models.Media, error) {
+	var candidates []models.Media
+	err := r.db.WithContext(ctx).Where("ref_count <= 0 AND last_used_at < ?", cutoff).
+		Order("last_used_at").Limit(limit).Find(&candidates).Error
+	return candidates, err
+}
+
+func (r *gormMedia) CountRefs(ctx context.Context, key string) (int, error) {
+	var items, posts int64
+	db := r.db.WithContext(ctx)
+	if err := db.Model(&models.PostMedia{}).Where("media_key = ? OR poster_key = ?", key, key).Count(&items).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Model(&models.Post{}).Where("media_key = ?", key).Count(&posts).Error; err != nil {
+		return 0, err
+	}
+	// Current posts repeat their cover item's key, so the larger count is the
+	// number of distinct uses
+	return int(max(items, posts)), nil
+}
+
+func (r *gormMedia) SetRefCount(ctx context.Context, id uint, refs int) error {
+	return r.db.WithContext(ctx).Model(&models.Media{}).Where("id = ?", id).UpdateColumn("ref_count", refs).Error
+}
+
+func (r *gormMedia) DeleteOrphan(ctx context.Context, id uint, cutoff time.Time) (bool, error) {
+	deleted := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND ref_count <= 0 AND last_used_at < ?", id, cutoff).Delete(&models.Media{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		deleted = true
+		return tx.Where("media_id = ?", id).Delete(&models.MediaOwner{}).Error
+	})
+	return deleted && err == nil, err
+}
+
+type gormUploadSessions struct {
+	db *gorm.DB
+}
+
+func (r *gormUploadSessions) Create(ctx context.Context, session *models.UploadSession) error {
+	return r.db.WithContext(ctx).Create(session).Error
+}
+
+func (r *gormUploadSessions) CountOpen(ctx context.Context, userID uint, now time.Time) (int64, error) {
+	var open int64
+	err := r.db.WithContext(ctx).Model(&models.UploadSession{}).
+		Where("user_id = ? AND expires_at > ?", userID, now).Count(&open).Error
+	return open, err
+}
+
+func (r *gormUploadSessions) Find(ctx context.Context, id string, userID uint, now time.Time) (*models.UploadSession, error) {
+	var session models.UploadSession
+	err := r.db.WithContext(ctx).Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, now).First(&session).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &session, nil
+}
+
+func (r *gormUploadSessions) ByID(ctx context.Context, id string) (*models.UploadSession, error) {
+	var session models.UploadSession
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &session, nil
+}
+
+func (r *gormUploadSessions) Expired(ctx context.Context, now time.Time) ([]models.UploadSession, error) {
+	var sessions []models.UploadSession
+	err := r.db.WithContext(ctx).Where("expires_at <= ?", now).Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *gormUploadSessions) AddChunk(ctx context.Context, chunk *models.UploadChunk) error {
+	return r.db.WithContext(ctx).Create(chunk).Error
+}
+
+func (r *gormUploadSessions) DeleteChunk(ctx context.Context, chunk *models.UploadChunk) error {
+	return r.db.WithContext(ctx).Delete(chunk).Error
+}
+
+func (r *gormUploadSessions) Chunks(ctx context.Context, sessionID string) ([]models.UploadChunk, error) {
+	var chunks []models.UploadChunk
+	err := r.db.WithContext(ctx).Where("session_id = ?", sessionID).Order("byte_offset").Find(&chunks).Error
+	return chunks, err
+}
+
+func (r *gormUploadSessions) Advance(ctx context.Context, id string, offset int64, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.UploadSession{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"byte_offset": offset, "expires_at": expiresAt}).Error
+}
+
+func (r *gormUploadSessions) Delete(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", id).Delete(&models.UploadChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&models.UploadSession{}).Error
+	})
+}
+
+type gormBannedMedia struct {
+	db *gorm.DB
+}
+
+func (r *gormBannedMedia) List(ctx context.Context) ([]models.BannedMedia, error) {
+	var banned []models.BannedMedia
+	err := r.db.WithContext(ctx).Order("created_at DESC, id DESC").Find(&banned).Error
+	return banned, err
+}
+
+func (r *gormBannedMedia) Create(ctx context.Context, banned *models.BannedMedia) error {
+	return r.db.WithContext(ctx).Create(banned).Error
+}
+
+func (r *gormBannedMedia) Delete(ctx context.Context, id uint) error {
+	res := r.db.WithContext(ctx).Delete(&models.BannedMedia{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// Package repository is the storage layer the HTTP handlers read and write
+// through. The interfaces let handlers run against any implementation; the
+// one used in production is backed by GORM.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/umutdeveloper/instagram-light/backend/models"
+)
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// Users stores accounts
+type Users interface {
+	Create(ctx context.Context, user *models.User) error
+	ByID(ctx context.Context, id uint) (*models.User, error)
+	// ByUsername and ByUsernameOrEmail ignore case; an identifier with an @
+	// is taken for an email
+	ByUsername(ctx context.Context, username string) (*models.User, error)
+	ByUsernameOrEmail(ctx context.Context, identifier string) (*models.User, error)
+	// SetPassword replaces the user's password hash
+	SetPassword(ctx context.Context, id uint, hash string) error
+	// MarkEmailVerified records that the user confirmed their email at at
+	MarkEmailVerified(ctx context.Context, id uint, at time.Time) error
+	// Search matches query anywhere in the username or email
+	Search(ctx context.Context, query string, limit int) ([]models.User, error)
+}
+
+// Posts stores posts with their carousel items and edit history. Trashed
+// posts are hidden from every method but ByIDWithTrashed and Restore.
+type Posts interface {
+	// List returns posts newest first, with media
+	List(ctx context.Context, limit, offset int) ([]models.Post, error)
+	// ByAuthors returns the posts of any of userIDs newest first, with media
+	ByAuthors(ctx context.Context, userIDs []uint, limit, offset int) ([]models.Post, error)
+	// ByID returns a post with its media
+	ByID(ctx context.Context, id uint) (*models.Post, error)
+	// ByIDWithTrashed returns a post, without media, even if it is in the trash
+	ByIDWithTrashed(ctx context.Context, id uint) (*models.Post, error)
+	// Create stores a post with its media and takes a reference on every
+	// upload it uses
+	Create(ctx context.Context, post *models.Post) error
+	// Update saves a post's caption, edit time and alt texts together with
+	// the revision holding their previous values
+	Update(ctx context.Context, post *models.Post, revision *models.PostRevision) error
+	// Revisions returns a post's edit history, newest first
+	Revisions(ctx context.Context, postID uint) ([]models.PostRevision, error)
+	// Trash moves a post to the trash together with its comments and likes
+	Trash(ctx context.Context, id uint) error
+	// Restore brings a post back with the comments and likes trashed with it
+	Restore(ctx context.Context, post *models.Post) error
+	// TrashedBefore returns the IDs of up to limit posts trashed before
+	// cutoff, longest trashed first
+	TrashedBefore(ctx context.Context, cutoff time.Time, limit int) ([]uint, error)
+	// Purge deletes a post for good with everything that belonged to it and
+	// releases the uploads it used
+	Purge(ctx context.Context, id uint) error
+	// ItemsUsing returns the carousel items, trashed or not, showing any of
+	// keys as their media or poster
+	ItemsUsing(ctx context.Context, keys []string) ([]models.PostMedia, error)
+	// UsingMedia returns the posts whose own media key is one of keys or,
+	// for posts from before content addressing, whose media URL ends in
+	// legacyPath
+	UsingMedia(ctx context.Context, keys []string, legacyPath string) ([]models.Post, error)
+	// FlagUsing flags the carousel items and posts using any of keys
+	FlagUsing(ctx context.Context, keys []string) error
+}
+
+// Comments stores comments on posts
+type Comments interface {
+	Create(ctx context.Context, comment *models.Comment) error
+	// ByID returns a comment of a post
+	ByID(ctx context.Context, postID, id uint) (*models.Comment, error)
+	// ListByPost returns a post's comments oldest first
+	ListByPost(ctx context.Context, postID uint) ([]models.Comment, error)
+	// Delete moves a comment to the trash
+	Delete(ctx context.Context, comment *models.Comment) error
+	// PurgeTrashed deletes comments trashed before cutoff for good
+	PurgeTrashed(ctx context.Context, cutoff time.Time) error
+}
+
+// Likes stores likes on posts
+type Likes interface {
+	Create(ctx context.Context, like *models.Like) error
+	// Find returns userID's like of a post
+	Find(ctx context.Context, userID, postID uint) (*models.Like, error)
+	// Delete removes a like for good
+	Delete(ctx context.Context, like *models.Like) error
+	Count(ctx context.Context, postID uint) (int64, error)
+}
+
+// Follows stores who follows whom
+type Follows interface {
+	// Followers returns the users following userID
+	Followers(ctx context.Context, userID uint) ([]models.User, error)
+	// Following returns the users userID follows
+	Following(ctx context.Context, userID uint) ([]models.User, error)
+	// FollowingIDs returns the IDs of the users followerID follows
+	FollowingIDs(ctx context.Context, followerID uint) ([]uint, error)
+	// FollowersAmong returns which of candidateIDs follow userID
+	FollowersAmong(ctx context.Context, userID uint, candidateIDs []uint) ([]uint, error)
+	IsFollowing(ctx context.Context, followerID, followingID uint) (bool, error)
+}
+
+// AuthTokens stores the single-use tokens mailed to users, by hash
+type AuthTokens interface {
+	Create(ctx context.Context, token *models.AuthToken) error
+	// Use marks the unused, unexpired token with hash and purpose used at at
+	// and returns it. Of concurrent calls for one token only one succeeds;
+	// the others, like calls for unknown tokens, get ErrNotFound.
+	Use(ctx context.Context, hash, purpose string, at time.Time) (*models.AuthToken, error)
+	// DeleteForUser deletes the user's tokens for purpose
+	DeleteForUser(ctx context.Context, userID uint, purpose string) error
+	// PurgeExpired deletes tokens that expired before before
+	PurgeExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Media stores the rows describing content-addressed uploads and who
+// uploaded them
+type Media interface {
+	// ByHash returns the media whose uploaded bytes have the SHA-256 hash
+	ByHash(ctx context.Context, hash string) (*models.Media, error)
+	// ByKey returns the media stored under a storage key
+	ByKey(ctx context.Context, key string) (*models.Media, error)
+	// Save creates the row for media's hash, or refreshes the use time and
+	// hashes of the row a racing upload created, and loads the stored row
+	// into media
+	Save(ctx context.Context, media *models.Media) error
+	// Touch records that media was uploaded again at at
+	Touch(ctx context.Context, id uint, at time.Time) error
+	// AddOwner records that userID uploaded media; recording it twice is
+	// harmless
+	AddOwner(ctx context.Context, mediaID, userID uint) error
+	// IsOwner reports whether userID uploaded the media stored under key
+	IsOwner(ctx context.Context, key string, userID uint) (bool, error)
+	// SetHashes stores the perceptual hashes of an image
+	SetHashes(ctx context.Context, id uint, phash, dhash int64) error
+	// SetModeration caches the AI moderation verdict of media
+	SetModeration(ctx context.Context, id uint, flagged bool, score float64) error
+	// HashedImages returns every image with perceptual hashes
+	HashedImages(ctx context.Context) ([]models.Media, error)
+	// Flag marks media as moderated and flagged
+	Flag(ctx context.Context, ids []uint) error
+	// Orphaned returns up to limit media nothing references and nobody used
+	// since cutoff, least recently used first
+	Orphaned(ctx context.Context, cutoff time.Time, limit int) ([]models.Media, error)
+	// CountRefs counts the distinct posts and carousel items using key
+	CountRefs(ctx context.Context, key string) (int, error)
+	// SetRefCount corrects the reference count of media
+	SetRefCount(ctx context.Context, id uint, refs int) error
+	// DeleteOrphan deletes media and its owners if nothing references it and
+	// nobody used it since cutoff, reporting whether it did
+	DeleteOrphan(ctx context.Context, id uint, cutoff time.Time) (bool, error)
+}
+
+// UploadSessions stores resumable uploads and the chunks received for them
+type UploadSessions interface {
+	Create(ctx context.Context, session *models.UploadSession) error
+	// CountOpen counts the user's sessions that have not expired at now
+	CountOpen(ctx context.Context, userID uint, now time.Time) (int64, error)
+	// Find returns the user's session with id unless it expired at now
+	Find(ctx context.Context, id string, userID uint, now time.Time) (*models.UploadSession, error)
+	ByID(ctx context.Context, id string) (*models.UploadSession, error)
+	// Expired returns the sessions that expired at now
+	Expired(ctx context.Context, now time.Time) ([]models.UploadSession, error)
+	// AddChunk records a chunk. Only one chunk can be recorded at each offset
+	// of a session; later ones fail.
+	AddChunk(ctx context.Context, chunk *models.UploadChunk) error
+	DeleteChunk(ctx context.Context, chunk *models.UploadChunk) error
+	// Chunks returns a session's chunks ordered by offset
+	Chunks(ctx context.Context, sessionID string) ([]models.UploadChunk, error)
+	// Advance records how many bytes a session received and when it expires
+	Advance(ctx context.Context, id string, offset int64, expiresAt time.Time) error
+	// Delete removes a session together with its chunk records
+	Delete(ctx context.Context, id string) error
+}
+
+// BannedMedia stores the perceptual hashes of images moderators banned
+type BannedMedia interface {
+	// List returns every ban, newest first
+	List(ctx context.Context) ([]models.BannedMedia, error)
+	Create(ctx context.Context, banned *models.BannedMedia) error
+	// Delete removes the ban with id, or returns ErrNotFound
+	Delete(ctx context.Context, id uint) error
+}
+
+// LoginFailures counts failed logins per hashed username or email, whether
+// or not an account has it
+type LoginFailures interface {
+	// ByHash returns the failures counted for the identifier with hash
+	ByHash(ctx context.Context, hash string) (*models.LoginFailure, error)
+	// Record counts a failed login at at and returns the failures so far,
+	// forgetting those more than window before it
+	Record(ctx context.Context, hash string, at time.Time, window time.Duration) (int, error)
+	// LockUntil refuses logins with the identifier until until
+	LockUntil(ctx context.Context, hash string, until time.Time) error
+	// Reset clears the failures and any lock of the identifiers with hashes
+	Reset(ctx context.Context, hashes ...string) error
+	// PurgeStale deletes the counts whose last failure is more than window
+	// before now and that are not locked past now
+	PurgeStale(ctx context.Context, now time.Time, window time.Duration) (int64, error)
+}
+
+// Repositories bundles one implementation of each repository
+type Repositories struct {
+	Users          Users
+	Posts          Posts
+	Comments       Comments
+	Likes          Likes
+	Follows        Follows
+	AuthTokens     AuthTokens
+	LoginFailures  LoginFailures
+	Media          Media
+	UploadSessions UploadSessions
+	BannedMedia    BannedMedia
+}
+
+// trashStamp is the deletion time given to a trashed post and everything
+// trashed with it. It is truncated to what every database keeps, so the
+// stamps still compare equal after a round trip.
+func trashStamp() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaURLPrefix is the API route media is served from
+const MediaURLPrefix = "media"
+
+var (
+	ErrInvalidSignature = errors.New("storage: invalid media URL signature")
+	ErrExpiredURL       = errors.New("storage: media URL has expired")
+)
+
+// DefaultMediaURLTTL is how long signed media URLs stay valid by default
+const DefaultMediaURLTTL = time.Hour
+
+// Signer signs media URLs for viewers and verifies them when they are fetched
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSigner returns a Signer using secret whose URLs stay valid for ttl, or
+// DefaultMediaURLTTL when ttl is not positive. Without a secret no URL
+// verifies.
+func NewSigner(secret string, ttl time.Duration) *Signer {
+	if ttl <= 0 {
+		ttl = DefaultMediaURLTTL
+	}
+	return &Signer{secret: []byte(secret), ttl: ttl}
+}
+
+// TTL returns how long signed media URLs stay valid
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+// MediaPath returns the unsigned API path of key, as recorded on posts
+func MediaPath(key string) string {
+	return MediaURLPrefix + "/" + key
+}
+
+// Sign returns a relative URL for key that is valid for ttl and only for
+// viewerID. Viewer 0 is reserved for internal callers such as moderation.
+func (s *Signer) Sign(key string, viewerID uint, ttl time.Duration) string {
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	uid := strconv.FormatUint(uint64(viewerID), 10)
+	query := url.Values{}
+	query.Set("exp", exp)
+	query.Set("uid", uid)
+	query.Set("sig", s.signature(key, exp, uid))
+	return MediaPath(key) + "?" + query.Encode()
+}
+
+// Verify checks the exp, uid and sig query parameters of a signed media URL
+// for key and returns the viewer it was issued to
+func (s *Signer) Verify(key string, query url.Values, now time.Time) (uint, error) {
+	exp, uid, sig := query.Get("exp"), query.Get("uid"), query.Get("sig")
+	if exp == "" || uid == "" || sig == "" || len(s.secret) == 0 {
+		return 0, ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(key, exp, uid))) {
+		return 0, ErrInvalidSignature
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	if now.Unix() > expires {
+		return 0, ErrExpiredURL
+	}
+	viewerID, err := strconv.ParseUint(uid, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	return uint(viewerID), nil
+}
+
+func (s *Signer) signature(key, exp, uid string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + exp + "\n" + uid))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// KeyFromMediaURL extracts the key from a signed or unsigned media URL,
+// relative ("media/k") or absolute ("https://host/media/k")
+func KeyFromMediaURL(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	key, ok := strings.CutPrefix(strings.TrimPrefix(u.Path, "/"), MediaURLPrefix+"/")
+	return key, ok && ValidKey(key)
+}
+
+// KeyOwner returns the uploader encoded in a key's first path segment
+func KeyOwner(key string) (uint, bool) {
+	owner, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(owner, 10, 64)
+	return uint(id), err == nil
+}
+
+// BaseKey returns the key of the original upload for a rendition key
+func BaseKey(key string) string {
+	ext := path.Ext(key)
+	stem := strings.TrimSuffix(key, ext)
+	for _, name := range []string{"thumb", "medium"} {
+		if base, ok := strings.CutSuffix(stem, "_"+name); ok {
+			return base + ext
+		}
+	}
+	return key
+}
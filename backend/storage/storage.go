@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/umutdeveloper/instagram-light/backend/models"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid object key")
+)
+
+// Storage stores uploaded media under opaque keys and hands out URLs clients
+// can fetch them from
+type Storage interface {
+	// Put writes size bytes from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the object's content; callers must close it
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	// URL returns a public or signed URL for the object
+	URL(ctx context.Context, key string) (string, error)
+	// KeyForURL maps a URL previously returned by URL back to its key
+	KeyForURL(url string) (string, bool)
+}
+
+// DefaultMediaPath is where the local driver keeps uploads by default
+const DefaultMediaPath = "tmp/uploads/"
+
+// Config selects and configures the media storage backend
+type Config struct {
+	// Driver is "local" or "s3"
+	Driver string
+	// MediaPath is the directory the local driver stores uploads in
+	MediaPath string
+	S3        S3Config
+	// SigningSecret signs the media URLs handed to clients
+	SigningSecret string
+	// URLTTL is how long signed media URLs stay valid
+	URLTTL time.Duration
+}
+
+// New returns the storage backend cfg selects
+func New(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "local":
+		return NewLocalStorage(cfg.MediaPath, MediaURLPrefix), nil
+	case "s3":
+		s3, err := NewS3Storage(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+// ValidKey reports whether key is a clean relative path that cannot escape
+// the storage root
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	return path.Clean(key) == key && key != "." && !strings.HasPrefix(key, "../")
+}
+
+// RenditionKey returns the key of the named rendition of a stored image; the
+// full rendition is the stored object itself
+func RenditionKey(key, name string) string {
+	if name == "full" {
+		return key
+	}
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "_" + name + ext
+}
+
+// contentKeyPrefix namespaces content-addressed keys. It is not a number, so
+// KeyOwner never mistakes a hash for an uploader.
+const contentKeyPrefix = "sha256/"
+
+// ContentKey returns the key media with the given SHA-256 hex digest is
+// stored under, fanned out by the first byte of the hash
+func ContentKey(hash, ext string) string {
+	return contentKeyPrefix + hash[:2] + "/" + hash + ext
+}
+
+// IsContentKey reports whether key was made by ContentKey
+func IsContentKey(key string) bool {
+	return strings.HasPrefix(key, contentKeyPrefix)
+}
+
+// ChunkKey returns the key a chunk of a resumable upload is stored under.
+// Offsets are zero-padded so chunks list in upload order.
+func ChunkKey(sessionID string, offset int64) string {
+	return fmt.Sprintf("uploads/%s/%020d", sessionID, offset)
+}
+
+// RenditionKeys returns the keys of the renditions stored for key. Sizes that
+// were never generated (e.g. videos) fall back to the full object.
+func RenditionKeys(ctx context.Context, s Storage, key string) (models.MediaRenditions, error) {
+	renditions := models.MediaRenditions{Thumb: key, Medium: key, Full: key}
+	for name, target := range map[string]*string{"thumb": &renditions.Thumb, "medium": &renditions.Medium} {
+		renditionKey := RenditionKey(key, name)
+		ok, err := s.Exists(ctx, renditionKey)
+		if err != nil {
+			return models.MediaRenditions{}, err
+		}
+		if ok {
+			*target = renditionKey
+		}
+	}
+	return renditions, nil
+}
+
+// Probe checks that s is writable by storing and deleting a small object
+func Probe(ctx context.Context, s Storage) error {
+	key := fmt.Sprintf("healthcheck/%d", time.Now().UnixNano())
+	if err := s.Put(ctx, key, strings.NewReader("ok"), 2, "text/plain"); err != nil {
+		return err
+	}
+	return s.Delete(ctx, key)
+}
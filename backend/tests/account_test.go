@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/config"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/mail"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// outbox records the mail sent instead of delivering it
+type outbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg mail.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+var mailedToken = regexp.MustCompile(`\?token=([A-Za-z0-9_-]+)`)
+
+// lastToken returns the token of the last message sent to address
+func (o *outbox) lastToken(t *testing.T, address string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		if o.messages[i].To == address {
+			if match := mailedToken.FindStringSubmatch(o.messages[i].Body); assert.NotNil(t, match) {
+				return match[1]
+			}
+			return ""
+		}
+	}
+	t.Fatalf("no mail sent to %s", address)
+	return ""
+}
+
+func setupAccountApp(t *testing.T) (*fiber.App, *api.Server, *outbox) {
+	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db.DB.AutoMigrate(&models.User{}, &models.AuthToken{}, &models.LoginFailure{})
+	sent := &outbox{}
+
+	cfg := &config.Config{
+		JWTSecret:        "test-secret-key-12345",
+		TokenTTL:         time.Hour,
+		AppURL:           "https://app.example.com",
+		EmailVerifyTTL:   time.Hour,
+		PasswordResetTTL: time.Hour,
+	}
+	services := testServices()
+	services.Mail = sent
+	server := api.NewServer(cfg, repository.NewGorm(db.DB), services)
+	app := fiber.New()
+	server.RegisterAuthRoutes(app)
+	return app, server, sent
+}
+
+func postJSON(app *fiber.App, path string, body interface{}) *http.Response {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, -1)
+	return resp
+}
+
+func TestRegisterRequiresARealEmail(t *testing.T) {
+	app, _, _ := setupAccountApp(t)
+	for _, email := range []string{"", "alice", "Alice <alice@example.com>", "alice@example.com\r\nBcc: x@example.com"} {
+		resp := postJSON(app, "/api/auth/register", models.AuthRequest{Username: "alice", Email: email, Password: "secret123"})
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, email)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	app, server, sent := setupAccountApp(t)
+	resp := postJSON(app, "/api/auth/register", models.AuthRequest{Username: "alice", Email: "Alice@Example.com", Password: "secret123"})
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.NoError(t, server.Drain(context.Background()))
+
+	token := sent.lastToken(t, "alice@example.com")
+	assert.Contains(t, sent.messages[0].Body, "https://app.example.com/verify-email?token="+token)
+	var stored models.AuthToken
+	db.DB.First(&stored)
+	assert.NotEqual(t, token, stored.Hash, "only a hash of the token is stored")
+
+	resp = postJSON(app, "/api/auth/verify", models.VerifyEmailRequest{Token: token})
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var user models.User
+	db.DB.First(&user)
+	assert.NotNil(t, user.EmailVerifiedAt)
+
+	resp = postJSON(app, "/api/auth/verify", models.VerifyEmailRequest{Token: token})
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, "tokens work once")
+	var body models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	assert.Equal(t, "invalid_token", body.Code)
+}
+
+func TestPasswordReset(t *testing.T) {
+	app, server, sent := setupAccountApp(t)
+	postJSON(app, "/api/auth/register", models.AuthRequest{Username: "alice", Email: "alice@example.com", Password: "old-password"})
+	assert.NoError(t, server.Drain(context.Background()))
+	sent.messages = nil
+
+	// Unknown addresses get the same answer and no mail
+	resp := postJSON(app, "/api/auth/password/forgot", models.ForgotPasswordRequest{Email: "nobody@example.com"})
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+	assert.NoError(t, server.Drain(context.Background()))
+	assert.Empty(t, sent.messages)
+
+	resp = postJSON(app, "/api/auth/password/forgot", models.ForgotPasswordRequest{Email: "ALICE@example.com"})
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+	assert.NoError(t, server.Drain(context.Background()))
+	first := sent.lastToken(t, "alice@example.com")
+	postJSON(app, "/api/auth/password/forgot", models.ForgotPasswordRequest{Email: "alice@example.com"})
+	assert.NoError(t, server.Drain(context.Background()))
+	token := sent.lastToken(t, "alice@example.com")
+	assert.NotEqual(t, first, token)
+
+	resp = postJSON(app, "/api/auth/password/reset", models.ResetPasswordRequest{Token: first, Password: "new-password"})
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, "a newer link replaces older ones")
+	resp = postJSON(app, "/api/auth/password/reset", models.ResetPasswordRequest{Token: token, Password: "short"})
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	// Locked accounts are unlocked by a reset
+	failures := repository.NewGorm(db.DB).LoginFailures
+	for _, identifier := range []string{"alice", "alice@example.com"} {
+		sum := sha256.Sum256([]byte(identifier))
+		hash := hex.EncodeToString(sum[:])
+		_, err := failures.Record(context.Background(), hash, time.Now(), time.Hour)
+		assert.NoError(t, err)
+		assert.NoError(t, failures.LockUntil(context.Background(), hash, time.Now().Add(time.Hour)))
+	}
+	assert.Equal(t, fiber.StatusTooManyRequests, postJSON(app, "/api/auth/login", models.AuthRequest{Username: "alice", Password: "old-password"}).StatusCode)
+	resp = postJSON(app, "/api/auth/password/reset", models.ResetPasswordRequest{Token: token, Password: "new-password"})
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var locked int64
+	db.DB.Model(&models.LoginFailure{}).Count(&locked)
+	assert.Zero(t, locked)
+	resp = postJSON(app, "/api/auth/password/reset", models.ResetPasswordRequest{Token: token, Password: "another-password"})
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, "tokens work once")
+
+	assert.Equal(t, fiber.StatusUnauthorized, postJSON(app, "/api/auth/login", models.AuthRequest{Username: "alice", Password: "old-password"}).StatusCode)
+	assert.Equal(t, fiber.StatusOK, postJSON(app, "/api/auth/login", models.AuthRequest{Username: "alice", Password: "new-password"}).StatusCode)
+}
+
+func TestExpiredAuthTokensCannotBeUsed(t *testing.T) {
+	setupAccountApp(t)
+	tokens := repository.NewGorm(db.DB).AuthTokens
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+	assert.NoError(t, tokens.Create(ctx, &models.AuthToken{UserID: 1, Purpose: models.TokenResetPassword, Hash: "h", ExpiresAt: expires}))
+
+	_, err := tokens.Use(ctx, "h", models.TokenVerifyEmail, time.Now())
+	assert.ErrorIs(t, err, repository.ErrNotFound, "tokens only work for their purpose")
+	_, err = tokens.Use(ctx, "h", models.TokenResetPassword, expires.Add(time.Second))
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	purged, err := tokens.PurgeExpired(ctx, expires.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+}
+
+func TestFileMailerWritesMessages(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := mail.NewFileMailer(dir, "App <no-reply@example.com>")
+	assert.NoError(t, err)
+	err = mailer.Send(context.Background(), mail.Message{
+		To:      "alice@example.com",
+		Subject: "Hello\r\nBcc: mallory@example.com",
+		Body:    "Line one\nLine two",
+	})
+	assert.NoError(t, err)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if assert.Len(t, files, 1) {
+		data, _ := os.ReadFile(files[0])
+		assert.Contains(t, string(data), "To: alice@example.com\r\n")
+		assert.Contains(t, string(data), "From: App <no-reply@example.com>\r\n")
+		assert.NotContains(t, string(data), "\r\nBcc:")
+		assert.Contains(t, string(data), "\r\n\r\nLine one\r\nLine two")
+	}
+}
+
+func TestLogMailerLeavesOutTheBody(t *testing.T) {
+	logs := captureLogs(t)
+	err := mail.NewLogMailer("App <no-reply@example.com>").Send(context.Background(), mail.Message{
+		To:      "alice@example.com",
+		Subject: "Reset your password",
+		Body:    "http://localhost/reset-password?token=secret-token-value",
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, logs.String(), "alice@example.com")
+	assert.Contains(t, logs.String(), "Reset your password")
+	assert.NotContains(t, logs.String(), "secret-token-value")
+}
+
+func TestSMTPMailerGivesUpOnStalledServer(t *testing.T) {
+	// Accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	mailer := mail.NewSMTPMailer(mail.SMTPConfig{Host: "127.0.0.1", Port: addr.Port}, "App <no-reply@example.com>")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = mailer.Send(ctx, mail.Message{To: "alice@example.com", Subject: "Hello", Body: "Hi"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -9,24 +9,23 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
 	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/models"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-func setupApp() *fiber.App {
+func setupApp(t *testing.T) *fiber.App {
 	os.Setenv("JWT_SECRET", "testsecret")
 	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	db.DB.AutoMigrate(&models.User{})
+	db.DB.AutoMigrate(&models.User{}, &models.AuthToken{}, &models.LoginFailure{})
 	app := fiber.New()
-	api.RegisterAuthRoutes(app)
+	newServer(t, testServices()).RegisterAuthRoutes(app)
 	return app
 }
 
 func TestRegister(t *testing.T) {
-	app := setupApp()
+	app := setupApp(t)
 	body := map[string]string{"username": "testuser", "email": "testuser@example.com", "password": "testpass"}
 	jsonBody, _ := json.Marshal(body)
 	req := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(jsonBody))
@@ -36,7 +35,7 @@ func TestRegister(t *testing.T) {
 }
 
 func TestRegisterDuplicate(t *testing.T) {
-	app := setupApp()
+	app := setupApp(t)
 	body := map[string]string{"username": "testuser", "email": "testuser@example.com", "password": "testpass"}
 	jsonBody, _ := json.Marshal(body)
 	req := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(jsonBody))
@@ -47,7 +46,7 @@ func TestRegisterDuplicate(t *testing.T) {
 }
 
 func TestLoginSuccess(t *testing.T) {
-	app := setupApp()
+	app := setupApp(t)
 	regBody := map[string]string{"username": "testuser", "email": "testuser@example.com", "password": "testpass"}
 	jsonReg, _ := json.Marshal(regBody)
 	regReq := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(jsonReg))
@@ -65,7 +64,7 @@ func TestLoginSuccess(t *testing.T) {
 }
 
 func TestLoginFail(t *testing.T) {
-	app := setupApp()
+	app := setupApp(t)
 	loginBody := map[string]string{"username": "nouser", "password": "badpass"}
 	jsonLogin, _ := json.Marshal(loginBody)
 	loginReq := httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(jsonLogin))
@@ -73,3 +72,56 @@ func TestLoginFail(t *testing.T) {
 	resp, _ := app.Test(loginReq)
 	assert.Equal(t, 401, resp.StatusCode)
 }
+
+func TestLoginByEmailOrUsernameInAnyCase(t *testing.T) {
+	app := setupApp(t)
+	body, _ := json.Marshal(models.AuthRequest{Username: "Alice", Email: "Alice@Example.com", Password: "testpass"})
+	req := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var stored models.User
+	db.DB.First(&stored)
+	assert.Equal(t, "alice", stored.Username)
+	assert.Equal(t, "alice@example.com", stored.Email)
+
+	for _, credentials := range []models.AuthRequest{
+		{Username: "ALICE", Password: "testpass"},
+		{Username: "alice@EXAMPLE.com", Password: "testpass"},
+		{Email: "Alice@example.com", Password: "testpass"},
+	} {
+		body, _ := json.Marshal(credentials)
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req)
+		assert.Equal(t, 200, resp.StatusCode, credentials)
+	}
+}
+
+func TestRegisterRejectsNamesDifferingOnlyByCase(t *testing.T) {
+	app := setupApp(t)
+	register := func(username, email string) int {
+		body, _ := json.Marshal(models.AuthRequest{Username: username, Email: email, Password: "testpass"})
+		req := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req)
+		return resp.StatusCode
+	}
+	assert.Equal(t, 200, register("alice", "alice@example.com"))
+	assert.Equal(t, 400, register("Alice", "other@example.com"))
+	assert.Equal(t, 400, register("alicia", "ALICE@example.com"))
+	assert.Equal(t, 400, register("al@ice", "al@example.com"), "usernames cannot look like emails")
+}
+
+func TestRegisterRejectsShortPasswords(t *testing.T) {
+	app := setupApp(t)
+	body, _ := json.Marshal(models.AuthRequest{Username: "alice", Email: "alice@example.com", Password: "12345"})
+	req := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req)
+	assert.Equal(t, 400, resp.StatusCode)
+	var count int64
+	db.DB.Model(&models.User{}).Count(&count)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+func TestPerceptualHashesSurviveReencoding(t *testing.T) {
+	phash, dhash, err := utils.PerceptualHashes(helpers.PatternPNGBytes(400, 300, 1))
+	assert.NoError(t, err)
+
+	// A smaller JPEG copy stays within the default distance
+	copyP, copyD, err := utils.PerceptualHashes(helpers.PatternJPEGBytes(200, 150, 1))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, utils.HammingDistance(phash, copyP), utils.DefaultBannedHashDistance)
+	assert.LessOrEqual(t, utils.HammingDistance(dhash, copyD), utils.DefaultBannedHashDistance)
+
+	// A different picture does not
+	otherP, otherD, err := utils.PerceptualHashes(helpers.PatternPNGBytes(400, 300, 2))
+	assert.NoError(t, err)
+	assert.Greater(t, utils.HammingDistance(phash, otherP), utils.DefaultBannedHashDistance)
+	assert.Greater(t, utils.HammingDistance(dhash, otherD), utils.DefaultBannedHashDistance)
+
+	_, _, err = utils.PerceptualHashes([]byte("not an image"))
+	assert.Error(t, err)
+}
+
+func TestHammingDistance(t *testing.T) {
+	assert.Equal(t, 0, utils.HammingDistance(0xff, 0xff))
+	assert.Equal(t, 64, utils.HammingDistance(0, ^uint64(0)))
+	assert.Equal(t, 2, utils.HammingDistance(0b1010, 0b0110))
+}
+
+// moderationRequest sends a JSON request to the moderation API as userID
+func moderationRequest(t *testing.T, app *fiber.App, method, path string, userID uint, body interface{}) *http.Response {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(userID, fmt.Sprintf("user%d", userID)))
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestBannedMediaRejectsNearDuplicates(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, _, _ := setupMediaApp(t)
+	db.DB.Create(&models.User{Username: "user1", Email: "user1@example.com", Password: "x"})
+	db.DB.Create(&models.User{Username: "user2", Email: "user2@example.com", Password: "x", IsModerator: true})
+
+	resp := uploadFile(t, app, "photo.png", helpers.PatternPNGBytes(400, 300, 7))
+	assert.Equal(t, 200, resp.StatusCode)
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+	key, _ := storage.KeyFromMediaURL(upload.MediaURL)
+	postID := postMedia(t, app, key)
+
+	// Only moderators manage the list
+	resp = moderationRequest(t, app, "POST", "/api/moderation/banned-media", 1, map[string]string{"media_url": upload.MediaURL})
+	assert.Equal(t, 403, resp.StatusCode)
+
+	resp = moderationRequest(t, app, "POST", "/api/moderation/banned-media", 2, map[string]string{"media_url": upload.MediaURL, "reason": "abuse"})
+	assert.Equal(t, 201, resp.StatusCode)
+	var banned models.BannedMedia
+	json.NewDecoder(resp.Body).Decode(&banned)
+	assert.Len(t, banned.PHashHex, 16)
+	assert.Equal(t, "abuse", banned.Reason)
+	assert.Equal(t, uint(2), banned.CreatedBy)
+
+	// The existing post is flagged
+	var post models.Post
+	db.DB.First(&post, postID)
+	assert.True(t, post.Flagged)
+
+	// Re-uploading the image, even resized and re-encoded, is rejected
+	for _, data := range [][]byte{helpers.PatternPNGBytes(400, 300, 7), helpers.PatternJPEGBytes(320, 240, 7)} {
+		resp = uploadFile(t, app, "again.jpg", data)
+		assert.Equal(t, 422, resp.StatusCode)
+		var body models.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		assert.Equal(t, utils.MediaErrBanned, body.Code)
+	}
+	// Unrelated images are still accepted
+	resp = uploadFile(t, app, "other.png", helpers.PatternPNGBytes(400, 300, 8))
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp = moderationRequest(t, app, "GET", "/api/moderation/banned-media", 2, nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	var list []models.BannedMedia
+	json.NewDecoder(resp.Body).Decode(&list)
+	assert.Len(t, list, 1)
+
+	resp = moderationRequest(t, app, "DELETE", fmt.Sprintf("/api/moderation/banned-media/%d", banned.ID), 2, nil)
+	assert.Equal(t, 204, resp.StatusCode)
+	resp = uploadFile(t, app, "again.jpg", helpers.PatternJPEGBytes(320, 240, 7))
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestBanMediaByHashesRejectsPosts(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, _, _ := setupMediaApp(t)
+	db.DB.Create(&models.User{Username: "user1", Email: "user1@example.com", Password: "x"})
+	db.DB.Create(&models.User{Username: "user2", Email: "user2@example.com", Password: "x", IsModerator: true})
+
+	// Uploaded before the ban, posted after it
+	resp := uploadFile(t, app, "photo.png", helpers.PatternPNGBytes(300, 300, 3))
+	assert.Equal(t, 200, resp.StatusCode)
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+
+	phash, dhash, _ := utils.PerceptualHashes(helpers.PatternJPEGBytes(150, 150, 3))
+	resp = moderationRequest(t, app, "POST", "/api/moderation/banned-media", 2, map[string]string{
+		"phash": fmt.Sprintf("%x", phash),
+		"dhash": fmt.Sprintf("%x", dhash),
+	})
+	assert.Equal(t, 201, resp.StatusCode)
+
+	body, _ := json.Marshal(map[string]interface{}{"user_id": 1, "media_url": upload.MediaURL})
+	req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(1, "user1"))
+	resp, _ = app.Test(req)
+	assert.Equal(t, 422, resp.StatusCode)
+
+	resp = moderationRequest(t, app, "POST", "/api/moderation/banned-media", 2, map[string]string{"phash": "xyz", "dhash": "1"})
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestBannedMediaCheckFailsClosed(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, _, _ := setupMediaApp(t)
+	db.DB.Create(&models.User{Username: "user1", Email: "user1@example.com", Password: "x"})
+	key := uploadKey(t, app, 64)
+
+	// Without the ban list nothing new gets through
+	assert.NoError(t, db.DB.Migrator().DropTable(&models.BannedMedia{}))
+	resp := uploadFile(t, app, "photo.png", helpers.PatternPNGBytes(300, 300, 4))
+	assert.Equal(t, 500, resp.StatusCode)
+
+	body, _ := json.Marshal(map[string]interface{}{"media_url": storage.MediaPath(key)})
+	req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(1, "user1"))
+	resp, _ = app.Test(req)
+	assert.Equal(t, 500, resp.StatusCode)
+	var count int64
+	db.DB.Model(&models.Post{}).Count(&count)
+	assert.Zero(t, count)
+}
@@ -14,7 +14,6 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
 	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/models"
 	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
@@ -22,22 +21,23 @@ import (
 	"gorm.io/gorm"
 )
 
-func setupCommentWSApp() *fiber.App {
+func setupCommentWSApp(t *testing.T) *fiber.App {
 	// Set JWT secret for tests
 	if os.Getenv("JWT_SECRET") == "" {
 		os.Setenv("JWT_SECRET", "test-secret-key-12345")
 	}
 
 	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	db.DB.AutoMigrate(&models.Post{}, &models.Comment{})
+	db.DB.AutoMigrate(&models.Post{}, &models.PostMedia{}, &models.Comment{})
+	server := newServer(t, testServices())
 	app := fiber.New()
-	api.RegisterCommentRoutes(app)
+	server.RegisterCommentRoutes(app)
+	server.RegisterWebSocketRoutes(app)
 	return app
 }
 
 func TestWSEventOnComment(t *testing.T) {
-	app := setupCommentWSApp()
-	api.RegisterWebSocketRoutes(app)
+	app := setupCommentWSApp(t)
 
 	postOwnerID := uint(1)
 	post := models.Post{UserID: postOwnerID, Caption: "ws comment", MediaURL: "http://media/comment.jpg"}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
 	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/models"
 	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
@@ -18,31 +17,39 @@ import (
 	"gorm.io/gorm"
 )
 
-func setupCommentApp() *fiber.App {
+func setupCommentApp(t *testing.T) *fiber.App {
 	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	db.DB.AutoMigrate(&models.Comment{})
+	db.DB.AutoMigrate(&models.Post{}, &models.PostMedia{}, &models.Comment{})
 	app := fiber.New()
-	api.RegisterCommentRoutes(app)
+	newServer(t, testServices()).RegisterCommentRoutes(app)
 	return app
 }
 
 func TestCreateAndGetComment(t *testing.T) {
-	app := setupCommentApp()
+	app := setupCommentApp(t)
+	db.DB.Create(&models.Post{UserID: 2, Caption: "Commented", MediaURL: "http://media.com/c.jpg"})
 	token := helpers.GenerateJWT(1, "user1")
 	commentBody := map[string]string{"text": "Nice post!"}
 	body, _ := json.Marshal(commentBody)
 
-	// Create comment
-	req := httptest.NewRequest("POST", "/api/posts/1/comments", bytes.NewReader(body))
+	// Posts that do not exist take no comments
+	req := httptest.NewRequest("POST", "/api/posts/7/comments", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
 	resp, _ := app.Test(req)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Create comment
+	req = httptest.NewRequest("POST", "/api/posts/1/comments", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, _ = app.Test(req)
 	assert.Equal(t, 201, resp.StatusCode)
 	var created models.Comment
 	json.NewDecoder(resp.Body).Decode(&created)
-	assert.Equal(t, int64(1), created.PostID)
+	assert.Equal(t, uint(1), created.PostID)
 	assert.Equal(t, "Nice post!", created.Text)
-	assert.Equal(t, int64(1), created.UserID)
+	assert.Equal(t, uint(1), created.UserID)
 	assert.WithinDuration(t, time.Now(), created.CreatedAt, time.Second*5)
 
 	// Get comments
@@ -58,8 +65,9 @@ func TestCreateAndGetComment(t *testing.T) {
 }
 
 func TestDeleteComment(t *testing.T) {
-	app := setupCommentApp()
+	app := setupCommentApp(t)
 	// User 1 creates a comment
+	db.DB.Create(&models.Post{UserID: 3, Caption: "Commented", MediaURL: "http://media.com/c.jpg"})
 	token1 := helpers.GenerateJWT(1, "user1")
 	commentBody := map[string]string{"text": "To be deleted"}
 	body, _ := json.Marshal(commentBody)
@@ -72,7 +80,7 @@ func TestDeleteComment(t *testing.T) {
 	json.NewDecoder(resp.Body).Decode(&created)
 
 	// User 1 deletes their own comment
-	delReq := httptest.NewRequest("DELETE", "/api/posts/1/comments/"+strconv.FormatInt(created.ID, 10), nil)
+	delReq := httptest.NewRequest("DELETE", "/api/posts/1/comments/"+strconv.FormatUint(uint64(created.ID), 10), nil)
 	delReq.Header.Set("Authorization", "Bearer "+token1)
 	delResp, _ := app.Test(delReq)
 	assert.Equal(t, 204, delResp.StatusCode)
@@ -95,7 +103,7 @@ func TestDeleteComment(t *testing.T) {
 	var created2 models.Comment
 	json.NewDecoder(resp2.Body).Decode(&created2)
 	token2 := helpers.GenerateJWT(2, "user2")
-	delReq2 := httptest.NewRequest("DELETE", "/api/posts/1/comments/"+strconv.FormatInt(created2.ID, 10), nil)
+	delReq2 := httptest.NewRequest("DELETE", "/api/posts/1/comments/"+strconv.FormatUint(uint64(created2.ID), 10), nil)
 	delReq2.Header.Set("Authorization", "Bearer "+token2)
 	delResp2, _ := app.Test(delReq2)
 	assert.Equal(t, 403, delResp2.StatusCode)
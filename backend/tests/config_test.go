@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/config"
+	"github.com/umutdeveloper/instagram-light/backend/mail"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/tracing"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("PORT", "9090")
+	t.Setenv("PREFORK", "false")
+	t.Setenv("DATABASE_URL", "postgres://localhost/test")
+	t.Setenv("JWT_SECRET", "secret")
+	t.Setenv("JWT_TTL", "1h")
+	t.Setenv("CORS_ORIGINS", "https://app.example.com, http://localhost:3000")
+	t.Setenv("MAX_UPLOAD_SIZE", "1024")
+	t.Setenv("MEDIA_SIGNING_SECRET", "")
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("RATE_LIMIT_LIKES", "100/1h")
+	t.Setenv("RATE_LIMIT_COMMENTS", "off")
+	t.Setenv("LOGIN_LOCKOUT_AFTER", "5")
+	t.Setenv("APP_URL", "https://app.example.com/")
+	t.Setenv("MAIL_DRIVER", "smtp")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+
+	cfg, err := config.FromEnv()
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, "9090", cfg.Port)
+	assert.False(t, cfg.Prefork)
+	assert.Equal(t, time.Hour, cfg.TokenTTL)
+	assert.Equal(t, slog.LevelDebug, cfg.LogLevel)
+	assert.Equal(t, []string{"https://app.example.com", "http://localhost:3000"}, cfg.CORSOrigins)
+	assert.Equal(t, int64(1024), cfg.Uploads.MaxImageSize)
+	assert.Equal(t, utils.DefaultMaxVideoSize, cfg.Uploads.MaxVideoSize)
+	assert.Equal(t, config.DefaultTrashRetention, cfg.TrashRetention)
+	assert.Equal(t, ratelimit.Limit{Max: 100, Window: time.Hour}, cfg.RateLimits.Likes)
+	assert.False(t, cfg.RateLimits.Comments.Enabled())
+	assert.Equal(t, ratelimit.DefaultLoginPerIP, cfg.RateLimits.LoginPerIP)
+	assert.Equal(t, ratelimit.StoreMemory, cfg.RateLimits.Store)
+	assert.Equal(t, 5, cfg.Login.LockoutAfter)
+	assert.Equal(t, utils.DefaultLoginLockoutDuration, cfg.Login.LockoutDuration)
+	assert.Equal(t, "https://app.example.com", cfg.AppURL)
+	assert.Equal(t, mail.DriverSMTP, cfg.Mail.Driver)
+	assert.Equal(t, mail.DefaultSMTPPort, cfg.Mail.SMTP.Port)
+	assert.Equal(t, config.DefaultPasswordResetTTL, cfg.PasswordResetTTL)
+	assert.Equal(t, "secret", cfg.Storage.SigningSecret, "media URLs fall back to the token secret")
+}
+
+func TestConfigReportsEveryProblem(t *testing.T) {
+	t.Setenv("JWT_TTL", "soon")
+	t.Setenv("MAX_VIDEO_SIZE", "-5")
+	t.Setenv("PREFORK", "maybe")
+	t.Setenv("LOG_LEVEL", "loud")
+	t.Setenv("LOG_FORMAT", "xml")
+	t.Setenv("TRACING_EXPORTER", "jaeger")
+	t.Setenv("TRACING_SAMPLE_RATIO", "1.5")
+	t.Setenv("RATE_LIMIT_UPLOAD_BYTES", "1GB/day")
+
+	cfg, err := config.FromEnv()
+	if assert.Error(t, err) {
+		for _, key := range []string{"JWT_TTL", "MAX_VIDEO_SIZE", "PREFORK", "LOG_LEVEL", "LOG_FORMAT", "TRACING_EXPORTER", "TRACING_SAMPLE_RATIO", "RATE_LIMIT_UPLOAD_BYTES"} {
+			assert.Contains(t, err.Error(), key)
+		}
+	}
+	// Unusable values keep their defaults
+	assert.Equal(t, config.DefaultTokenTTL, cfg.TokenTTL)
+	assert.True(t, cfg.Prefork)
+	assert.Equal(t, slog.LevelInfo, cfg.LogLevel)
+	assert.Equal(t, config.DefaultLogFormat, cfg.LogFormat)
+	assert.Equal(t, tracing.ExporterNone, cfg.Tracing.Exporter)
+	assert.Equal(t, 1.0, cfg.Tracing.SampleRatio)
+
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("PORT", "http")
+	t.Setenv("CORS_ORIGINS", "localhost:3000")
+	t.Setenv("STORAGE_DRIVER", "ftp")
+	t.Setenv("APP_URL", "/app")
+	t.Setenv("MAIL_FROM", "nobody")
+	t.Setenv("MAIL_DRIVER", "smtp")
+	cfg, _ = config.FromEnv()
+	err = cfg.Validate()
+	if assert.Error(t, err) {
+		for _, key := range []string{"JWT_SECRET", "DATABASE_URL", "PORT", "CORS_ORIGINS", "STORAGE_DRIVER", "APP_URL", "MAIL_FROM", "SMTP_HOST"} {
+			assert.Contains(t, err.Error(), key)
+		}
+	}
+}
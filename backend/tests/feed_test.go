@@ -7,7 +7,6 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
 	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/models"
 	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
@@ -15,16 +14,16 @@ import (
 	"gorm.io/gorm"
 )
 
-func setupFeedApp() *fiber.App {
+func setupFeedApp(t *testing.T) *fiber.App {
 	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	db.DB.AutoMigrate(&models.User{}, &models.Post{}, &models.Follow{})
+	db.DB.AutoMigrate(&models.User{}, &models.Post{}, &models.PostMedia{}, &models.Follow{})
 	app := fiber.New()
-	api.RegisterFeedRoutes(app)
+	newServer(t, testServices()).RegisterFeedRoutes(app)
 	return app
 }
 
 func TestFeedPaginationAndFanOut(t *testing.T) {
-	app := setupFeedApp()
+	app := setupFeedApp(t)
 
 	// Create users
 	user1 := models.User{Username: "user1", Email: "user1@example.com", Password: "pass1"}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -8,13 +9,17 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func TestHealthEndpoint(t *testing.T) {
 	// Initialize Fiber app
 	app := fiber.New()
-	api.RegisterRoutes(app)
+	newServer(t, testServices()).RegisterRoutes(app)
 
 	// Create a new HTTP request to the /health endpoint
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
@@ -32,3 +37,71 @@ func TestHealthEndpoint(t *testing.T) {
 	expectedBody := `{"status":"ok"}`
 	assert.JSONEq(t, expectedBody, string(body))
 }
+
+func setupHealthApp(t *testing.T, aiStatus int) (*fiber.App, *storage.MemoryStorage) {
+	ai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(aiStatus)
+	}))
+	t.Cleanup(ai.Close)
+	t.Setenv("AI_SERVICE_URL", ai.URL)
+
+	services := testServices()
+	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	app := fiber.New()
+	newServer(t, services).RegisterRoutes(app)
+	return app, services.Media.(*storage.MemoryStorage)
+}
+
+func getReadiness(t *testing.T, app *fiber.App) (int, models.ReadinessResponse) {
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	assert.NoError(t, err)
+	var report models.ReadinessResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	return resp.StatusCode, report
+}
+
+func TestHealthLive(t *testing.T) {
+	app := fiber.New()
+	newServer(t, testServices()).RegisterRoutes(app)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHealthReady(t *testing.T) {
+	app, mem := setupHealthApp(t, http.StatusOK)
+
+	status, report := getReadiness(t, app)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.HealthOK, report.Status)
+	for _, name := range []string{"database", "storage", "ai_service", "websocket"} {
+		assert.Equal(t, models.HealthOK, report.Components[name].Status, name)
+	}
+	assert.NotNil(t, report.Components["websocket"].Details)
+
+	// The storage probe cleans up after itself
+	assert.Empty(t, mem.Keys())
+}
+
+func TestHealthReadyDegradedWithoutAIService(t *testing.T) {
+	app, _ := setupHealthApp(t, http.StatusInternalServerError)
+
+	status, report := getReadiness(t, app)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.HealthDegraded, report.Status)
+	assert.Equal(t, models.HealthDown, report.Components["ai_service"].Status)
+	assert.Contains(t, report.Components["ai_service"].Error, "500")
+}
+
+func TestHealthReadyUnavailableWithoutDatabase(t *testing.T) {
+	app, _ := setupHealthApp(t, http.StatusOK)
+	sqlDB, _ := db.DB.DB()
+	sqlDB.Close()
+
+	status, report := getReadiness(t, app)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, models.HealthUnavailable, report.Status)
+	assert.Equal(t, models.HealthDown, report.Components["database"].Status)
+	assert.NotEmpty(t, report.Components["database"].Error)
+}
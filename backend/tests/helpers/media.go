@@ -0,0 +1,114 @@
+package helpers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+)
+
+// testImage returns a small gradient image of the given size
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// PNGBytes encodes a test image of the given size as PNG
+func PNGBytes(width, height int) []byte {
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, testImage(width, height))
+	return buf.Bytes()
+}
+
+// JPEGBytes encodes a test image of the given size as JPEG
+func JPEGBytes(width, height int) []byte {
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, testImage(width, height), &jpeg.Options{Quality: 90})
+	return buf.Bytes()
+}
+
+// AnimatedGIFBytes encodes an animation of the given size whose first frame
+// fills the canvas and whose later frames each paint a smaller square on it
+func AnimatedGIFBytes(width, height, frames int) []byte {
+	anim := &gif.GIF{LoopCount: 0}
+	for i := 0; i < frames; i++ {
+		bounds := image.Rect(0, 0, width, height)
+		if i > 0 {
+			bounds = image.Rect(i, i, width/2+i, height/2+i).Intersect(bounds)
+		}
+		frame := image.NewPaletted(bounds, palette.WebSafe)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				frame.SetColorIndex(x, y, uint8(i*37%len(palette.WebSafe)))
+			}
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10+i)
+	}
+	var buf bytes.Buffer
+	_ = gif.EncodeAll(&buf, anim)
+	return buf.Bytes()
+}
+
+// WithEXIFOrientation inserts an EXIF APP1 segment carrying the given
+// orientation and a fake GPS marker right after a JPEG's SOI marker
+func WithEXIFOrientation(jpegData []byte, orientation uint16) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // big-endian header, IFD0 at offset 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, byte(orientation >> 8), byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	payload = append(payload, []byte("GPSLatitude=41.0082")...)
+	length := len(payload) + 2
+	segment := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+
+	out := append([]byte{}, jpegData[:2]...)
+	out = append(out, segment...)
+	return append(out, jpegData[2:]...)
+}
+
+// PatternPNGBytes encodes a blocky pseudo-random image as PNG. Different seeds
+// give perceptually different images; the same seed at another size gives a
+// resized copy.
+func PatternPNGBytes(width, height int, seed int64) []byte {
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, patternImage(width, height, seed))
+	return buf.Bytes()
+}
+
+// PatternJPEGBytes is PatternPNGBytes encoded as JPEG
+func PatternJPEGBytes(width, height int, seed int64) []byte {
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, patternImage(width, height, seed), &jpeg.Options{Quality: 80})
+	return buf.Bytes()
+}
+
+func patternImage(width, height int, seed int64) *image.RGBA {
+	const blocks = 8
+	rng := rand.New(rand.NewSource(seed))
+	var shades [blocks][blocks]uint8
+	for by := range shades {
+		for bx := range shades[by] {
+			shades[by][bx] = uint8(rng.Intn(256))
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			v := shades[y*blocks/height][x*blocks/width]
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// MP4Box builds an ISO BMFF box with the given type and payload
+func MP4Box(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(box, uint32(8+len(body)))
+	copy(box[4:], boxType)
+	return append(box, body...)
+}
+
+func be32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// MP4Bytes returns a minimal MP4 file with one video track of the given size,
+// duration and codec (sample entry type, e.g. "avc1")
+func MP4Bytes(width, height int, duration time.Duration, codec string) []byte {
+	const timescale = 1000
+	// mvhd version 0: version/flags, creation, modification, timescale, duration, rest
+	mvhd := MP4Box("mvhd", be32(0), be32(0), be32(0), be32(timescale), be32(uint32(duration.Milliseconds())), make([]byte, 80))
+	// tkhd ends with 16.16 fixed-point width and height
+	tkhd := MP4Box("tkhd", make([]byte, 76), be32(uint32(width)<<16), be32(uint32(height)<<16))
+	hdlr := MP4Box("hdlr", be32(0), be32(0), []byte("vide"), make([]byte, 13))
+	stsd := MP4Box("stsd", be32(0), be32(1), MP4Box(codec, make([]byte, 78)))
+	mdia := MP4Box("mdia", hdlr, MP4Box("minf", MP4Box("stbl", stsd)))
+	moov := MP4Box("moov", mvhd, MP4Box("trak", tkhd, mdia))
+	ftyp := MP4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	return bytes.Join([][]byte{ftyp, moov, MP4Box("mdat", make([]byte, 32))}, nil)
+}
+
+// ebml builds an EBML element with an 8-byte size field
+func ebml(id uint32, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(body)))
+	size[0] = 0x01
+	return append(append(out, size...), body...)
+}
+
+func ebmlUint(id uint32, v uint64) []byte {
+	return ebml(id, binary.BigEndian.AppendUint64(nil, v))
+}
+
+// WebMBytes returns a minimal WebM file with one video track of the given
+// size, duration and codec ID (e.g. "V_VP9")
+func WebMBytes(width, height int, duration time.Duration, codec string) []byte {
+	header := ebml(0x1A45DFA3, ebml(0x4282, []byte("webm")))
+	durationMs := binary.BigEndian.AppendUint64(nil, math.Float64bits(float64(duration.Milliseconds())))
+	info := ebml(0x1549A966, ebmlUint(0x2AD7B1, 1_000_000), ebml(0x4489, durationMs))
+	track := ebml(0xAE, ebmlUint(0x83, 1), ebml(0x86, []byte(codec)), ebml(0xE0, ebmlUint(0xB0, uint64(width)), ebmlUint(0xBA, uint64(height))))
+	segment := ebml(0x18538067, info, ebml(0x1654AE6B, track), ebml(0x1F43B675, make([]byte, 32)))
+	return append(header, segment...)
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+func TestProcessImageRenditions(t *testing.T) {
+	renditions, ext, err := utils.ProcessImage(helpers.JPEGBytes(2000, 1000))
+	assert.NoError(t, err)
+	assert.Equal(t, ".jpg", ext)
+
+	sizes := map[string][2]int{}
+	for _, r := range renditions {
+		sizes[r.Name] = [2]int{r.Width, r.Height}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(r.Data))
+		assert.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, r.Width, cfg.Width)
+	}
+	assert.Equal(t, [2]int{320, 160}, sizes["thumb"])
+	assert.Equal(t, [2]int{640, 320}, sizes["medium"])
+	assert.Equal(t, [2]int{1080, 540}, sizes["full"])
+}
+
+func TestProcessImageKeepsSmallImagesAndTransparency(t *testing.T) {
+	renditions, ext, err := utils.ProcessImage(helpers.PNGBytes(100, 50))
+	assert.NoError(t, err)
+	// The test PNG is fully opaque, so it is re-encoded as JPEG
+	assert.Equal(t, ".jpg", ext)
+	for _, r := range renditions {
+		assert.Equal(t, 100, r.Width)
+		assert.Equal(t, 50, r.Height)
+	}
+}
+
+func TestProcessImageStripsEXIFAndAppliesOrientation(t *testing.T) {
+	// Orientation 6 means the camera stored the image rotated 90 degrees clockwise
+	data := helpers.WithEXIFOrientation(helpers.JPEGBytes(40, 20), 6)
+	_, err := utils.ValidateMedia(data, utils.DefaultMediaLimits())
+	assert.NoError(t, err)
+
+	renditions, _, err := utils.ProcessImage(data)
+	assert.NoError(t, err)
+	for _, r := range renditions {
+		assert.False(t, bytes.Contains(r.Data, []byte("Exif")), "rendition %s still has EXIF", r.Name)
+		assert.False(t, bytes.Contains(r.Data, []byte("GPS")), "rendition %s still has GPS data", r.Name)
+		assert.Equal(t, 20, r.Width)
+		assert.Equal(t, 40, r.Height)
+	}
+}
+
+func TestProcessImageKeepsGIFAnimation(t *testing.T) {
+	data := helpers.AnimatedGIFBytes(800, 400, 4)
+	_, err := utils.ValidateMedia(data, utils.DefaultMediaLimits())
+	assert.NoError(t, err)
+
+	renditions, ext, err := utils.ProcessImage(data)
+	assert.NoError(t, err)
+	assert.Equal(t, ".gif", ext)
+	sizes := map[string][2]int{}
+	for _, r := range renditions {
+		sizes[r.Name] = [2]int{r.Width, r.Height}
+		anim, err := gif.DecodeAll(bytes.NewReader(r.Data))
+		if assert.NoError(t, err) {
+			assert.Len(t, anim.Image, 4, "rendition %s lost frames", r.Name)
+			assert.Equal(t, []int{10, 11, 12, 13}, anim.Delay)
+			for _, frame := range anim.Image {
+				assert.Equal(t, image.Rect(0, 0, r.Width, r.Height), frame.Bounds())
+			}
+		}
+	}
+	assert.Equal(t, [2]int{320, 160}, sizes["thumb"])
+	assert.Equal(t, [2]int{800, 400}, sizes["full"])
+}
+
+func TestValidateMediaBoundsGIFFrames(t *testing.T) {
+	data := helpers.AnimatedGIFBytes(100, 100, 12)
+	limits := utils.DefaultMediaLimits()
+	limits.MaxImagePixels = 100 * 100
+	_, err := utils.ValidateMedia(data, limits)
+	assert.Equal(t, utils.MediaErrMalformed, mediaErrorCode(err))
+}
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// legacyLike and legacyFollow are the tables as they were before unique constraints
+type legacyLike struct {
+	ID     uint
+	UserID uint
+	PostID uint
+}
+
+func (legacyLike) TableName() string { return "likes" }
+
+type legacyFollow struct {
+	ID          uint
+	FollowerID  uint
+	FollowingID uint
+}
+
+func (legacyFollow) TableName() string { return "follows" }
+
+func TestCleanupOrphansMigration(t *testing.T) {
+	conn, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	conn.AutoMigrate(&models.User{}, &models.Post{}, &models.PostMedia{}, &models.PostRevision{}, &models.Comment{}, &models.Media{}, &legacyLike{}, &legacyFollow{})
+
+	user := models.User{Username: "user1", Email: "user1@example.com", Password: "x"}
+	conn.Create(&user)
+	post := models.Post{UserID: user.ID, MediaURL: "http://media.com/1.jpg"}
+	conn.Create(&post)
+	orphan := models.Post{UserID: 99, MediaURL: "http://media.com/2.jpg"}
+	conn.Create(&orphan)
+	conn.Create(&models.Media{Hash: "h", Key: "sha256/ha/h.jpg", RefCount: 2})
+	conn.Create(&models.PostMedia{PostID: post.ID, MediaURL: "x", MediaKey: "sha256/ha/h.jpg"})
+	conn.Create(&models.PostMedia{PostID: orphan.ID, MediaURL: "x", MediaKey: "sha256/ha/h.jpg"})
+	conn.Create(&models.Comment{PostID: post.ID, UserID: user.ID, Text: "kept"})
+	conn.Create(&models.Comment{PostID: orphan.ID, UserID: user.ID, Text: "orphaned post"})
+	conn.Create(&models.Comment{PostID: post.ID, UserID: 99, Text: "orphaned user"})
+	conn.Create(&legacyLike{UserID: user.ID, PostID: post.ID})
+	conn.Create(&legacyLike{UserID: user.ID, PostID: post.ID})
+	conn.Create(&legacyFollow{FollowerID: user.ID, FollowingID: 99})
+
+	cleanup, err := fs.ReadFile(db.Migrations, "0002_cleanup_orphans.up.sql")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Exec(string(cleanup)).Error)
+	// Running it again changes nothing
+	assert.NoError(t, conn.Exec(string(cleanup)).Error)
+
+	count := func(model interface{}) int64 {
+		var n int64
+		conn.Model(model).Count(&n)
+		return n
+	}
+	assert.Equal(t, int64(1), count(&models.Post{}))
+	assert.Equal(t, int64(1), count(&models.PostMedia{}))
+	assert.Equal(t, int64(1), count(&models.Comment{}))
+	assert.Equal(t, int64(1), count(&legacyLike{}))
+	assert.Equal(t, int64(0), count(&legacyFollow{}))
+	var media models.Media
+	conn.First(&media)
+	assert.Equal(t, 1, media.RefCount)
+
+	// The constraints can now be added
+	assert.NoError(t, conn.Migrator().DropTable(&legacyLike{}, &legacyFollow{}))
+	assert.NoError(t, conn.AutoMigrate(&models.Like{}, &models.Follow{}))
+}
+
+// legacyUser is the users table as it was before names ignored case
+type legacyUser struct {
+	ID       uint
+	Username string `gorm:"uniqueIndex:idx_users_username"`
+	Email    string `gorm:"uniqueIndex:idx_users_email"`
+}
+
+func (legacyUser) TableName() string { return "users" }
+
+func TestCaseInsensitiveUsersMigration(t *testing.T) {
+	conn, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	conn.AutoMigrate(&legacyUser{})
+	conn.Create(&legacyUser{Username: "Alice", Email: "Alice@Example.com"})
+	conn.Create(&legacyUser{Username: "alice", Email: "alice@example.com"})
+	conn.Create(&legacyUser{Username: "Bob", Email: "bob@example.com"})
+
+	up, err := fs.ReadFile(db.Migrations, "0006_case_insensitive_users.up.sql")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Exec(string(up)).Error)
+
+	var users []legacyUser
+	conn.Order("id").Find(&users)
+	if assert.Len(t, users, 3) {
+		assert.Equal(t, "alice", users[0].Username, "the oldest account keeps its name")
+		assert.Equal(t, "alice@example.com", users[0].Email)
+		assert.Equal(t, "alice_2", users[1].Username)
+		assert.Equal(t, "duplicate-2-alice@example.com", users[1].Email)
+		assert.Equal(t, "bob", users[2].Username)
+	}
+	assert.Error(t, conn.Create(&legacyUser{Username: "BOB", Email: "bob2@example.com"}).Error)
+}
+
+func TestForeignKeysCascade(t *testing.T) {
+	conn, _ := gorm.Open(sqlite.Open(":memory:?_foreign_keys=on"), &gorm.Config{})
+	assert.NoError(t, conn.AutoMigrate(&models.User{}, &models.Post{}, &models.PostMedia{}, &models.PostRevision{}, &models.Comment{}, &models.Like{}, &models.Follow{}))
+
+	author := models.User{Username: "author", Email: "author@example.com", Password: "x"}
+	fan := models.User{Username: "fan", Email: "fan@example.com", Password: "x"}
+	conn.Create(&author)
+	conn.Create(&fan)
+	post := models.Post{UserID: author.ID, MediaURL: "x", Media: []models.PostMedia{{MediaURL: "x"}}}
+	assert.NoError(t, conn.Create(&post).Error)
+	assert.NoError(t, conn.Create(&models.Comment{PostID: post.ID, UserID: fan.ID, Text: "hi"}).Error)
+	assert.NoError(t, conn.Create(&models.Like{PostID: post.ID, UserID: fan.ID}).Error)
+	assert.NoError(t, conn.Create(&models.Follow{FollowerID: fan.ID, FollowingID: author.ID}).Error)
+
+	// Rows must point at existing parents and pairs are unique
+	assert.Error(t, conn.Create(&models.Post{UserID: 99, MediaURL: "x"}).Error)
+	assert.Error(t, conn.Create(&models.Like{PostID: post.ID, UserID: fan.ID}).Error)
+	assert.Error(t, conn.Create(&models.Follow{FollowerID: fan.ID, FollowingID: author.ID}).Error)
+
+	// Deleting the author removes their post and everything attached to it
+	assert.NoError(t, conn.Unscoped().Delete(&author).Error)
+	for _, model := range []interface{}{&models.Post{}, &models.PostMedia{}, &models.Comment{}, &models.Like{}, &models.Follow{}} {
+		var n int64
+		conn.Unscoped().Model(model).Count(&n)
+		assert.Zero(t, n)
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
 	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/models"
 	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
@@ -21,22 +20,23 @@ import (
 	"gorm.io/gorm"
 )
 
-func setupPostWSApp() *fiber.App {
+func setupPostWSApp(t *testing.T) *fiber.App {
 	// Set JWT secret for tests
 	if os.Getenv("JWT_SECRET") == "" {
 		os.Setenv("JWT_SECRET", "test-secret-key-12345")
 	}
 
 	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	db.DB.AutoMigrate(&models.Post{}, &models.Like{})
+	db.DB.AutoMigrate(&models.Post{}, &models.PostMedia{}, &models.PostRevision{}, &models.Like{}, &models.Comment{})
+	server := newServer(t, testServices())
 	app := fiber.New()
-	api.RegisterPostRoutes(app)
+	server.RegisterPostRoutes(app)
+	server.RegisterWebSocketRoutes(app)
 	return app
 }
 
 func TestWSEventOnLike(t *testing.T) {
-	app := setupPostWSApp()
-	api.RegisterWebSocketRoutes(app)
+	app := setupPostWSApp(t)
 
 	postOwner := models.Post{UserID: 1, Caption: "ws like", MediaURL: "http://media/like.jpg"}
 	db.DB.Create(&postOwner)
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/logging"
+	"github.com/umutdeveloper/instagram-light/backend/middleware"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+// captureLogs makes a JSON logger writing to the returned buffer the default
+// for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(&buf, "json", slog.LevelDebug))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRequestIDIsLoggedWithEveryEntry(t *testing.T) {
+	logs := captureLogs(t)
+	app := fiber.New()
+	app.Use(middleware.RequestID())
+	app.Use(middleware.RequestLogger())
+	app.Get("/things", func(c *fiber.Ctx) error {
+		slog.InfoContext(c.UserContext(), "Handling things")
+		return c.SendString("ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/things?sig=abc", nil)
+	req.Header.Set(logging.RequestIDHeader, "edge-1234")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "edge-1234", resp.Header.Get(logging.RequestIDHeader))
+
+	entries := logEntries(t, logs)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "Handling things", entries[0]["msg"])
+		assert.Equal(t, "Request handled", entries[1]["msg"])
+		for _, entry := range entries {
+			assert.Equal(t, "edge-1234", entry["request_id"])
+		}
+		assert.Equal(t, "/things", entries[1]["path"])
+		assert.Equal(t, "/things", entries[1]["route"])
+		assert.EqualValues(t, 200, entries[1]["status"])
+	}
+	assert.NotContains(t, logs.String(), "secret-token")
+	assert.NotContains(t, logs.String(), "sig=abc")
+}
+
+func TestRequestIDIsGeneratedWhenMissingOrUnsafe(t *testing.T) {
+	app := fiber.New()
+	app.Use(middleware.RequestID())
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(logging.RequestID(c.UserContext()))
+	})
+
+	first, _ := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	second, _ := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Len(t, first.Header.Get(logging.RequestIDHeader), 32)
+	assert.NotEqual(t, first.Header.Get(logging.RequestIDHeader), second.Header.Get(logging.RequestIDHeader))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(logging.RequestIDHeader, "bad id\nforged=entry")
+	resp, _ := app.Test(req)
+	assert.Len(t, resp.Header.Get(logging.RequestIDHeader), 32)
+}
+
+func TestLoggerRedactsSecrets(t *testing.T) {
+	logs := captureLogs(t)
+	slog.Info("Login attempt",
+		"username", "alice",
+		"password", "hunter2",
+		"Authorization", "Bearer abc.def",
+		slog.Group("headers", "X-Api-Token", "t0k3n"),
+	)
+
+	output := logs.String()
+	assert.Contains(t, output, "alice")
+	for _, secret := range []string{"hunter2", "abc.def", "t0k3n"} {
+		assert.NotContains(t, output, secret)
+	}
+	assert.Contains(t, output, logging.Redacted)
+}
+
+func TestAIClientPassesRequestID(t *testing.T) {
+	var received string
+	ai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(logging.RequestIDHeader)
+		w.Write([]byte(`{"nsfw": false, "score": 0.1}`))
+	}))
+	defer ai.Close()
+
+	ctx := logging.WithRequestID(context.Background(), "req-42")
+	_, err := utils.AIService{URL: ai.URL, BackendURL: "http://backend"}.ModerateImage(ctx, "media/1.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "req-42", received)
+}
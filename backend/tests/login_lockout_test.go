@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/config"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupLockoutApp(t *testing.T, policy utils.LoginPolicy) *fiber.App {
+	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db.DB.AutoMigrate(&models.User{}, &models.AuthToken{}, &models.LoginFailure{})
+	cfg := &config.Config{JWTSecret: "test-secret-key-12345", TokenTTL: time.Hour, Login: policy}
+	app := fiber.New()
+	api.NewServer(cfg, repository.NewGorm(db.DB), testServices()).RegisterAuthRoutes(app)
+
+	body, _ := json.Marshal(models.AuthRequest{Username: "alice", Email: "alice@example.com", Password: "correct-horse"})
+	req := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	return app
+}
+
+func login(app *fiber.App, username, password string) *http.Response {
+	body, _ := json.Marshal(models.AuthRequest{Username: username, Password: password})
+	req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, -1)
+	return resp
+}
+
+// unlock ends every wait, as if it had passed
+func unlock() {
+	db.DB.Model(&models.LoginFailure{}).Where("1 = 1").Update("locked_until", nil)
+}
+
+func TestFailedLoginsDelayThenLockTheAccount(t *testing.T) {
+	logs := captureLogs(t)
+	app := setupLockoutApp(t, utils.LoginPolicy{
+		DelayAfter:      2,
+		Delay:           time.Minute,
+		LockoutAfter:    4,
+		LockoutDuration: 15 * time.Minute,
+	})
+
+	for i := 0; i < 2; i++ {
+		resp := login(app, "alice", "wrong")
+		assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+	resp := login(app, "alice", "wrong")
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	// While the account waits even the right password is refused
+	resp = login(app, "alice", "correct-horse")
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	var body models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	assert.Equal(t, "login_locked", body.Code)
+	assert.NotContains(t, logs.String(), "security.account_locked")
+
+	unlock()
+	resp = login(app, "alice", "wrong")
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "900", resp.Header.Get(fiber.HeaderRetryAfter))
+	assert.Contains(t, logs.String(), "security.account_locked")
+	assert.Equal(t, fiber.StatusTooManyRequests, login(app, "alice", "correct-horse").StatusCode)
+
+	// A successful login clears the failures
+	unlock()
+	assert.Equal(t, fiber.StatusOK, login(app, "alice", "correct-horse").StatusCode)
+	var counted int64
+	db.DB.Model(&models.LoginFailure{}).Count(&counted)
+	assert.Zero(t, counted)
+}
+
+func TestUnknownLoginsAreThrottledLikeAccounts(t *testing.T) {
+	app := setupLockoutApp(t, utils.LoginPolicy{
+		DelayAfter:      2,
+		Delay:           time.Minute,
+		LockoutAfter:    4,
+		LockoutDuration: 15 * time.Minute,
+	})
+
+	// The same failures get the same answers whether the account exists
+	for _, username := range []string{"alice", "mallory"} {
+		var retries []string
+		for i := 0; i < 3; i++ {
+			resp := login(app, username, "wrong")
+			assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode, username)
+			retries = append(retries, resp.Header.Get(fiber.HeaderRetryAfter))
+		}
+		assert.Equal(t, []string{"", "", "60"}, retries, username)
+
+		resp := login(app, username, "wrong")
+		assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode, username)
+		assert.NotEmpty(t, resp.Header.Get(fiber.HeaderRetryAfter), username)
+		var body models.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		assert.Equal(t, "login_locked", body.Code, username)
+	}
+
+	// Identifiers are counted regardless of case
+	assert.Equal(t, fiber.StatusTooManyRequests, login(app, " Mallory", "wrong").StatusCode)
+	// Failures with one username do not hold up another
+	assert.Equal(t, fiber.StatusUnauthorized, login(app, "trent", "wrong").StatusCode)
+}
+
+func TestUsernameAndEmailShareOneBudget(t *testing.T) {
+	app := setupLockoutApp(t, utils.LoginPolicy{
+		DelayAfter:      2,
+		Delay:           time.Minute,
+		LockoutAfter:    4,
+		LockoutDuration: 15 * time.Minute,
+	})
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, fiber.StatusUnauthorized, login(app, "alice", "wrong").StatusCode)
+	}
+	// The third failure, by email, is the one that makes the account wait
+	resp := login(app, "alice@example.com", "wrong")
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get(fiber.HeaderRetryAfter))
+	assert.Equal(t, fiber.StatusTooManyRequests, login(app, "alice", "correct-horse").StatusCode)
+
+	unlock()
+	resp = login(app, "Alice@Example.com", "wrong")
+	assert.Equal(t, "900", resp.Header.Get(fiber.HeaderRetryAfter))
+	assert.Equal(t, fiber.StatusTooManyRequests, login(app, "alice", "correct-horse").StatusCode)
+	assert.Equal(t, fiber.StatusTooManyRequests, login(app, "alice@example.com", "correct-horse").StatusCode)
+
+	// Logging in by either clears both
+	unlock()
+	assert.Equal(t, fiber.StatusOK, login(app, "alice@example.com", "correct-horse").StatusCode)
+	var counted int64
+	db.DB.Model(&models.LoginFailure{}).Count(&counted)
+	assert.Zero(t, counted)
+}
+
+func TestOldFailedLoginsAreForgotten(t *testing.T) {
+	setupLockoutApp(t, utils.DefaultLoginPolicy())
+	failures := repository.NewGorm(db.DB).LoginFailures
+
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	for i := 1; i <= 3; i++ {
+		count, err := failures.Record(ctx, "alice-hash", start.Add(time.Duration(i)*time.Second), 15*time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, i, count)
+	}
+	count, err := failures.Record(ctx, "alice-hash", time.Now(), 15*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Stale counts are purged unless still locked
+	_, err = failures.Record(ctx, "bob-hash", start, 15*time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, failures.LockUntil(ctx, "bob-hash", time.Now().Add(time.Hour)))
+	_, err = failures.Record(ctx, "carol-hash", start, 15*time.Minute)
+	assert.NoError(t, err)
+	purged, err := failures.PurgeStale(ctx, time.Now(), 15*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = failures.ByHash(ctx, "carol-hash")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestLoginPolicyWait(t *testing.T) {
+	policy := utils.LoginPolicy{DelayAfter: 3, Delay: time.Second, LockoutAfter: 10, LockoutDuration: 15 * time.Minute}
+	for failures, want := range map[int]time.Duration{1: 0, 3: 0, 4: time.Second, 5: 2 * time.Second, 6: 4 * time.Second} {
+		wait, locked := policy.Wait(failures)
+		assert.Equal(t, want, wait, failures)
+		assert.False(t, locked)
+	}
+	wait, locked := policy.Wait(10)
+	assert.Equal(t, 15*time.Minute, wait)
+	assert.True(t, locked)
+
+	wait, locked = utils.LoginPolicy{}.Wait(100)
+	assert.Zero(t, wait)
+	assert.False(t, locked)
+}
+
+func TestUnknownUsernameTakesAsLongAsWrongPassword(t *testing.T) {
+	app := setupLockoutApp(t, utils.LoginPolicy{})
+	elapsed := func(username string) time.Duration {
+		start := time.Now()
+		assert.Equal(t, fiber.StatusUnauthorized, login(app, username, "wrong").StatusCode)
+		return time.Since(start)
+	}
+	known := elapsed("alice")
+	unknown := elapsed("mallory")
+	// Both compare a bcrypt hash; without that an unknown name answers in
+	// well under a tenth of the time
+	assert.Greater(t, unknown, known/3)
+}
@@ -0,0 +1,421 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupMediaApp serves every route from media in memory, returning the
+// server and storage behind it
+func setupMediaApp(t *testing.T) (*fiber.App, *api.Server, *storage.MemoryStorage) {
+	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db.DB.AutoMigrate(&models.User{}, &models.Post{}, &models.PostMedia{}, &models.Follow{}, &models.Media{}, &models.MediaOwner{}, &models.BannedMedia{}, &models.PostRevision{}, &models.Comment{}, &models.Like{})
+	services := testServices()
+	server := newServer(t, services)
+	app := fiber.New()
+	server.RegisterRoutes(app)
+	return app, server, services.Media.(*storage.MemoryStorage)
+}
+
+func putMedia(media storage.Storage, key string) {
+	media.Put(context.Background(), key, bytes.NewReader([]byte("img:"+key)), int64(len(key)+4), "image/jpeg")
+}
+
+func fetchMedia(t *testing.T, app *fiber.App, signedURL string) int {
+	req := httptest.NewRequest("GET", "/"+signedURL, nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	return resp.StatusCode
+}
+
+func TestServeMediaRequiresValidSignature(t *testing.T) {
+	app, _, store := setupMediaApp(t)
+	putMedia(store, "1/a.jpg")
+
+	signed := testSigner.Sign("1/a.jpg", 1, time.Minute)
+	req := httptest.NewRequest("GET", "/"+signed, nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "img:1/a.jpg", string(body))
+
+	// Unsigned, tampered and expired URLs are rejected
+	assert.Equal(t, 403, fetchMedia(t, app, storage.MediaPath("1/a.jpg")))
+	u, _ := url.Parse(signed)
+	q := u.Query()
+	q.Set("uid", "2")
+	assert.Equal(t, 403, fetchMedia(t, app, u.Path+"?"+q.Encode()))
+	assert.Equal(t, 403, fetchMedia(t, app, testSigner.Sign("1/a.jpg", 1, -time.Minute)))
+	// A signature for one key cannot be reused for another
+	putMedia(store, "1/b.jpg")
+	q = u.Query()
+	assert.Equal(t, 403, fetchMedia(t, app, storage.MediaPath("1/b.jpg")+"?"+q.Encode()))
+}
+
+func TestServeMediaAccessControl(t *testing.T) {
+	app, _, store := setupMediaApp(t)
+	owner := models.User{Username: "owner", Email: "owner@example.com", Password: "x"}
+	follower := models.User{Username: "follower", Email: "follower@example.com", Password: "x"}
+	stranger := models.User{Username: "stranger", Email: "stranger@example.com", Password: "x"}
+	db.DB.Create(&owner)
+	db.DB.Create(&follower)
+	db.DB.Create(&stranger)
+	db.DB.Create(&models.Follow{FollowerID: follower.ID, FollowingID: owner.ID})
+
+	// Unattached uploads are only visible to the uploader
+	putMedia(store, "1/draft.jpg")
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign("1/draft.jpg", owner.ID, time.Minute)))
+	assert.Equal(t, 404, fetchMedia(t, app, testSigner.Sign("1/draft.jpg", stranger.ID, time.Minute)))
+
+	// Public posts, including their renditions, are visible to everyone
+	putMedia(store, "1/public.jpg")
+	putMedia(store, "1/public_thumb.jpg")
+	post := models.Post{UserID: owner.ID, MediaURL: storage.MediaPath("1/public.jpg"), MediaKey: "1/public.jpg"}
+	db.DB.Create(&post)
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign("1/public.jpg", stranger.ID, time.Minute)))
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign("1/public_thumb.jpg", stranger.ID, time.Minute)))
+
+	// Flagged posts are hidden from everyone but the owner
+	db.DB.Model(&post).Update("flagged", true)
+	assert.Equal(t, 404, fetchMedia(t, app, testSigner.Sign("1/public.jpg", stranger.ID, time.Minute)))
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign("1/public.jpg", owner.ID, time.Minute)))
+	db.DB.Model(&post).Update("flagged", false)
+
+	// Private accounts only share media with followers
+	db.DB.Model(&owner).Update("is_private", true)
+	assert.Equal(t, 404, fetchMedia(t, app, testSigner.Sign("1/public.jpg", stranger.ID, time.Minute)))
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign("1/public.jpg", follower.ID, time.Minute)))
+	db.DB.Model(&owner).Update("is_private", false)
+
+	// URLs issued before a post was deleted stop working
+	signed := testSigner.Sign("1/public.jpg", stranger.ID, time.Minute)
+	db.DB.Delete(&post)
+	assert.Equal(t, 404, fetchMedia(t, app, signed))
+}
+
+func TestServeMediaOfPostsFromBeforeAttributedUploads(t *testing.T) {
+	app, _, store := setupMediaApp(t)
+	owner := models.User{Username: "owner", Email: "owner@example.com", Password: "x", IsPrivate: true}
+	follower := models.User{Username: "follower", Email: "follower@example.com", Password: "x"}
+	stranger := models.User{Username: "stranger", Email: "stranger@example.com", Password: "x"}
+	db.DB.Create(&owner)
+	db.DB.Create(&follower)
+	db.DB.Create(&stranger)
+	db.DB.Create(&models.Follow{FollowerID: follower.ID, FollowingID: owner.ID})
+
+	// Keys name no uploader: the original upload directory, and a bare
+	// timestamped file name
+	for _, key := range []string{"tmp/uploads/1700000000_cat.jpg", "1700000000_dog.jpg"} {
+		putMedia(store, key)
+		db.DB.Create(&models.Post{UserID: owner.ID, MediaURL: storage.MediaPath(key)})
+		assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign(key, owner.ID, time.Minute)), key)
+		assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign(key, follower.ID, time.Minute)), key)
+		assert.Equal(t, 404, fetchMedia(t, app, testSigner.Sign(key, stranger.ID, time.Minute)), key)
+	}
+
+	// The same holds for carousel items pointing at such keys
+	putMedia(store, "1700000001_bird.jpg")
+	db.DB.Create(&models.Post{UserID: owner.ID, MediaURL: storage.MediaPath("1700000001_bird.jpg"), MediaKey: "1700000001_bird.jpg",
+		Media: []models.PostMedia{{MediaURL: storage.MediaPath("1700000001_bird.jpg"), MediaKey: "1700000001_bird.jpg"}}})
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign("1700000001_bird.jpg", follower.ID, time.Minute)))
+	assert.Equal(t, 404, fetchMedia(t, app, testSigner.Sign("1700000001_bird.jpg", stranger.ID, time.Minute)))
+}
+
+func TestCreatePostAttachesSignedUpload(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, _, store := setupMediaApp(t)
+	putMedia(store, "7/photo.jpg")
+	putMedia(store, "7/photo_thumb.jpg")
+	putMedia(store, "7/photo_medium.jpg")
+
+	uploadURL := testSigner.Sign("7/photo.jpg", 7, time.Minute)
+	body, _ := json.Marshal(map[string]interface{}{"user_id": 7, "media_url": uploadURL})
+	req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(7, "seven"))
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+
+	var created models.Post
+	json.NewDecoder(resp.Body).Decode(&created)
+	thumbKey, ok := storage.KeyFromMediaURL(created.Renditions.Thumb)
+	assert.True(t, ok)
+	assert.Equal(t, "7/photo_thumb.jpg", thumbKey)
+	assert.Equal(t, 200, fetchMedia(t, app, created.Renditions.Medium))
+
+	// The stored row keeps the key and unsigned paths, never signed URLs
+	var stored models.Post
+	db.DB.First(&stored, created.ID)
+	assert.Equal(t, "7/photo.jpg", stored.MediaKey)
+	assert.Equal(t, "media/7/photo.jpg", stored.MediaURL)
+	assert.Equal(t, "media/7/photo_thumb.jpg", stored.Renditions.Thumb)
+}
+
+func TestCreateCarouselPost(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, _, store := setupMediaApp(t)
+	token := helpers.GenerateJWT(7, "seven")
+	png := helpers.PNGBytes(40, 30)
+	for _, key := range []string{"7/a.png", "7/b.png"} {
+		store.Put(context.Background(), key, bytes.NewReader(png), int64(len(png)), "image/png")
+	}
+	putMedia(store, "7/clip.mp4")
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"user_id": 7,
+		"caption": "trip",
+		"media": []map[string]string{
+			{"media_url": testSigner.Sign("7/a.png", 7, time.Minute)},
+			{"media_url": testSigner.Sign("7/clip.mp4", 7, time.Minute)},
+			{"media_url": testSigner.Sign("7/b.png", 7, time.Minute)},
+		},
+	})
+	req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	var created models.Post
+	json.NewDecoder(resp.Body).Decode(&created)
+
+	// Post detail returns every item in order, with type and dimensions
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/posts/%d", created.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	var post models.Post
+	json.NewDecoder(resp.Body).Decode(&post)
+	if assert.Len(t, post.Media, 3) {
+		keys := []string{}
+		for i, item := range post.Media {
+			assert.Equal(t, i, item.Position)
+			key, _ := storage.KeyFromMediaURL(item.MediaURL)
+			keys = append(keys, key)
+		}
+		assert.Equal(t, []string{"7/a.png", "7/clip.mp4", "7/b.png"}, keys)
+		assert.Equal(t, models.MediaTypeImage, post.Media[0].MediaType)
+		assert.Equal(t, 40, post.Media[0].Width)
+		assert.Equal(t, 30, post.Media[0].Height)
+		assert.Equal(t, models.MediaTypeVideo, post.Media[1].MediaType)
+	}
+	// The post's own media mirrors the cover item
+	coverKey, _ := storage.KeyFromMediaURL(post.MediaURL)
+	assert.Equal(t, "7/a.png", coverKey)
+
+	// The feed includes all items too
+	req = httptest.NewRequest("GET", "/api/feed?user_id=7", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	var feed models.FeedResponse
+	json.NewDecoder(resp.Body).Decode(&feed)
+	if assert.Len(t, feed.Posts, 1) {
+		assert.Len(t, feed.Posts[0].Media, 3)
+	}
+
+	// Too many items are rejected
+	items := []map[string]string{}
+	for i := 0; i < 11; i++ {
+		items = append(items, map[string]string{"media_url": testSigner.Sign("7/a.png", 7, time.Minute)})
+	}
+	body, _ = json.Marshal(map[string]interface{}{"user_id": 7, "media": items})
+	req = httptest.NewRequest("POST", "/api/posts", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestServeMediaPerItemModeration(t *testing.T) {
+	app, _, store := setupMediaApp(t)
+	owner := models.User{Username: "owner", Email: "owner@example.com", Password: "x"}
+	stranger := models.User{Username: "stranger", Email: "stranger@example.com", Password: "x"}
+	db.DB.Create(&owner)
+	db.DB.Create(&stranger)
+	putMedia(store, "1/ok.jpg")
+	putMedia(store, "1/nsfw.jpg")
+
+	post := models.Post{UserID: owner.ID, MediaURL: storage.MediaPath("1/ok.jpg"), MediaKey: "1/ok.jpg", Media: []models.PostMedia{
+		{Position: 0, MediaURL: storage.MediaPath("1/ok.jpg"), MediaKey: "1/ok.jpg"},
+		{Position: 1, MediaURL: storage.MediaPath("1/nsfw.jpg"), MediaKey: "1/nsfw.jpg", Flagged: true},
+	}}
+	db.DB.Create(&post)
+
+	// A flagged item is hidden without hiding the rest of the carousel
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign("1/ok.jpg", stranger.ID, time.Minute)))
+	assert.Equal(t, 404, fetchMedia(t, app, testSigner.Sign("1/nsfw.jpg", stranger.ID, time.Minute)))
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign("1/nsfw.jpg", owner.ID, time.Minute)))
+}
+
+func TestCreateVideoPost(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, _, _ := setupMediaApp(t)
+	owner := models.User{Username: "user1", Email: "user1@example.com", Password: "x"}
+	stranger := models.User{Username: "stranger", Email: "stranger@example.com", Password: "x"}
+	db.DB.Create(&owner)
+	db.DB.Create(&stranger)
+
+	resp := uploadForm(t, app,
+		formFile{"file", "clip.webm", helpers.WebMBytes(640, 360, 2500*time.Millisecond, "V_VP9")},
+		formFile{"poster", "poster.png", helpers.PNGBytes(64, 36)},
+	)
+	assert.Equal(t, 200, resp.StatusCode)
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"user_id": owner.ID,
+		"media":   []map[string]string{{"media_url": upload.MediaURL, "poster_url": upload.PosterURL}},
+	})
+	req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(owner.ID, "user1"))
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+
+	var post models.Post
+	json.NewDecoder(resp.Body).Decode(&post)
+	assert.Equal(t, models.MediaTypeVideo, post.MediaType)
+	posterKey, _ := storage.KeyFromMediaURL(upload.PosterURL)
+	if assert.Len(t, post.Media, 1) {
+		item := post.Media[0]
+		assert.Equal(t, models.MediaTypeVideo, item.MediaType)
+		assert.Equal(t, 640, item.Width)
+		assert.Equal(t, 360, item.Height)
+		assert.Equal(t, int64(2500), item.DurationMs)
+		assert.Equal(t, "V_VP9", item.Codec)
+		itemPosterKey, ok := storage.KeyFromMediaURL(item.PosterURL)
+		assert.True(t, ok)
+		assert.Equal(t, posterKey, itemPosterKey)
+	}
+
+	// The poster is served to whoever may see the video
+	assert.Equal(t, 200, fetchMedia(t, app, testSigner.Sign(posterKey, stranger.ID, time.Minute)))
+	db.DB.Model(&models.PostMedia{}).Where("post_id = ?", post.ID).Update("flagged", true)
+	assert.Equal(t, 404, fetchMedia(t, app, testSigner.Sign(posterKey, stranger.ID, time.Minute)))
+}
+
+func TestModerationIsReusedForIdenticalContent(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	calls := 0
+	ai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"nsfw":true,"score":0.97}`))
+	}))
+	defer ai.Close()
+	t.Setenv("AI_SERVICE_URL", ai.URL)
+	app, server, _ := setupMediaApp(t)
+	owner := models.User{Username: "user1", Email: "user1@example.com", Password: "x"}
+	db.DB.Create(&owner)
+
+	resp := uploadFile(t, app, "a.png", helpers.PNGBytes(16, 16))
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+
+	createPost := func() models.Post {
+		body, _ := json.Marshal(map[string]interface{}{"user_id": owner.ID, "media_url": upload.MediaURL})
+		req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(owner.ID, "user1"))
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, 201, resp.StatusCode)
+		var post models.Post
+		json.NewDecoder(resp.Body).Decode(&post)
+		return post
+	}
+	first := createPost()
+	second := createPost()
+	assert.True(t, first.Flagged)
+	assert.True(t, second.Flagged)
+	assert.Equal(t, 1, calls)
+
+	// Both posts hold a reference; a deleted post keeps its reference while it
+	// can be restored and releases it when purged
+	key, _ := storage.KeyFromMediaURL(upload.MediaURL)
+	var media models.Media
+	db.DB.Where("storage_key = ?", key).First(&media)
+	assert.Equal(t, 2, media.RefCount)
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/api/posts/%d", first.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(owner.ID, "user1"))
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 204, resp.StatusCode)
+	db.DB.First(&media, media.ID)
+	assert.Equal(t, 2, media.RefCount)
+	purged, err := server.PurgeTrash(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	db.DB.First(&media, media.ID)
+	assert.Equal(t, 1, media.RefCount)
+}
+
+func TestPostsOnlyShareTheirAuthorsUploads(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, _, _ := setupMediaApp(t)
+	victim := models.User{Username: "user1", Email: "user1@example.com", Password: "x", IsPrivate: true}
+	thief := models.User{Username: "thief", Email: "thief@example.com", Password: "x"}
+	stranger := models.User{Username: "stranger", Email: "stranger@example.com", Password: "x"}
+	db.DB.Create(&victim)
+	db.DB.Create(&thief)
+	db.DB.Create(&stranger)
+
+	// uploadForm uploads as user 1
+	resp := uploadFile(t, app, "private.png", helpers.PNGBytes(32, 32))
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+	key, _ := storage.KeyFromMediaURL(upload.MediaURL)
+
+	create := func(userID uint, body map[string]interface{}) *http.Response {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(userID, "user"))
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+	// Neither the key alone nor claiming to be the uploader works
+	assert.Equal(t, 403, create(thief.ID, map[string]interface{}{"media_url": storage.MediaPath(key)}).StatusCode)
+	assert.Equal(t, 403, create(thief.ID, map[string]interface{}{"user_id": victim.ID, "media_url": storage.MediaPath(key)}).StatusCode)
+
+	// A post that got hold of the key anyway does not make it public
+	db.DB.Create(&models.Post{UserID: thief.ID, MediaURL: storage.MediaPath(key), MediaKey: key, Media: []models.PostMedia{
+		{MediaURL: storage.MediaPath(key), MediaKey: key},
+	}})
+	assert.Equal(t, 404, fetchMedia(t, app, testSigner.Sign(key, stranger.ID, time.Minute)))
+
+	resp = create(victim.ID, map[string]interface{}{"user_id": thief.ID, "media_url": storage.MediaPath(key)})
+	assert.Equal(t, 201, resp.StatusCode)
+	var created models.Post
+	json.NewDecoder(resp.Body).Decode(&created)
+	assert.Equal(t, victim.ID, created.UserID, "the author comes from the token")
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+)
+
+// uploadKey uploads an image as user 1 and returns its storage key
+func uploadKey(t *testing.T, app *fiber.App, size int) string {
+	resp := uploadFile(t, app, "photo.png", helpers.PNGBytes(size, size))
+	assert.Equal(t, 200, resp.StatusCode)
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+	key, _ := storage.KeyFromMediaURL(upload.MediaURL)
+	return key
+}
+
+// postMedia creates a post for user 1 around an uploaded key
+func postMedia(t *testing.T, app *fiber.App, key string) uint {
+	body, _ := json.Marshal(map[string]interface{}{"user_id": 1, "media_url": storage.MediaPath(key)})
+	req := httptest.NewRequest("POST", "/api/posts", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(1, "user1"))
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	var post models.Post
+	json.NewDecoder(resp.Body).Decode(&post)
+	return post.ID
+}
+
+func storedObject(store storage.Storage, key string) bool {
+	ok, _ := store.Exists(context.Background(), key)
+	return ok
+}
+
+func TestSweepOrphanedMedia(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, server, store := setupMediaApp(t)
+	db.DB.Create(&models.User{Username: "user1", Email: "user1@example.com", Password: "x"})
+
+	draft := uploadKey(t, app, 10)
+	kept := uploadKey(t, app, 11)
+	removed := uploadKey(t, app, 12)
+	postMedia(t, app, kept)
+	removedPost := postMedia(t, app, removed)
+	req := httptest.NewRequest("DELETE", fmt.Sprintf("/api/posts/%d", removedPost), nil)
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(1, "user1"))
+	resp, _ := app.Test(req)
+	assert.Equal(t, 204, resp.StatusCode)
+	// Media of a deleted post is only released once the post leaves the trash
+	_, err := server.PurgeTrash(context.Background(), 0)
+	assert.NoError(t, err)
+
+	// Nothing is collected within the grace period
+	opts := api.MediaGCOptions{Grace: time.Hour}
+	stats, err := server.SweepOrphanedMedia(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.Deleted)
+
+	db.DB.Model(&models.Media{}).Where("1 = 1").Update("last_used_at", time.Now().Add(-2*time.Hour))
+
+	// A dry run reports without deleting
+	opts.DryRun = true
+	stats, err = server.SweepOrphanedMedia(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Deleted)
+	assert.Positive(t, stats.BytesReclaimed)
+	assert.True(t, storedObject(store, draft))
+	assert.True(t, storedObject(store, removed))
+
+	before := api.MediaGCTotals()
+	opts.DryRun = false
+	stats, err = server.SweepOrphanedMedia(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Deleted)
+	assert.Equal(t, before.BytesReclaimed+stats.BytesReclaimed, api.MediaGCTotals().BytesReclaimed)
+	for _, key := range []string{draft, removed, storage.RenditionKey(draft, "thumb")} {
+		assert.False(t, storedObject(store, key), key)
+	}
+	assert.True(t, storedObject(store, kept))
+	assert.True(t, storedObject(store, storage.RenditionKey(kept, "thumb")))
+	var owners int64
+	db.DB.Model(&models.MediaOwner{}).Count(&owners)
+	assert.Equal(t, int64(1), owners)
+}
+
+func TestSweepOrphanedMediaRepairsDriftedCounts(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("AI_SERVICE_URL", "http://127.0.0.1:1")
+	app, server, store := setupMediaApp(t)
+	db.DB.Create(&models.User{Username: "user1", Email: "user1@example.com", Password: "x"})
+
+	key := uploadKey(t, app, 10)
+	postMedia(t, app, key)
+	db.DB.Model(&models.Media{}).Where("storage_key = ?", key).
+		Updates(map[string]interface{}{"ref_count": 0, "last_used_at": time.Now().Add(-48 * time.Hour)})
+
+	stats, err := server.SweepOrphanedMedia(context.Background(), api.MediaGCOptions{Grace: time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.Deleted)
+	assert.True(t, storedObject(store, key))
+	var media models.Media
+	db.DB.Where("storage_key = ?", key).First(&media)
+	assert.Equal(t, 1, media.RefCount)
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	pngenc "image/png"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+func mediaErrorCode(err error) string {
+	var mediaErr *utils.MediaError
+	if errors.As(err, &mediaErr) {
+		return mediaErr.Code
+	}
+	return ""
+}
+
+func TestValidateMediaAcceptsImages(t *testing.T) {
+	info, err := utils.ValidateMedia(helpers.PNGBytes(16, 9), utils.DefaultMediaLimits())
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", info.ContentType)
+	assert.Equal(t, ".png", info.Ext)
+	assert.Equal(t, 16, info.Width)
+	assert.Equal(t, 9, info.Height)
+
+	info, err = utils.ValidateMedia(helpers.JPEGBytes(10, 20), utils.DefaultMediaLimits())
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", info.ContentType)
+	assert.Equal(t, ".jpg", info.Ext)
+}
+
+func TestValidateMediaAcceptsPhonePhotos(t *testing.T) {
+	jpg := helpers.JPEGBytes(10, 20)
+	// Multi-Picture images and Motion Photo videos follow the main image
+	mpf := append(append([]byte{}, jpg...), helpers.JPEGBytes(4, 4)...)
+	motion := append(append([]byte{}, jpg...), helpers.MP4Bytes(320, 240, time.Second, "avc1")...)
+	for _, data := range [][]byte{mpf, motion} {
+		info, err := utils.ValidateMedia(data, utils.DefaultMediaLimits())
+		if assert.NoError(t, err) {
+			assert.Equal(t, 10, info.Width)
+		}
+	}
+}
+
+func TestValidateMediaAcceptsMP4(t *testing.T) {
+	data := helpers.MP4Bytes(1280, 720, 12500*time.Millisecond, "avc1")
+	info, err := utils.ValidateMedia(data, utils.DefaultMediaLimits())
+	assert.NoError(t, err)
+	assert.Equal(t, "video/mp4", info.ContentType)
+	assert.False(t, info.IsImage())
+	assert.Equal(t, 1280, info.Width)
+	assert.Equal(t, 720, info.Height)
+	assert.Equal(t, 12500*time.Millisecond, info.Duration)
+	assert.Equal(t, "avc1", info.Codec)
+
+	// Bytes after the last box are not part of the container
+	_, err = utils.ValidateMedia(append(data, []byte("PAYLOAD!trailer")...), utils.DefaultMediaLimits())
+	assert.Error(t, err)
+}
+
+func TestValidateMediaRejections(t *testing.T) {
+	png := helpers.PNGBytes(8, 8)
+	jpg := helpers.JPEGBytes(8, 8)
+	corrupted := append([]byte{}, png...)
+	copy(corrupted[40:], bytes.Repeat([]byte{0xFF}, 20))
+
+	cases := []struct {
+		name string
+		data []byte
+		code string
+	}{
+		{"empty", nil, utils.MediaErrEmpty},
+		{"html", []byte("<html><body>hi</body></html>"), utils.MediaErrUnsupported},
+		{"corrupted pixels", corrupted, utils.MediaErrMalformed},
+		{"jpeg with appended zip", append(append([]byte{}, jpg...), []byte("PK\x03\x04....PK\x05\x06")...), utils.MediaErrPolyglot},
+		{"jpeg with embedded php", append(jpg[:len(jpg)-2:len(jpg)-2], append([]byte("<?php system($_GET['c']); ?>"), 0xFF, 0xD9)...), utils.MediaErrPolyglot},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := utils.ValidateMedia(tc.data, utils.DefaultMediaLimits())
+			assert.Error(t, err)
+			assert.Equal(t, tc.code, mediaErrorCode(err))
+		})
+	}
+
+	// Images and videos have separate size limits
+	_, err := utils.ValidateMedia(png, utils.MediaLimits{MaxImageSize: int64(len(png) - 1), MaxVideoSize: utils.DefaultMaxVideoSize})
+	assert.Equal(t, utils.MediaErrTooLarge, mediaErrorCode(err))
+}
+
+func TestValidateMediaScansWherePayloadsHide(t *testing.T) {
+	jpg := helpers.JPEGBytes(8, 8)
+	png := helpers.PNGBytes(8, 8)
+	comment := []byte("<ScRiPt>alert(1)</sCrIpT>")
+	withComment := append([]byte{0xFF, 0xD8, 0xFF, 0xFE, 0, byte(len(comment) + 2)}, comment...)
+	withComment = append(withComment, jpg[2:]...)
+	text := append([]byte("Comment\x00"), []byte("JavaScript:alert(1)")...)
+	iend := len(png) - 12
+	withText := append(append(append([]byte{}, png[:iend]...), pngChunk("tEXt", text)...), png[iend:]...)
+
+	// Markers are found in any case in metadata and after the end of the image
+	for name, data := range map[string][]byte{
+		"jpeg comment":  withComment,
+		"jpeg trailer":  append(append([]byte{}, jpg...), []byte("<!DOCTYPE HTML><p>hi")...),
+		"png text":      withText,
+		"gif extension": gifWithComment(t, "<IFRAME src=x>"),
+	} {
+		_, err := utils.ValidateMedia(data, utils.DefaultMediaLimits())
+		assert.Equal(t, utils.MediaErrPolyglot, mediaErrorCode(err), name)
+	}
+
+	// Pixel data that happens to spell a marker is left alone
+	pixels := image.NewGray(image.Rect(0, 0, 5, 1))
+	copy(pixels.Pix, "<HTML")
+	var buf bytes.Buffer
+	require.NoError(t, (&pngenc.Encoder{CompressionLevel: pngenc.NoCompression}).Encode(&buf, pixels))
+	require.True(t, bytes.Contains(buf.Bytes(), []byte("<HTML")))
+	_, err := utils.ValidateMedia(buf.Bytes(), utils.DefaultMediaLimits())
+	assert.NoError(t, err)
+}
+
+// pngChunk encodes a PNG chunk with its length and checksum
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(append(chunk, chunkType...), data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// gifWithComment encodes a one-pixel GIF with a comment extension before the trailer
+func gifWithComment(t *testing.T, comment string) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil))
+	data := buf.Bytes()
+	extension := append([]byte{0x21, 0xFE, byte(len(comment))}, comment...)
+	extension = append(extension, 0x00)
+	return append(append(data[:len(data)-1:len(data)-1], extension...), 0x3B)
+}
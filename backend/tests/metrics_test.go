@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMetricsCountRequestsByRoute(t *testing.T) {
+	app := fiber.New()
+	app.Use(metrics.Middleware())
+	app.Get("/metrics-test/:id", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Get("/metrics-test-error", func(c *fiber.Ctx) error { return fiber.ErrTeapot })
+	app.Get("/metrics-test-missing", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNotFound) })
+	app.Get("/metrics", metrics.Handler)
+
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/metrics-test-error", "/metrics-test-missing", "/no-such-route"} {
+		_, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("GET", "/metrics-test/:id", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("GET", "/metrics-test-error", "418")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("GET", "/metrics-test-missing", "404")))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("GET", "unmatched", "404")), 1.0)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `http_requests_total{method="GET",route="/metrics-test/:id",status="200"} 2`)
+	assert.Contains(t, string(body), `http_request_duration_seconds_count{method="GET",route="/metrics-test/:id",status="200"} 2`)
+	assert.NotContains(t, string(body), "/no-such-route")
+}
+
+func TestMetricsTimeDatabaseQueries(t *testing.T) {
+	gdb, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	gdb.AutoMigrate(&models.User{})
+	assert.NoError(t, metrics.InstrumentDB(gdb))
+
+	gdb.Create(&models.User{Username: "metrics", Email: "metrics@example.com", Password: "x"})
+	var user models.User
+	gdb.First(&user, "username = ?", "metrics")
+	gdb.First(&user, "username = ?", "nobody")
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.DBQueryErrors.WithLabelValues("query", "users")), "missing rows are not errors")
+	assert.Equal(t, uint64(2), histogramCount(t, metrics.DBQueryDuration, "query", "users"))
+}
+
+// Under prefork every process shares its metrics on a socket and a scrape of
+// any one of them sums the whole group
+func TestMetricsSumPreforkGroup(t *testing.T) {
+	dir := t.TempDir()
+
+	// Another process of the group, which has served one request and holds
+	// two WebSocket connections
+	peer := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "http_requests_total", Help: "x"}, []string{"method", "route", "status"})
+	connections := prometheus.NewGauge(prometheus.GaugeOpts{Name: "ws_connections", Help: "x"})
+	peer.MustRegister(requests, connections)
+	requests.WithLabelValues("GET", "/prefork-test", "200").Inc()
+	connections.Set(2)
+	listener, err := net.Listen("unix", filepath.Join(dir, "1.sock"))
+	assert.NoError(t, err)
+	peerServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, _ := peer.Gather()
+		encoder := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeProtoDelim))
+		for _, family := range families {
+			encoder.Encode(family)
+		}
+	})}
+	go peerServer.Serve(listener)
+	defer peerServer.Close()
+
+	// This process served two of the same requests and holds one connection
+	metrics.HTTPRequests.WithLabelValues("GET", "/prefork-test", "200").Add(2)
+	metrics.WSConnections.Inc()
+	defer metrics.WSConnections.Dec()
+	ownConnections := testutil.ToFloat64(metrics.WSConnections)
+
+	stop, err := metrics.Share(dir)
+	assert.NoError(t, err)
+	defer stop()
+
+	families, err := metrics.Gather(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, counterValue(families, "http_requests_total", "/prefork-test"))
+	assert.Equal(t, ownConnections+2, gaugeValue(families, "ws_connections"))
+
+	// A process that has exited is skipped
+	peerServer.Close()
+	families, err = metrics.Gather(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, counterValue(families, "http_requests_total", "/prefork-test"))
+}
+
+func histogramCount(t *testing.T, vec *prometheus.HistogramVec, labels ...string) uint64 {
+	var metric dto.Metric
+	observer, _ := vec.GetMetricWithLabelValues(labels...)
+	assert.NoError(t, observer.(prometheus.Metric).Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func counterValue(families []*dto.MetricFamily, name, route string) float64 {
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.Metric {
+			for _, label := range metric.Label {
+				if label.GetName() == "route" && label.GetValue() == route {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func gaugeValue(families []*dto.MetricFamily, name string) float64 {
+	for _, family := range families {
+		if family.GetName() == name && len(family.Metric) > 0 {
+			return family.Metric[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
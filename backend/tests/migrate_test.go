@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_create_notes.up.sql":   {Data: []byte("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);")},
+		"0001_create_notes.down.sql": {Data: []byte("DROP TABLE notes;")},
+		"0002_seed_notes.up.sql":     {Data: []byte("INSERT INTO notes (body) VALUES ('a');\nINSERT INTO notes (body) VALUES ('b');")},
+		"0002_seed_notes.down.sql":   {Data: []byte("-- nothing to undo\n")},
+		"0003_add_title.up.sql":      {Data: []byte("ALTER TABLE notes ADD COLUMN title TEXT;")},
+	}
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	conn, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	migrator, err := db.NewMigrator(conn, testMigrations())
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	applied, err := migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, applied, 3)
+	assert.Equal(t, []int64{1, 2, 3}, []int64{applied[0].Version, applied[1].Version, applied[2].Version})
+	var notes int64
+	conn.Table("notes").Count(&notes)
+	assert.Equal(t, int64(2), notes)
+	assert.True(t, conn.Migrator().HasColumn("notes", "title"))
+
+	// Applied migrations are not run again
+	applied, err = migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+
+	statuses, err := migrator.Status(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 3)
+	for _, status := range statuses {
+		assert.NotNil(t, status.AppliedAt)
+	}
+
+	// Migrations without a down file cannot be reverted
+	_, err = migrator.Down(ctx, 1)
+	assert.Error(t, err)
+
+	conn.Exec("DELETE FROM schema_migrations WHERE version = 3")
+	reverted, err := migrator.Down(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, reverted, 2)
+	assert.Equal(t, int64(2), reverted[0].Version)
+	assert.False(t, conn.Migrator().HasTable("notes"))
+
+	statuses, _ = migrator.Status(ctx)
+	for _, status := range statuses {
+		assert.Nil(t, status.AppliedAt)
+	}
+}
+
+func TestMigratorRejectsBadFiles(t *testing.T) {
+	conn, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	for name, files := range map[string]fstest.MapFS{
+		"misnamed":  {"create_notes.sql": {Data: []byte("SELECT 1;")}},
+		"down only": {"0001_notes.down.sql": {Data: []byte("SELECT 1;")}},
+		"renamed": {
+			"0001_notes.up.sql": {Data: []byte("SELECT 1;")},
+			"0001_other.up.sql": {Data: []byte("SELECT 1;")},
+		},
+	} {
+		_, err := db.NewMigrator(conn, files)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	conn, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	migrator, err := db.NewMigrator(conn, fstest.MapFS{
+		"0001_ok.up.sql":     {Data: []byte("CREATE TABLE ok (id INTEGER);")},
+		"0002_broken.up.sql": {Data: []byte("CREATE TABLE broken (id INTEGER); NOT SQL;")},
+	})
+	assert.NoError(t, err)
+	applied, err := migrator.Up(context.Background())
+	assert.Error(t, err)
+	assert.Len(t, applied, 1)
+	assert.False(t, conn.Migrator().HasTable("broken"))
+	var versions []int64
+	conn.Raw("SELECT version FROM schema_migrations").Scan(&versions)
+	assert.Equal(t, []int64{1}, versions)
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	migrator, err := db.NewMigrator(nil, db.Migrations)
+	assert.NoError(t, err)
+	assert.NotNil(t, migrator)
+}
+
+// baselineSchema is the schema AutoMigrate created before versioned
+// migrations, with one row in every table
+const baselineSchema = `
+CREATE TABLE users (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    email TEXT NOT NULL,
+    password TEXT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_users_username ON users (username);
+CREATE UNIQUE INDEX idx_users_email ON users (email);
+CREATE TABLE posts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    caption TEXT,
+    media_url TEXT NOT NULL,
+    flagged BOOLEAN DEFAULT false,
+    created_at TIMESTAMPTZ
+);
+CREATE INDEX idx_posts_user_id ON posts (user_id);
+CREATE TABLE likes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    post_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE TABLE follows (
+    id BIGSERIAL PRIMARY KEY,
+    follower_id BIGINT NOT NULL,
+    following_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+CREATE TABLE comments (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    text TEXT NOT NULL,
+    created_at TIMESTAMPTZ
+);
+INSERT INTO users (username, email, password, created_at) VALUES ('Alice', 'Alice@Example.com', 'hash', now()), ('bob', 'bob@example.com', 'hash', now());
+INSERT INTO posts (user_id, caption, media_url, created_at) VALUES (1, 'hello', '1/photo.jpg', now());
+INSERT INTO likes (user_id, post_id, created_at) VALUES (1, 1, now());
+INSERT INTO follows (follower_id, following_id, created_at) VALUES (1, 2, now());
+INSERT INTO comments (post_id, user_id, text, created_at) VALUES (1, 1, 'nice', now());
+`
+
+// postgresSchema connects to TEST_DATABASE_URL with its own schema, dropped
+// when the test ends, and skips the test when no database is configured
+func postgresSchema(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	require.NoError(t, admin.Exec("CREATE SCHEMA "+schema).Error)
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	u, err := url.Parse(dsn)
+	require.NoError(t, err)
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	conn, err := gorm.Open(postgres.Open(u.String()), &gorm.Config{})
+	require.NoError(t, err)
+	return conn
+}
+
+func TestEmbeddedMigrationsUpgradeBaselineDatabase(t *testing.T) {
+	conn := postgresSchema(t)
+	require.NoError(t, conn.Exec(baselineSchema).Error)
+
+	migrator, err := db.NewMigrator(conn, db.Migrations)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	schema := conn.Migrator()
+	for table, columns := range map[string][]string{
+		"users":    {"is_private", "is_moderator", "email_verified_at"},
+		"posts":    {"media_key", "media_type", "edited_at", "deleted_at", "rendition_thumb", "rendition_medium", "rendition_full"},
+		"likes":    {"deleted_at"},
+		"comments": {"deleted_at"},
+	} {
+		for _, column := range columns {
+			assert.True(t, schema.HasColumn(table, column), "%s.%s", table, column)
+		}
+	}
+	assert.True(t, schema.HasIndex("posts", "idx_posts_media_key"))
+	assert.True(t, schema.HasIndex("comments", "idx_comments_deleted_at"))
+	assert.True(t, schema.HasIndex("users", "idx_users_email_lower"))
+	for _, table := range []string{"post_media", "media", "media_owners", "rate_limits", "auth_tokens", "login_failures", "upload_sessions"} {
+		assert.True(t, schema.HasTable(table), table)
+	}
+
+	// Existing rows survive and get the new columns' defaults
+	var post struct {
+		MediaType string
+		Caption   string
+	}
+	require.NoError(t, conn.Raw("SELECT media_type, caption FROM posts WHERE id = 1").Scan(&post).Error)
+	assert.Equal(t, "image", post.MediaType)
+	assert.Equal(t, "hello", post.Caption)
+	var email string
+	conn.Raw("SELECT email FROM users WHERE id = 1").Scan(&email)
+	assert.Equal(t, "alice@example.com", email)
+	var comments int64
+	conn.Table("comments").Count(&comments)
+	assert.Equal(t, int64(1), comments)
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupPostEditApp(t *testing.T) *fiber.App {
+	if os.Getenv("JWT_SECRET") == "" {
+		os.Setenv("JWT_SECRET", "test-secret-key-12345")
+	}
+	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db.DB.AutoMigrate(&models.User{}, &models.Follow{}, &models.Post{}, &models.PostMedia{}, &models.PostRevision{})
+	server := newServer(t, testServices())
+	app := fiber.New()
+	server.RegisterPostRoutes(app)
+	server.RegisterWebSocketRoutes(app)
+	return app
+}
+
+// patchPost sends an edit to a post as userID
+func patchPost(t *testing.T, app *fiber.App, postID, userID uint, edit interface{}) *http.Response {
+	body, _ := json.Marshal(edit)
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/api/posts/%d", postID), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(userID, fmt.Sprintf("user%d", userID)))
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestUpdatePostKeepsRevisions(t *testing.T) {
+	app := setupPostEditApp(t)
+	db.DB.Create(&models.User{Username: "author", Email: "author@example.com", Password: "x"})
+	post := models.Post{UserID: 1, Caption: "First", MediaURL: "http://media.com/1.jpg", Media: []models.PostMedia{
+		{MediaURL: "http://media.com/1.jpg", AltText: "A cat"},
+		{Position: 1, MediaURL: "http://media.com/2.jpg"},
+	}}
+	db.DB.Create(&post)
+	first, second := post.Media[0].ID, post.Media[1].ID
+
+	// Only the author may edit
+	resp := patchPost(t, app, post.ID, 2, map[string]string{"caption": "Hijacked"})
+	assert.Equal(t, 403, resp.StatusCode)
+	resp = patchPost(t, app, post.ID, 1, map[string]interface{}{"media": []map[string]interface{}{{"id": 999, "alt_text": "x"}}})
+	assert.Equal(t, 400, resp.StatusCode)
+	resp = patchPost(t, app, post.ID, 1, map[string]interface{}{})
+	assert.Equal(t, 400, resp.StatusCode)
+
+	resp = patchPost(t, app, post.ID, 1, map[string]interface{}{
+		"caption": "Second",
+		"media":   []map[string]interface{}{{"id": second, "alt_text": "A dog"}},
+	})
+	assert.Equal(t, 200, resp.StatusCode)
+	var updated models.Post
+	json.NewDecoder(resp.Body).Decode(&updated)
+	assert.Equal(t, "Second", updated.Caption)
+	assert.NotNil(t, updated.EditedAt)
+	assert.Equal(t, "A cat", updated.Media[0].AltText)
+	assert.Equal(t, "A dog", updated.Media[1].AltText)
+
+	// Edits that change nothing leave no revision
+	resp = patchPost(t, app, post.ID, 1, map[string]string{"caption": "Second"})
+	assert.Equal(t, 200, resp.StatusCode)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/posts/%d/revisions", post.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(2, "user2"))
+	resp, _ = app.Test(req)
+	assert.Equal(t, 200, resp.StatusCode)
+	var revisions []models.PostRevision
+	json.NewDecoder(resp.Body).Decode(&revisions)
+	assert.Len(t, revisions, 1)
+	assert.Equal(t, "First", revisions[0].Caption)
+	assert.Equal(t, map[uint]string{first: "A cat", second: ""}, revisions[0].AltTexts)
+	assert.Equal(t, uint(1), revisions[0].EditedBy)
+}
+
+func TestWSEventOnPostUpdate(t *testing.T) {
+	app := setupPostEditApp(t)
+	authorID, followerID, strangerID := uint(11), uint(12), uint(13)
+	post := models.Post{UserID: authorID, Caption: "Before", MediaURL: "http://media.com/ws.jpg"}
+	db.DB.Create(&post)
+	db.DB.Create(&models.Follow{FollowerID: followerID, FollowingID: authorID})
+
+	go app.Listen(":9995")
+	defer app.Shutdown()
+	time.Sleep(100 * time.Millisecond)
+
+	// Both users open the post, but only the follower is notified
+	dial := func(userID uint) *websocket.Conn {
+		headers := make(http.Header)
+		headers.Set("Authorization", "Bearer "+helpers.GenerateJWT(userID, fmt.Sprintf("user%d", userID)))
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:9995/ws", headers)
+		if err != nil {
+			t.Fatalf("WebSocket connection failed: %v", err)
+		}
+		conn.WriteJSON(models.WSEvent{Type: "view_post", Payload: map[string]uint{"post_id": post.ID}})
+		return conn
+	}
+	follower := dial(followerID)
+	defer follower.Close()
+	stranger := dial(strangerID)
+	defer stranger.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	resp := patchPost(t, app, post.ID, authorID, map[string]string{"caption": "After"})
+	assert.Equal(t, 200, resp.StatusCode)
+
+	follower.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var event struct {
+		Type    string                    `json:"type"`
+		Payload models.PostUpdatedPayload `json:"payload"`
+	}
+	assert.NoError(t, follower.ReadJSON(&event))
+	assert.Equal(t, "post_updated", event.Type)
+	assert.Equal(t, post.ID, event.Payload.PostID)
+	assert.Equal(t, "After", event.Payload.Caption)
+
+	stranger.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, _, err := stranger.ReadMessage()
+	assert.Error(t, err)
+}
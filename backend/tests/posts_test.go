@@ -8,7 +8,6 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
 	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/models"
 	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
@@ -16,15 +15,15 @@ import (
 	"gorm.io/gorm"
 )
 
-func setupPostApp() *fiber.App {
+func setupPostApp(t *testing.T) *fiber.App {
 	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	db.DB.AutoMigrate(&models.Post{}, &models.Like{})
+	db.DB.AutoMigrate(&models.Post{}, &models.PostMedia{}, &models.PostRevision{}, &models.Like{}, &models.Comment{})
 	app := fiber.New()
-	api.RegisterPostRoutes(app)
+	newServer(t, testServices()).RegisterPostRoutes(app)
 	return app
 }
 func TestToggleLike(t *testing.T) {
-	app := setupPostApp()
+	app := setupPostApp(t)
 	// Create a post
 	post := models.Post{UserID: 1, Caption: "Like Me", MediaURL: "http://media.com/like.jpg"}
 	db.DB.Create(&post)
@@ -56,7 +55,7 @@ func TestToggleLike(t *testing.T) {
 }
 
 func TestCreateAndGetPost(t *testing.T) {
-	app := setupPostApp()
+	app := setupPostApp(t)
 	postBody := models.Post{UserID: 1, Caption: "Hello World", MediaURL: "http://media.com/1.jpg"}
 	body, _ := json.Marshal(postBody)
 	token := helpers.GenerateJWT(1, "user1")
@@ -104,11 +103,17 @@ func TestCreateAndGetPost(t *testing.T) {
 }
 
 func TestDeletePost(t *testing.T) {
-	app := setupPostApp()
+	app := setupPostApp(t)
 	post := models.Post{UserID: 2, Caption: "Delete Me", MediaURL: "http://media.com/2.jpg"}
 	db.DB.Create(&post)
 	token := helpers.GenerateJWT(2, "user2")
 
+	// Only the author may delete it
+	reqOther := httptest.NewRequest("DELETE", "/api/posts/1", nil)
+	reqOther.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(3, "user3"))
+	respOther, _ := app.Test(reqOther)
+	assert.Equal(t, 403, respOther.StatusCode)
+
 	// Delete post
 	reqDel := httptest.NewRequest("DELETE", "/api/posts/1", nil)
 	reqDel.Header.Set("Authorization", "Bearer "+token)
@@ -120,4 +125,10 @@ func TestDeletePost(t *testing.T) {
 	reqGet.Header.Set("Authorization", "Bearer "+token)
 	respGet, _ := app.Test(reqGet)
 	assert.Equal(t, 404, respGet.StatusCode)
+
+	// A deleted post cannot be deleted again
+	reqAgain := httptest.NewRequest("DELETE", "/api/posts/1", nil)
+	reqAgain.Header.Set("Authorization", "Bearer "+token)
+	respAgain, _ := app.Test(reqAgain)
+	assert.Equal(t, 404, respAgain.StatusCode)
 }
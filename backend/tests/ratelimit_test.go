@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/config"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRateLimitStores(t *testing.T) {
+	conn, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, conn.AutoMigrate(&models.RateLimit{}))
+
+	stores := map[string]ratelimit.Store{
+		ratelimit.StoreMemory:   ratelimit.NewMemoryStore(),
+		ratelimit.StorePostgres: ratelimit.NewPostgresStore(conn),
+	}
+	limit := ratelimit.Limit{Max: 5, Window: 200 * time.Millisecond}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			result, err := store.Take(ctx, "k", 3, limit)
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, int64(2), result.Remaining)
+
+			// A denied take does not count
+			result, err = store.Take(ctx, "k", 3, limit)
+			assert.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, int64(2), result.Remaining)
+			assert.WithinDuration(t, time.Now().Add(limit.Window), result.Reset, limit.Window)
+
+			result, _ = store.Take(ctx, "k", 2, limit)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, int64(0), result.Remaining)
+			result, _ = store.Take(ctx, "other", 1, limit)
+			assert.True(t, result.Allowed, "keys are counted apart")
+
+			time.Sleep(limit.Window + 50*time.Millisecond)
+			result, _ = store.Take(ctx, "k", 1, limit)
+			assert.True(t, result.Allowed, "a new window starts once the last one ended")
+			assert.Equal(t, int64(4), result.Remaining)
+
+			// Purged right away, before the new window of "k" ends too
+			if postgres, ok := store.(*ratelimit.PostgresStore); ok {
+				purged, err := postgres.PurgeExpired(ctx)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), purged, "only the window of \"other\" has ended")
+			}
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ratelimit.ParseLimit("10/15m")
+	assert.NoError(t, err)
+	assert.Equal(t, ratelimit.Limit{Max: 10, Window: 15 * time.Minute}, limit)
+
+	limit, err = ratelimit.ParseLimit("off")
+	assert.NoError(t, err)
+	assert.False(t, limit.Enabled())
+
+	for _, bad := range []string{"10", "ten/1m", "10/soon", "-1/1m", "10/0s"} {
+		_, err := ratelimit.ParseLimit(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func loginAttempt(app *fiber.App, ip, username string) *http.Response {
+	body, _ := json.Marshal(models.AuthRequest{Username: username, Password: "wrong"})
+	req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", ip)
+	resp, _ := app.Test(req)
+	return resp
+}
+
+func TestLoginIsRateLimitedPerUsernameAndIP(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret-key-12345", TokenTTL: time.Hour}
+	cfg.RateLimits = ratelimit.Config{
+		LoginPerIP:       ratelimit.Limit{Max: 5, Window: time.Minute},
+		LoginPerUsername: ratelimit.Limit{Max: 2, Window: time.Minute},
+	}
+	server := api.NewServer(cfg, &repository.Repositories{Users: &fakeUsers{}, LoginFailures: &fakeLoginFailures{}}, testServices())
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	server.RegisterAuthRoutes(app)
+
+	first := loginAttempt(app, "198.51.100.1", "alice")
+	assert.Equal(t, fiber.StatusUnauthorized, first.StatusCode)
+	assert.Equal(t, "2", first.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", first.Header.Get("RateLimit-Policy"))
+
+	// The username is counted across addresses and regardless of case
+	assert.Equal(t, fiber.StatusUnauthorized, loginAttempt(app, "198.51.100.2", "Alice").StatusCode)
+	limited := loginAttempt(app, "198.51.100.3", "alice")
+	assert.Equal(t, fiber.StatusTooManyRequests, limited.StatusCode)
+	assert.Equal(t, "0", limited.Header.Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, limited.Header.Get(fiber.HeaderRetryAfter))
+
+	// One address trying many usernames runs out too
+	for i, name := range []string{"bob", "carol", "dave", "erin"} {
+		assert.Equal(t, fiber.StatusUnauthorized, loginAttempt(app, "198.51.100.1", name).StatusCode, i)
+	}
+	assert.Equal(t, fiber.StatusTooManyRequests, loginAttempt(app, "198.51.100.1", "frank").StatusCode)
+	assert.Equal(t, fiber.StatusUnauthorized, loginAttempt(app, "198.51.100.9", "frank").StatusCode)
+}
+
+func TestUploadBytesAreRateLimited(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", int64(7))
+		return c.Next()
+	})
+	app.Put("/upload", ratelimit.Middleware(ratelimit.NewMemoryStore(), ratelimit.Rule{
+		Policy: "upload_bytes",
+		Limit:  ratelimit.Limit{Max: 10, Window: time.Hour},
+		Key:    ratelimit.ByUser,
+		Cost:   ratelimit.BodySize,
+	}), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	upload := func(size int) int {
+		resp, _ := app.Test(httptest.NewRequest("PUT", "/upload", strings.NewReader(strings.Repeat("x", size))))
+		return resp.StatusCode
+	}
+	assert.Equal(t, fiber.StatusTooManyRequests, upload(11), "more than a whole window is never allowed")
+	assert.Equal(t, fiber.StatusNoContent, upload(6))
+	assert.Equal(t, fiber.StatusTooManyRequests, upload(6))
+	assert.Equal(t, fiber.StatusNoContent, upload(4))
+}
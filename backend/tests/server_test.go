@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/config"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/mail"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/ratelimit"
+	"github.com/umutdeveloper/instagram-light/backend/repository"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+// testJWTSecret signs tokens in tests that do not set JWT_SECRET themselves
+const testJWTSecret = "test-secret-key-12345"
+
+// testSigner signs media URLs the way servers built on testServices verify them
+var testSigner = storage.NewSigner("media-test-secret", storage.DefaultMediaURLTTL)
+
+// testServices keeps media in memory, signs media URLs with a test secret and
+// only logs mail
+func testServices() api.Services {
+	return api.Services{
+		Media:  storage.NewMemoryStorage("http://cdn.test"),
+		Signer: testSigner,
+		Mail:   mail.NewLogMailer(mail.DefaultFrom),
+		WS:     utils.NewWSManager(),
+		Limits: ratelimit.NewMemoryStore(),
+	}
+}
+
+// newServer returns a Server on the test database, configured from the
+// environment like the real one. JWT_SECRET is set for the test if missing.
+func newServer(t *testing.T, services api.Services) *api.Server {
+	if os.Getenv("JWT_SECRET") == "" {
+		t.Setenv("JWT_SECRET", testJWTSecret)
+	}
+	cfg, err := config.FromEnv()
+	require.NoError(t, err)
+	return api.NewServer(cfg, repository.NewGorm(db.DB), services)
+}
+
+// fakeUsers keeps users in memory; methods the tests don't need panic
+// through the embedded nil interface
+type fakeUsers struct {
+	repository.Users
+	users []models.User
+}
+
+func (f *fakeUsers) Create(ctx context.Context, user *models.User) error {
+	user.ID = uint(len(f.users) + 1)
+	f.users = append(f.users, *user)
+	return nil
+}
+
+func (f *fakeUsers) ByID(ctx context.Context, id uint) (*models.User, error) {
+	for _, user := range f.users {
+		if user.ID == id {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (f *fakeUsers) ByUsername(ctx context.Context, username string) (*models.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Username, username) {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (f *fakeUsers) ByUsernameOrEmail(ctx context.Context, identifier string) (*models.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Username, identifier) || strings.EqualFold(user.Email, identifier) {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// fakeLoginFailures counts failed logins in memory
+type fakeLoginFailures struct {
+	repository.LoginFailures
+	failures map[string]*models.LoginFailure
+}
+
+func (f *fakeLoginFailures) ByHash(ctx context.Context, hash string) (*models.LoginFailure, error) {
+	if failure, ok := f.failures[hash]; ok {
+		return failure, nil
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (f *fakeLoginFailures) Record(ctx context.Context, hash string, at time.Time, window time.Duration) (int, error) {
+	if f.failures == nil {
+		f.failures = map[string]*models.LoginFailure{}
+	}
+	failure, ok := f.failures[hash]
+	if !ok || !failure.LastFailedAt.After(at.Add(-window)) {
+		failure = &models.LoginFailure{IdentifierHash: hash}
+		f.failures[hash] = failure
+	}
+	failure.Failures++
+	failure.LastFailedAt = at
+	return failure.Failures, nil
+}
+
+func (f *fakeLoginFailures) LockUntil(ctx context.Context, hash string, until time.Time) error {
+	f.failures[hash].LockedUntil = &until
+	return nil
+}
+
+func (f *fakeLoginFailures) Reset(ctx context.Context, hashes ...string) error {
+	for _, hash := range hashes {
+		delete(f.failures, hash)
+	}
+	return nil
+}
+
+// fakeAuthTokens keeps the tokens mailed at registration
+type fakeAuthTokens struct {
+	repository.AuthTokens
+	mu     sync.Mutex
+	tokens []models.AuthToken
+}
+
+func (f *fakeAuthTokens) Create(ctx context.Context, token *models.AuthToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, *token)
+	return nil
+}
+
+type fakeFollows struct {
+	repository.Follows
+	users *fakeUsers
+	// follower ID -> followed IDs
+	following map[uint][]uint
+}
+
+func (f *fakeFollows) Followers(ctx context.Context, userID uint) ([]models.User, error) {
+	var followers []models.User
+	for followerID, followed := range f.following {
+		for _, id := range followed {
+			if id == userID {
+				user, _ := f.users.ByID(ctx, followerID)
+				followers = append(followers, *user)
+			}
+		}
+	}
+	return followers, nil
+}
+
+func TestServerWithFakeRepositories(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	cfg := &config.Config{JWTSecret: "test-secret-key-12345", TokenTTL: time.Hour}
+	users := &fakeUsers{}
+	follows := &fakeFollows{users: users, following: map[uint][]uint{}}
+	tokens := &fakeAuthTokens{}
+	server := api.NewServer(cfg, &repository.Repositories{
+		Users: users, Follows: follows, AuthTokens: tokens, LoginFailures: &fakeLoginFailures{},
+	}, testServices())
+	app := fiber.New()
+	server.RegisterAuthRoutes(app)
+	server.RegisterUserRoutes(app)
+
+	for _, name := range []string{"alice", "bob"} {
+		body, _ := json.Marshal(models.AuthRequest{Username: name, Email: name + "@example.com", Password: "secret123"})
+		req := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req)
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+	assert.Len(t, users.users, 2)
+	assert.NotEqual(t, "secret123", users.users[0].Password, "password is stored hashed")
+	assert.NoError(t, server.Drain(context.Background()))
+	assert.Len(t, tokens.tokens, 2, "each new user is mailed a verification link")
+
+	body, _ := json.Marshal(models.AuthRequest{Username: "alice", Password: "secret123"})
+	req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	body, _ = json.Marshal(models.AuthRequest{Username: "alice", Password: "wrong"})
+	req = httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ = app.Test(req)
+	assert.Equal(t, 401, resp.StatusCode)
+
+	// bob follows alice
+	follows.following[2] = []uint{1}
+	token := helpers.GenerateJWT(1, "alice")
+	req = httptest.NewRequest("GET", "/api/users/1/followers", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, _ = app.Test(req)
+	assert.Equal(t, 200, resp.StatusCode)
+	var followers []models.User
+	json.NewDecoder(resp.Body).Decode(&followers)
+	if assert.Len(t, followers, 1) {
+		assert.Equal(t, "bob", followers[0].Username)
+		assert.Empty(t, followers[0].Password)
+	}
+
+	req = httptest.NewRequest("GET", "/api/users/7", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, _ = app.Test(req)
+	assert.Equal(t, 404, resp.StatusCode)
+	var errResp models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	assert.Equal(t, "User not found", errResp.Error)
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+)
+
+// exerciseStorage runs the behaviour every Storage implementation must share
+func exerciseStorage(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	data := []byte("hello media")
+
+	ok, err := s.Exists(ctx, "a/b.jpg")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, err = s.Open(ctx, "a/b.jpg")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	assert.NoError(t, s.Put(ctx, "a/b.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"))
+	ok, err = s.Exists(ctx, "a/b.jpg")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	rc, err := s.Open(ctx, "a/b.jpg")
+	assert.NoError(t, err)
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, data, got)
+
+	u, err := s.URL(ctx, "a/b.jpg")
+	assert.NoError(t, err)
+	key, ok := s.KeyForURL(u)
+	assert.True(t, ok)
+	assert.Equal(t, "a/b.jpg", key)
+
+	assert.NoError(t, s.Delete(ctx, "a/b.jpg"))
+	ok, _ = s.Exists(ctx, "a/b.jpg")
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, s.Put(ctx, "../escape.jpg", bytes.NewReader(data), int64(len(data)), "image/jpeg"), storage.ErrInvalidKey)
+	_, ok = s.KeyForURL("http://elsewhere.example.com/a/b.jpg")
+	assert.False(t, ok)
+}
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	s := storage.NewLocalStorage(root, "media")
+	exerciseStorage(t, s)
+
+	u, _ := s.URL(context.Background(), "x.jpg")
+	assert.Equal(t, "media/x.jpg", u)
+	// Raw paths returned by uploads before the storage layer still resolve
+	key, ok := s.KeyForURL(root + "/x.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, "x.jpg", key)
+}
+
+func TestMemoryStorage(t *testing.T) {
+	exerciseStorage(t, storage.NewMemoryStorage("http://cdn.test"))
+}
+
+func TestS3StorageSignedURLs(t *testing.T) {
+	fake := helpers.NewFakeS3("media")
+	defer fake.Close()
+
+	s, err := storage.NewS3Storage(storage.S3Config{
+		Endpoint:        fake.Endpoint(),
+		Bucket:          "media",
+		AccessKeyID:     "test",
+		SecretAccessKey: "testsecret",
+		Region:          "us-east-1",
+	})
+	assert.NoError(t, err)
+	exerciseStorage(t, s)
+
+	assert.NoError(t, s.Put(context.Background(), "signed.jpg", bytes.NewReader([]byte("x")), 1, "image/jpeg"))
+	stored, ok := fake.Object("signed.jpg")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("x"), stored)
+
+	raw, err := s.URL(context.Background(), "signed.jpg")
+	assert.NoError(t, err)
+	u, err := url.Parse(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "/media/signed.jpg", u.Path)
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Expires"))
+}
+
+func TestS3StoragePublicURLs(t *testing.T) {
+	fake := helpers.NewFakeS3("media")
+	defer fake.Close()
+
+	s, err := storage.NewS3Storage(storage.S3Config{
+		Endpoint:  fake.Endpoint(),
+		Bucket:    "media",
+		Region:    "us-east-1",
+		PublicURL: "https://cdn.example.com/",
+	})
+	assert.NoError(t, err)
+	u, err := s.URL(context.Background(), "p/1.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/p/1.jpg", u)
+	key, ok := s.KeyForURL(u)
+	assert.True(t, ok)
+	assert.Equal(t, "p/1.jpg", key)
+}
+
+func TestRenditionKeys(t *testing.T) {
+	assert.Equal(t, "1_a_thumb.jpg", storage.RenditionKey("1_a.jpg", "thumb"))
+	assert.Equal(t, "1_a.jpg", storage.RenditionKey("1_a.jpg", "full"))
+
+	ctx := context.Background()
+	s := storage.NewMemoryStorage("http://cdn.test")
+	s.Put(ctx, "v.mp4", bytes.NewReader([]byte("v")), 1, "video/mp4")
+	r, err := storage.RenditionKeys(ctx, s, "v.mp4")
+	assert.NoError(t, err)
+	// Videos have no generated sizes, so every rendition is the original
+	assert.Equal(t, "v.mp4", r.Thumb)
+	assert.Equal(t, "v.mp4", r.Full)
+
+	s.Put(ctx, "i.jpg", bytes.NewReader([]byte("i")), 1, "image/jpeg")
+	s.Put(ctx, "i_thumb.jpg", bytes.NewReader([]byte("t")), 1, "image/jpeg")
+	r, err = storage.RenditionKeys(ctx, s, "i.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "i_thumb.jpg", r.Thumb)
+	assert.Equal(t, "i.jpg", r.Medium)
+}
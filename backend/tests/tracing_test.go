@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/middleware"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/tracing"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// recordSpans makes a provider sampling every span the global one for the
+// rest of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func spanNamed(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestRequestSpanContinuesCallerTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	database.AutoMigrate(&models.User{})
+	assert.NoError(t, tracing.InstrumentDB(database))
+
+	app := fiber.New()
+	app.Use(middleware.RequestID())
+	app.Use(tracing.Middleware())
+	app.Get("/users/:id", func(c *fiber.Ctx) error {
+		var user models.User
+		if err := database.WithContext(c.UserContext()).First(&user, c.Params("id")).Error; err != nil {
+			return fiber.ErrNotFound
+		}
+		return c.JSON(user)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	spans := recorder.Ended()
+	request := spanNamed(spans, "GET /users/:id")
+	if assert.NotNil(t, request) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+		assert.Equal(t, trace.SpanKindServer, request.SpanKind())
+	}
+	query := spanNamed(spans, "db.query users")
+	if assert.NotNil(t, query) && request != nil {
+		assert.Equal(t, request.SpanContext().SpanID(), query.Parent().SpanID())
+		// A missing row is not a failed query
+		assert.Empty(t, query.Events())
+	}
+}
+
+func TestUnmatchedRouteSpanName(t *testing.T) {
+	recorder := recordSpans(t)
+	app := fiber.New()
+	app.Use(tracing.Middleware())
+
+	_, err := app.Test(httptest.NewRequest(http.MethodGet, "/wp-admin/setup.php", nil))
+	assert.NoError(t, err)
+	assert.NotNil(t, spanNamed(recorder.Ended(), "GET unmatched"))
+}
+
+func TestAIClientPropagatesTraceContext(t *testing.T) {
+	recorder := recordSpans(t)
+	var traceparent string
+	ai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"nsfw": false, "score": 0.1}`))
+	}))
+	defer ai.Close()
+
+	ctx, parent := tracing.Start(context.Background(), "test")
+	_, err := utils.AIService{URL: ai.URL, BackendURL: "http://backend"}.ModerateImage(ctx, "media/1.jpg")
+	parent.End()
+	assert.NoError(t, err)
+
+	span := spanNamed(recorder.Ended(), "ai.moderate_image")
+	if assert.NotNil(t, span) {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+		assert.Contains(t, traceparent, span.SpanContext().SpanID().String())
+	}
+}
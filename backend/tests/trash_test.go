@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/config"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+)
+
+// postRequest sends an empty request to a post endpoint as userID
+func postRequest(t *testing.T, app *fiber.App, method, path string, userID uint) *http.Response {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(userID, fmt.Sprintf("user%d", userID)))
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestDeletedPostCanBeRestored(t *testing.T) {
+	app := setupPostApp(t)
+	newServer(t, testServices()).RegisterCommentRoutes(app)
+	post := models.Post{UserID: 1, Caption: "Oops", MediaURL: "http://media.com/oops.jpg"}
+	db.DB.Create(&post)
+	db.DB.Create(&models.Like{UserID: 2, PostID: post.ID})
+	kept := models.Comment{PostID: post.ID, UserID: 2, Text: "kept"}
+	removed := models.Comment{PostID: post.ID, UserID: 2, Text: "removed earlier"}
+	db.DB.Create(&kept)
+	db.DB.Create(&removed)
+	db.DB.Delete(&removed)
+
+	path := fmt.Sprintf("/api/posts/%d", post.ID)
+	assert.Equal(t, 409, postRequest(t, app, "POST", path+"/restore", 1).StatusCode)
+	assert.Equal(t, 204, postRequest(t, app, "DELETE", path, 1).StatusCode)
+
+	// The post, its comments and its likes are hidden
+	assert.Equal(t, 404, postRequest(t, app, "GET", path, 1).StatusCode)
+	assert.Equal(t, 404, postRequest(t, app, "POST", path+"/like", 2).StatusCode)
+	var comments, likes int64
+	db.DB.Model(&models.Comment{}).Where("post_id = ?", post.ID).Count(&comments)
+	db.DB.Model(&models.Like{}).Where("post_id = ?", post.ID).Count(&likes)
+	assert.Zero(t, comments)
+	assert.Zero(t, likes)
+	req := httptest.NewRequest("POST", path+"/comments", strings.NewReader(`{"text":"late"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(2, "user2"))
+	resp, _ := app.Test(req)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Only the author can restore
+	assert.Equal(t, 404, postRequest(t, app, "POST", path+"/restore", 2).StatusCode)
+	assert.Equal(t, 200, postRequest(t, app, "POST", path+"/restore", 1).StatusCode)
+	assert.Equal(t, 200, postRequest(t, app, "GET", path, 1).StatusCode)
+
+	// Comments deleted before the post stay deleted
+	var restored []models.Comment
+	db.DB.Where("post_id = ?", post.ID).Find(&restored)
+	assert.Len(t, restored, 1)
+	assert.Equal(t, kept.ID, restored[0].ID)
+	db.DB.Model(&models.Like{}).Where("post_id = ?", post.ID).Count(&likes)
+	assert.Equal(t, int64(1), likes)
+}
+
+func TestTrashIsPurgedAfterRetention(t *testing.T) {
+	app := setupPostApp(t)
+	post := models.Post{UserID: 1, Caption: "Gone", MediaURL: "http://media.com/gone.jpg"}
+	db.DB.Create(&post)
+	db.DB.Create(&models.Comment{PostID: post.ID, UserID: 2, Text: "bye"})
+	path := fmt.Sprintf("/api/posts/%d", post.ID)
+	assert.Equal(t, 204, postRequest(t, app, "DELETE", path, 1).StatusCode)
+
+	// Nothing is purged within the retention period
+	purged, err := newServer(t, testServices()).PurgeTrash(context.Background(), time.Hour)
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+
+	// Past it the post can no longer be restored and is purged
+	db.DB.Unscoped().Model(&models.Post{}).Where("id = ?", post.ID).UpdateColumn("deleted_at", time.Now().Add(-31*24*time.Hour))
+	assert.Equal(t, 410, postRequest(t, app, "POST", path+"/restore", 1).StatusCode)
+	purged, err = newServer(t, testServices()).PurgeTrash(context.Background(), config.DefaultTrashRetention)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	var posts, comments int64
+	db.DB.Unscoped().Model(&models.Post{}).Count(&posts)
+	db.DB.Unscoped().Model(&models.Comment{}).Count(&comments)
+	assert.Zero(t, posts)
+	assert.Zero(t, comments)
+	assert.Equal(t, 404, postRequest(t, app, "POST", path+"/restore", 1).StatusCode)
+}
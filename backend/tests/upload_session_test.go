@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupUploadSessionApp(t *testing.T) (*fiber.App, *api.Server, *storage.MemoryStorage) {
+	t.Setenv("JWT_SECRET", "test-secret-key-12345")
+	t.Setenv("UPLOAD_CHUNK_SIZE", "64")
+	services := testServices()
+	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db.DB.AutoMigrate(&models.UploadSession{}, &models.UploadChunk{}, &models.Media{}, &models.MediaOwner{}, &models.BannedMedia{})
+	server := newServer(t, services)
+	app := fiber.New()
+	server.RegisterRoutes(app)
+	return app, server, services.Media.(*storage.MemoryStorage)
+}
+
+// sessionRequest sends an authenticated request for user 1 to the upload session API
+func sessionRequest(t *testing.T, app *fiber.App, method, path string, body io.Reader, headers map[string]string) *http.Response {
+	req := httptest.NewRequest(method, "/api/upload/sessions"+path, body)
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(1, "user1"))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func createUploadSession(t *testing.T, app *fiber.App, size int) models.UploadSessionResponse {
+	body := fmt.Sprintf(`{"filename":"clip.mp4","size":%d}`, size)
+	resp := sessionRequest(t, app, "POST", "", strings.NewReader(body), map[string]string{"Content-Type": "application/json"})
+	assert.Equal(t, 201, resp.StatusCode)
+	var session models.UploadSessionResponse
+	json.NewDecoder(resp.Body).Decode(&session)
+	return session
+}
+
+func putChunk(t *testing.T, app *fiber.App, id string, offset int, chunk []byte) *http.Response {
+	return sessionRequest(t, app, "PUT", "/"+id, bytes.NewReader(chunk), map[string]string{"Upload-Offset": fmt.Sprint(offset)})
+}
+
+func completeUpload(t *testing.T, app *fiber.App, id string, data []byte) *http.Response {
+	sum := sha256.Sum256(data)
+	body := fmt.Sprintf(`{"sha256":"%s"}`, hex.EncodeToString(sum[:]))
+	return sessionRequest(t, app, "POST", "/"+id+"/complete", strings.NewReader(body), map[string]string{"Content-Type": "application/json"})
+}
+
+// completeUploadWithPoster finishes an upload as a multipart request carrying
+// a poster image
+func completeUploadWithPoster(t *testing.T, app *fiber.App, id string, data, poster []byte) *http.Response {
+	sum := sha256.Sum256(data)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("sha256", hex.EncodeToString(sum[:]))
+	part, _ := writer.CreateFormFile("poster", "poster.png")
+	part.Write(poster)
+	writer.Close()
+	return sessionRequest(t, app, "POST", "/"+id+"/complete", body, map[string]string{"Content-Type": writer.FormDataContentType()})
+}
+
+func TestResumableUpload(t *testing.T) {
+	app, _, mem := setupUploadSessionApp(t)
+	video := helpers.MP4Bytes(640, 360, 2*time.Second, "avc1")
+	session := createUploadSession(t, app, len(video))
+	assert.Equal(t, int64(0), session.Offset)
+	assert.Equal(t, int64(64), session.ChunkSize)
+
+	// Send the first chunks, then "reconnect" and ask where to resume
+	assert.Equal(t, 200, putChunk(t, app, session.ID, 0, video[:64]).StatusCode)
+	assert.Equal(t, 200, putChunk(t, app, session.ID, 64, video[64:128]).StatusCode)
+	// A retried chunk at an old offset is refused with the current offset
+	resp := putChunk(t, app, session.ID, 64, video[64:128])
+	assert.Equal(t, 409, resp.StatusCode)
+	var state models.UploadSessionResponse
+	json.NewDecoder(resp.Body).Decode(&state)
+	assert.Equal(t, int64(128), state.Offset)
+
+	resp = sessionRequest(t, app, "GET", "/"+session.ID, nil, nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	json.NewDecoder(resp.Body).Decode(&state)
+	for offset := int(state.Offset); offset < len(video); offset += 64 {
+		assert.Equal(t, 200, putChunk(t, app, session.ID, offset, video[offset:min(offset+64, len(video))]).StatusCode)
+	}
+
+	// Finalizing with the wrong checksum is rejected and keeps the session
+	resp = completeUpload(t, app, session.ID, []byte("something else"))
+	assert.Equal(t, 400, resp.StatusCode)
+
+	resp = completeUpload(t, app, session.ID, video)
+	assert.Equal(t, 200, resp.StatusCode)
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+	assert.Equal(t, models.MediaTypeVideo, upload.MediaType)
+	key, ok := storage.KeyFromMediaURL(upload.MediaURL)
+	assert.True(t, ok)
+	rc, err := mem.Open(context.Background(), key)
+	assert.NoError(t, err)
+	stored, _ := io.ReadAll(rc)
+	assert.Equal(t, video, stored)
+
+	// The session and its chunks are gone
+	assert.Equal(t, []string{key}, mem.Keys())
+	assert.Equal(t, 404, sessionRequest(t, app, "GET", "/"+session.ID, nil, nil).StatusCode)
+}
+
+func TestResumableUploadWithPoster(t *testing.T) {
+	app, _, _ := setupUploadSessionApp(t)
+	video := helpers.WebMBytes(640, 360, 2*time.Second, "V_VP9")
+	session := createUploadSession(t, app, len(video))
+	for offset := 0; offset < len(video); offset += 64 {
+		assert.Equal(t, 200, putChunk(t, app, session.ID, offset, video[offset:min(offset+64, len(video))]).StatusCode)
+	}
+
+	// A rejected poster keeps the session, so it can be sent again
+	resp := completeUploadWithPoster(t, app, session.ID, video, []byte("not an image"))
+	assert.Equal(t, 415, resp.StatusCode)
+	assert.Equal(t, 200, sessionRequest(t, app, "GET", "/"+session.ID, nil, nil).StatusCode)
+
+	resp = completeUploadWithPoster(t, app, session.ID, video, helpers.PNGBytes(64, 36))
+	assert.Equal(t, 200, resp.StatusCode)
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+	assert.Equal(t, models.MediaTypeVideo, upload.MediaType)
+	posterKey, ok := storage.KeyFromMediaURL(upload.PosterURL)
+	if assert.True(t, ok) {
+		var poster models.Media
+		assert.NoError(t, db.DB.Where("storage_key = ?", posterKey).First(&poster).Error)
+		assert.Equal(t, models.MediaTypeImage, poster.MediaType)
+	}
+}
+
+func TestResumableUploadValidation(t *testing.T) {
+	app, _, _ := setupUploadSessionApp(t)
+	session := createUploadSession(t, app, 100)
+
+	// Chunks must not exceed the chunk size or the announced size
+	assert.Equal(t, 413, putChunk(t, app, session.ID, 0, make([]byte, 65)).StatusCode)
+	assert.Equal(t, 200, putChunk(t, app, session.ID, 0, make([]byte, 64)).StatusCode)
+	assert.Equal(t, 400, putChunk(t, app, session.ID, 64, make([]byte, 40)).StatusCode)
+	// Incomplete uploads cannot be finalized
+	assert.Equal(t, 409, completeUpload(t, app, session.ID, make([]byte, 100)).StatusCode)
+
+	// Sessions belong to their creator
+	req := httptest.NewRequest("GET", "/api/upload/sessions/"+session.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(2, "user2"))
+	resp, _ := app.Test(req)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Oversized files are refused up front
+	resp = sessionRequest(t, app, "POST", "", strings.NewReader(`{"size":999999999999}`), map[string]string{"Content-Type": "application/json"})
+	assert.Equal(t, 413, resp.StatusCode)
+}
+
+func TestAbortAndPurgeUploadSessions(t *testing.T) {
+	app, server, mem := setupUploadSessionApp(t)
+
+	aborted := createUploadSession(t, app, 100)
+	putChunk(t, app, aborted.ID, 0, make([]byte, 64))
+	assert.Equal(t, 204, sessionRequest(t, app, "DELETE", "/"+aborted.ID, nil, nil).StatusCode)
+	assert.Empty(t, mem.Keys())
+
+	abandoned := createUploadSession(t, app, 100)
+	putChunk(t, app, abandoned.ID, 0, make([]byte, 64))
+	active := createUploadSession(t, app, 100)
+	putChunk(t, app, active.ID, 0, make([]byte, 64))
+	db.DB.Model(&models.UploadSession{}).Where("id = ?", abandoned.ID).Update("expires_at", time.Now().Add(-time.Minute))
+
+	// Expired sessions are invisible to clients and removed by the purge
+	assert.Equal(t, 404, sessionRequest(t, app, "GET", "/"+abandoned.ID, nil, nil).StatusCode)
+	purged, err := server.PurgeExpiredUploadSessions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, []string{storage.ChunkKey(active.ID, 0)}, mem.Keys())
+}
@@ -1,35 +1,82 @@
 package tests
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
+	"github.com/umutdeveloper/instagram-light/backend/db"
+	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/storage"
 	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
-func setupUploadApp() *fiber.App {
+// setupUploadApp serves every route with uploads on local disk, returning
+// the storage behind it
+func setupUploadApp(t *testing.T) (*fiber.App, storage.Storage) {
+	services := testServices()
+	services.Media = storage.NewLocalStorage(t.TempDir(), "media")
+	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db.DB.AutoMigrate(&models.Media{}, &models.MediaOwner{}, &models.BannedMedia{})
 	app := fiber.New()
-	api.RegisterRoutes(app)
-	return app
+	newServer(t, services).RegisterRoutes(app)
+	return app, services.Media
+}
+
+// formFile is one file field of a multipart upload
+type formFile struct {
+	field, filename string
+	content         []byte
+}
+
+// uploadFile posts content as a multipart "file" field to /api/upload
+func uploadFile(t *testing.T, app *fiber.App, filename string, content []byte) *http.Response {
+	return uploadForm(t, app, formFile{"file", filename, content})
+}
+
+// uploadForm posts the given file fields to /api/upload
+func uploadForm(t *testing.T, app *fiber.App, files ...formFile) *http.Response {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, f := range files {
+		fileWriter, err := writer.CreateFormFile(f.field, f.filename)
+		assert.NoError(t, err)
+		_, err = fileWriter.Write(f.content)
+		assert.NoError(t, err)
+	}
+	writer.Close()
+
+	token := helpers.GenerateJWT(1, "user1")
+	req := httptest.NewRequest("POST", "/api/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	return resp
 }
 
 func TestUploadMedia(t *testing.T) {
-	app := setupUploadApp()
+	app, _ := setupUploadApp(t)
 
 	// Create a temporary file to upload
 	tmpFile, err := os.CreateTemp("", "testfile-*.jpg")
 	assert.NoError(t, err)
 	defer os.Remove(tmpFile.Name())
-	_, err = tmpFile.WriteString("fake image content")
+	_, err = tmpFile.Write(helpers.PNGBytes(8, 8))
 	assert.NoError(t, err)
 	tmpFile.Close()
 
@@ -66,4 +113,157 @@ func TestUploadMedia(t *testing.T) {
 	err = json.Unmarshal(respBody, &ur)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, ur.MediaURL)
+	// Opaque images are re-encoded as JPEG regardless of the client's filename
+	key, ok := storage.KeyFromMediaURL(ur.MediaURL)
+	assert.True(t, ok)
+	assert.Equal(t, ".jpg", filepath.Ext(key))
+}
+
+func TestUploadMediaStoresRenditions(t *testing.T) {
+	app, store := setupUploadApp(t)
+
+	resp := uploadFile(t, app, "photo.jpg", helpers.WithEXIFOrientation(helpers.JPEGBytes(1600, 800), 1))
+	assert.Equal(t, 200, resp.StatusCode)
+	var ur models.UploadResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&ur))
+	assert.Equal(t, ur.MediaURL, ur.Renditions.Full)
+	assert.NotEqual(t, ur.Renditions.Full, ur.Renditions.Thumb)
+	assert.NotEqual(t, ur.Renditions.Full, ur.Renditions.Medium)
+	for _, u := range []string{ur.Renditions.Thumb, ur.Renditions.Medium, ur.Renditions.Full} {
+		assert.True(t, strings.HasPrefix(u, "media/sha256/"))
+		key, ok := storage.KeyFromMediaURL(u)
+		assert.True(t, ok)
+		rc, err := store.Open(context.Background(), key)
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		assert.False(t, bytes.Contains(data, []byte("GPS")))
+	}
+}
+
+func TestUploadMediaRejectsInvalidFiles(t *testing.T) {
+	app, _ := setupUploadApp(t)
+
+	cases := []struct {
+		name     string
+		content  []byte
+		status   int
+		code     string
+		filename string
+	}{
+		{"plain text", []byte("fake image content"), 415, "unsupported_media_type", "fake.jpg"},
+		{"truncated png", helpers.PNGBytes(8, 8)[:40], 400, "polyglot_file", "broken.png"},
+		{"png with appended script", append(helpers.PNGBytes(8, 8), []byte("<script>alert(1)</script>")...), 400, "polyglot_file", "poly.png"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := uploadFile(t, app, tc.filename, tc.content)
+			assert.Equal(t, tc.status, resp.StatusCode)
+			var errResp models.ErrorResponse
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+			assert.Equal(t, tc.code, errResp.Code)
+			assert.NotEmpty(t, errResp.Error)
+		})
+	}
+}
+
+func TestUploadMediaRejectsOversizedFile(t *testing.T) {
+	t.Setenv("MAX_UPLOAD_SIZE", "100")
+	app, _ := setupUploadApp(t)
+
+	resp := uploadFile(t, app, "big.png", helpers.PNGBytes(64, 64))
+	assert.Equal(t, 413, resp.StatusCode)
+	var errResp models.ErrorResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	assert.Equal(t, "file_too_large", errResp.Code)
+}
+
+func TestUploadVideoWithPoster(t *testing.T) {
+	app, store := setupUploadApp(t)
+	video := helpers.MP4Bytes(1280, 720, 4*time.Second, "avc1")
+
+	resp := uploadForm(t, app,
+		formFile{"file", "clip.mp4", video},
+		formFile{"poster", "poster.png", helpers.PNGBytes(64, 36)},
+	)
+	assert.Equal(t, 200, resp.StatusCode)
+	var upload models.UploadResponse
+	json.NewDecoder(resp.Body).Decode(&upload)
+	assert.Equal(t, models.MediaTypeVideo, upload.MediaType)
+	assert.Equal(t, 1280, upload.Width)
+	assert.Equal(t, 720, upload.Height)
+	assert.Equal(t, int64(4000), upload.DurationMs)
+	assert.Equal(t, "avc1", upload.Codec)
+
+	key, ok := storage.KeyFromMediaURL(upload.MediaURL)
+	assert.True(t, ok)
+	posterKey, ok := storage.KeyFromMediaURL(upload.PosterURL)
+	assert.True(t, ok)
+	assert.NotEqual(t, key, posterKey)
+	exists, _ := store.Exists(context.Background(), posterKey)
+	assert.True(t, exists)
+
+	// Posters belong to videos only and must be images
+	resp = uploadForm(t, app,
+		formFile{"file", "a.png", helpers.PNGBytes(8, 8)},
+		formFile{"poster", "b.png", helpers.PNGBytes(8, 8)},
+	)
+	assert.Equal(t, 400, resp.StatusCode)
+	resp = uploadForm(t, app,
+		formFile{"file", "clip.mp4", video},
+		formFile{"poster", "clip.mp4", video},
+	)
+	assert.Equal(t, 415, resp.StatusCode)
+}
+
+func TestUploadVideoTooLong(t *testing.T) {
+	t.Setenv("MAX_VIDEO_DURATION", "5s")
+	app, _ := setupUploadApp(t)
+
+	resp := uploadFile(t, app, "long.webm", helpers.WebMBytes(640, 360, 6*time.Second, "V_VP9"))
+	assert.Equal(t, 413, resp.StatusCode)
+	var errResp models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	assert.Equal(t, utils.MediaErrTooLong, errResp.Code)
+}
+
+func TestUploadDeduplicatesIdenticalContent(t *testing.T) {
+	app, _ := setupUploadApp(t)
+	image := helpers.PNGBytes(32, 32)
+
+	var first, second models.UploadResponse
+	resp := uploadFile(t, app, "a.png", image)
+	assert.Equal(t, 200, resp.StatusCode)
+	json.NewDecoder(resp.Body).Decode(&first)
+
+	// The same bytes under another name, from another user, share the stored copy
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, _ := writer.CreateFormFile("file", "b.png")
+	fileWriter.Write(image)
+	writer.Close()
+	req := httptest.NewRequest("POST", "/api/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+helpers.GenerateJWT(2, "user2"))
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	json.NewDecoder(resp.Body).Decode(&second)
+
+	firstKey, _ := storage.KeyFromMediaURL(first.MediaURL)
+	secondKey, _ := storage.KeyFromMediaURL(second.MediaURL)
+	assert.Equal(t, firstKey, secondKey)
+	var media []models.Media
+	db.DB.Find(&media)
+	assert.Len(t, media, 1)
+	var owners int64
+	db.DB.Model(&models.MediaOwner{}).Where("media_id = ?", media[0].ID).Count(&owners)
+	assert.Equal(t, int64(2), owners)
+
+	// Different content gets its own key
+	var other models.UploadResponse
+	resp = uploadFile(t, app, "a.png", helpers.PNGBytes(33, 32))
+	json.NewDecoder(resp.Body).Decode(&other)
+	otherKey, _ := storage.KeyFromMediaURL(other.MediaURL)
+	assert.NotEqual(t, firstKey, otherKey)
 }
@@ -3,11 +3,11 @@ package tests
 import (
 	"encoding/json"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
 	"github.com/umutdeveloper/instagram-light/backend/db"
 	"github.com/umutdeveloper/instagram-light/backend/models"
 	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
@@ -21,10 +21,10 @@ func clearTables() {
 	db.DB.Exec("DELETE FROM users")
 }
 
-func setupUserApp() *fiber.App {
+func setupUserApp(t *testing.T) *fiber.App {
 	db.DB.AutoMigrate(&models.User{}, &models.Follow{})
 	app := fiber.New()
-	api.RegisterUserRoutes(app)
+	newServer(t, testServices()).RegisterUserRoutes(app)
 	return app
 }
 
@@ -47,7 +47,7 @@ func setupUserFollowData() {
 func TestGetUserByID(t *testing.T) {
 	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	clearTables()
-	app := setupUserApp()
+	app := setupUserApp(t)
 	// Create a user
 	user := models.User{Username: "testuser", Email: "test@example.com", Password: "secret"}
 	db.DB.Create(&user)
@@ -74,7 +74,7 @@ func TestGetUserByID(t *testing.T) {
 func TestGetFollowersAndFollowing(t *testing.T) {
 	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	clearTables()
-	app := setupUserApp()
+	app := setupUserApp(t)
 	setupUserFollowData()
 
 	// Test followers of alice (id=1)
@@ -123,3 +123,25 @@ func TestGetFollowersAndFollowing(t *testing.T) {
 	json.NewDecoder(respFollowingBob.Body).Decode(&followingBob)
 	assert.Len(t, followingBob, 1)
 }
+
+func TestSearchUsersIgnoresCase(t *testing.T) {
+	db.DB, _ = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	// Postgres compares LIKE patterns case-sensitively; make SQLite do the same
+	db.DB.Exec("PRAGMA case_sensitive_like = ON")
+	clearTables()
+	app := setupUserApp(t)
+	setupUserFollowData()
+	token := helpers.GenerateJWT(1, "alice")
+
+	for _, query := range []string{"ALIce", "Alice@Example.COM"} {
+		req := httptest.NewRequest("GET", "/api/users/search?q="+url.QueryEscape(query), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, _ := app.Test(req)
+		assert.Equal(t, 200, resp.StatusCode, query)
+		var found []models.User
+		json.NewDecoder(resp.Body).Decode(&found)
+		if assert.Len(t, found, 1, query) {
+			assert.Equal(t, "alice", found[0].Username)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
+)
+
+func TestValidateMediaAcceptsWebM(t *testing.T) {
+	data := helpers.WebMBytes(640, 360, 3*time.Second, "V_VP9")
+	info, err := utils.ValidateMedia(data, utils.DefaultMediaLimits())
+	assert.NoError(t, err)
+	assert.Equal(t, "video/webm", info.ContentType)
+	assert.Equal(t, ".webm", info.Ext)
+	assert.False(t, info.IsImage())
+	assert.Equal(t, 640, info.Width)
+	assert.Equal(t, 360, info.Height)
+	assert.Equal(t, 3*time.Second, info.Duration)
+	assert.Equal(t, "V_VP9", info.Codec)
+
+	// Bytes after the segment are not part of the container
+	_, err = utils.ValidateMedia(append(data, []byte("PAYLOAD!trailer")...), utils.DefaultMediaLimits())
+	assert.Error(t, err)
+}
+
+func TestValidateMediaVideoLimits(t *testing.T) {
+	limits := utils.DefaultMediaLimits()
+	limits.MaxVideoDuration = 10 * time.Second
+
+	_, err := utils.ValidateMedia(helpers.MP4Bytes(640, 360, 11*time.Second, "avc1"), limits)
+	assert.Equal(t, utils.MediaErrTooLong, mediaErrorCode(err))
+	_, err = utils.ValidateMedia(helpers.WebMBytes(640, 360, 11*time.Second, "V_VP8"), limits)
+	assert.Equal(t, utils.MediaErrTooLong, mediaErrorCode(err))
+
+	data := helpers.MP4Bytes(640, 360, time.Second, "avc1")
+	limits.MaxVideoSize = int64(len(data) - 1)
+	_, err = utils.ValidateMedia(data, limits)
+	assert.Equal(t, utils.MediaErrTooLarge, mediaErrorCode(err))
+}
+
+func TestValidateMediaRejectsUnplayableVideo(t *testing.T) {
+	_, err := utils.ValidateMedia(helpers.MP4Bytes(640, 360, time.Second, "mp4v"), utils.DefaultMediaLimits())
+	assert.Equal(t, utils.MediaErrUnsupported, mediaErrorCode(err))
+	_, err = utils.ValidateMedia(helpers.WebMBytes(640, 360, time.Second, "V_THEORA"), utils.DefaultMediaLimits())
+	assert.Equal(t, utils.MediaErrUnsupported, mediaErrorCode(err))
+
+	// An MP4 without a movie box has no playable track
+	noMoov := append(helpers.MP4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")), helpers.MP4Box("mdat", make([]byte, 32))...)
+	_, err = utils.ValidateMedia(noMoov, utils.DefaultMediaLimits())
+	assert.Equal(t, utils.MediaErrMalformed, mediaErrorCode(err))
+}
@@ -10,27 +10,39 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
-	"github.com/umutdeveloper/instagram-light/backend/api"
 	"github.com/umutdeveloper/instagram-light/backend/tests/helpers"
+	"github.com/umutdeveloper/instagram-light/backend/utils"
 )
 
 func getMockJWT(userID uint, username string) string {
 	return helpers.GenerateJWT(userID, username)
 }
 
-func setupWSApp() *fiber.App {
+// setupWSApp serves only the WebSocket route, returning the manager it uses
+func setupWSApp(t *testing.T) (*fiber.App, *utils.WSManager) {
 	// Set JWT secret for tests
 	if os.Getenv("JWT_SECRET") == "" {
 		os.Setenv("JWT_SECRET", "test-secret-key-12345")
 	}
 
+	services := testServices()
 	app := fiber.New()
-	api.RegisterWebSocketRoutes(app)
-	return app
+	newServer(t, services).RegisterWebSocketRoutes(app)
+	return app, services.WS
+}
+
+func dialWS(t *testing.T, port string, userID uint) *websocket.Conn {
+	headers := make(http.Header)
+	headers.Set("Authorization", "Bearer "+getMockJWT(userID, "testuser"))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+port+"/ws", headers)
+	if err != nil {
+		t.Fatalf("WebSocket connection failed: %v", err)
+	}
+	return conn
 }
 
 func TestWSEndpoint_Authorized(t *testing.T) {
-	app := setupWSApp()
+	app, _ := setupWSApp(t)
 
 	// Start test server with Fiber
 	go app.Listen(":9999")
@@ -55,7 +67,7 @@ func TestWSEndpoint_Authorized(t *testing.T) {
 }
 
 func TestWSEndpoint_Unauthorized(t *testing.T) {
-	app := setupWSApp()
+	app, _ := setupWSApp(t)
 
 	// Start test server with Fiber
 	go app.Listen(":9998")
@@ -81,3 +93,55 @@ func TestWSEndpoint_Unauthorized(t *testing.T) {
 	// Either connection fails immediately or succeeds with 101 but then closes
 	assert.True(t, err != nil || resp.StatusCode == http.StatusSwitchingProtocols)
 }
+
+func TestWSSendsToEveryConnectionOfAUser(t *testing.T) {
+	app, manager := setupWSApp(t)
+	go app.Listen(":9994")
+	defer app.Shutdown()
+	time.Sleep(200 * time.Millisecond)
+
+	first := dialWS(t, "9994", 321)
+	defer first.Close()
+	second := dialWS(t, "9994", 321)
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, manager.SendToUser("321", fiber.Map{"type": "ping"}))
+	for _, conn := range []*websocket.Conn{first, second} {
+		var event map[string]interface{}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, conn.ReadJSON(&event))
+		assert.Equal(t, "ping", event["type"])
+	}
+
+	// Closing one tab keeps the user connected in the other
+	second.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, manager.SendToUser("321", fiber.Map{"type": "pong"}))
+	var event map[string]interface{}
+	first.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, first.ReadJSON(&event))
+	assert.Equal(t, "pong", event["type"])
+}
+
+func TestWSCloseAllSendsGoingAway(t *testing.T) {
+	app, manager := setupWSApp(t)
+	go app.Listen(":9993")
+	defer app.Shutdown()
+	time.Sleep(200 * time.Millisecond)
+
+	conn := dialWS(t, "9993", 654)
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	manager.CloseAll()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+
+	// New connections are turned away too
+	late := dialWS(t, "9993", 654)
+	defer late.Close()
+	late.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = late.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "got %v", err)
+}
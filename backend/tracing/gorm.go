@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"errors"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// InstrumentDB records a span for every statement db runs on behalf of a
+// traced request. Statements run without one, such as those of the
+// background jobs, are not traced.
+func InstrumentDB(db *gorm.DB) error {
+	cb := db.Callback()
+	// The processors have unexported types, so each is registered by hand
+	errs := []error{
+		cb.Create().Before("gorm:create").Register("tracing:before_create", startStatement("create")),
+		cb.Create().After("gorm:create").Register("tracing:after_create", endStatement),
+		cb.Query().Before("gorm:query").Register("tracing:before_query", startStatement("query")),
+		cb.Query().After("gorm:query").Register("tracing:after_query", endStatement),
+		cb.Update().Before("gorm:update").Register("tracing:before_update", startStatement("update")),
+		cb.Update().After("gorm:update").Register("tracing:after_update", endStatement),
+		cb.Delete().Before("gorm:delete").Register("tracing:before_delete", startStatement("delete")),
+		cb.Delete().After("gorm:delete").Register("tracing:after_delete", endStatement),
+		cb.Row().Before("gorm:row").Register("tracing:before_row", startStatement("row")),
+		cb.Row().After("gorm:row").Register("tracing:after_row", endStatement),
+		cb.Raw().Before("gorm:raw").Register("tracing:before_raw", startStatement("raw")),
+		cb.Raw().After("gorm:raw").Register("tracing:after_raw", endStatement),
+	}
+	return errors.Join(errs...)
+}
+
+func startStatement(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx := tx.Statement.Context
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+		name := "db." + operation
+		if table := tx.Statement.Table; table != "" {
+			name += " " + table
+		}
+		_, span := Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(tx.Dialector.Name()),
+				semconv.DBOperationName(operation),
+				semconv.DBCollectionName(tx.Statement.Table),
+			))
+		tx.InstanceSet(spanKey, span)
+	}
+}
+
+func endStatement(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	// The statement is recorded without its parameters, which may hold
+	// personal data
+	span.SetAttributes(semconv.DBQueryText(tx.Statement.SQL.String()))
+	err := tx.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	End(span, err)
+}
@@ -2,13 +2,19 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/umutdeveloper/instagram-light/backend/logging"
 	"github.com/umutdeveloper/instagram-light/backend/models"
+	"github.com/umutdeveloper/instagram-light/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // isAbsoluteURL checks if a URL is absolute (starts with http:// or https://)
@@ -16,15 +22,29 @@ func isAbsoluteURL(url string) bool {
 	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
 }
 
-// ModerateImage calls the AI service to check for NSFW content
-func ModerateImage(mediaURL string) (*models.AIServiceResponse, error) {
-	aiServiceURL := GetEnv("AI_SERVICE_URL", "http://ai-service:8000")
-	backendURL := GetEnv("BACKEND_URL", "http://backend:8080")
+// AIService is the client of the AI moderation service
+type AIService struct {
+	// URL is the base URL of the AI service
+	URL string
+	// BackendURL is where the AI service reaches this API, for relative media URLs
+	BackendURL string
+}
+
+// ModerateImage calls the AI service to check for NSFW content, passing on
+// the request ID and trace context ctx carries
+func (s AIService) ModerateImage(ctx context.Context, mediaURL string) (result *models.AIServiceResponse, err error) {
+	ctx, span := tracing.Start(ctx, "ai.moderate_image", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		if result != nil {
+			span.SetAttributes(attribute.Bool("ai.nsfw", result.NSFW), attribute.Float64("ai.score", result.Score))
+		}
+		tracing.End(span, err)
+	}()
 
 	// Convert relative path to absolute URL for AI service
 	imageURL := mediaURL
 	if !isAbsoluteURL(imageURL) {
-		imageURL = backendURL + "/" + mediaURL
+		imageURL = s.BackendURL + "/" + mediaURL
 	}
 
 	requestBody := map[string]string{
@@ -35,11 +55,15 @@ func ModerateImage(mediaURL string) (*models.AIServiceResponse, error) {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", aiServiceURL+"/moderate-image", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.URL+"/moderate-image", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	tracing.Inject(ctx, req.Header)
 
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
@@ -47,6 +71,7 @@ func ModerateImage(mediaURL string) (*models.AIServiceResponse, error) {
 		return nil, fmt.Errorf("failed to call AI service: %w", err)
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("AI service returned status %d", resp.StatusCode)
@@ -59,3 +84,20 @@ func ModerateImage(mediaURL string) (*models.AIServiceResponse, error) {
 
 	return &aiResponse, nil
 }
+
+// Ping checks that the AI service is up by calling its status endpoint
+func (s AIService) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/status", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call AI service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("AI service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
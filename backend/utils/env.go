@@ -1,19 +0,0 @@
-package utils
-
-import (
-	"os"
-
-	"github.com/joho/godotenv"
-)
-
-func LoadEnv() error {
-	return godotenv.Load()
-}
-
-func GetEnv(key string, fallback string) string {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		return fallback
-	}
-	return value
-}
@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// Longest-side bounds for each generated rendition
+var renditionSizes = []struct {
+	Name    string
+	MaxSide int
+}{
+	{"thumb", 320},
+	{"medium", 640},
+	{"full", 1080},
+}
+
+const renditionJPEGQuality = 85
+
+// Rendition is one re-encoded size of an uploaded image
+type Rendition struct {
+	Name   string
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// ProcessImage decodes an uploaded image, applies its EXIF orientation and
+// re-encodes it at each rendition size. Re-encoding drops all metadata (EXIF,
+// GPS, XMP, comments). It returns the renditions and the extension they use.
+func ProcessImage(data []byte) ([]Rendition, string, error) {
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode image: %w", err)
+		}
+		if len(anim.Image) > 1 {
+			return processAnimation(anim)
+		}
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	ext, encode := renditionEncoder(format, img)
+	renditions := make([]Rendition, 0, len(renditionSizes))
+	for _, size := range renditionSizes {
+		resized := fitWithin(img, size.MaxSide)
+		var buf bytes.Buffer
+		if err := encode(&buf, resized); err != nil {
+			return nil, "", fmt.Errorf("failed to encode %s rendition: %w", size.Name, err)
+		}
+		bounds := resized.Bounds()
+		renditions = append(renditions, Rendition{
+			Name:   size.Name,
+			Data:   buf.Bytes(),
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		})
+	}
+	return renditions, ext, nil
+}
+
+// processAnimation renders each frame of an animated GIF onto the full canvas
+// and re-encodes the animation at each rendition size, keeping frame delays
+// and the loop count
+func processAnimation(anim *gif.GIF) ([]Rendition, string, error) {
+	canvasBounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	if canvasBounds.Empty() {
+		canvasBounds = anim.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(canvasBounds)
+	outputs := make([]*gif.GIF, len(renditionSizes))
+	for i := range outputs {
+		outputs[i] = &gif.GIF{LoopCount: anim.LoopCount}
+	}
+
+	for i, frame := range anim.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvasBounds)
+			draw.Copy(previous, canvasBounds.Min, canvas, canvasBounds, draw.Src, nil)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		for j, size := range renditionSizes {
+			resized := fitWithin(canvas, size.MaxSide)
+			// Every output frame covers the whole canvas, so none needs disposal
+			paletted := image.NewPaletted(resized.Bounds(), framePalette(frame.Palette, resized))
+			draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), resized, resized.Bounds().Min)
+			outputs[j].Image = append(outputs[j].Image, paletted)
+			outputs[j].Delay = append(outputs[j].Delay, anim.Delay[i])
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	renditions := make([]Rendition, 0, len(renditionSizes))
+	for i, size := range renditionSizes {
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, outputs[i]); err != nil {
+			return nil, "", fmt.Errorf("failed to encode %s rendition: %w", size.Name, err)
+		}
+		bounds := outputs[i].Image[0].Bounds()
+		renditions = append(renditions, Rendition{
+			Name:   size.Name,
+			Data:   buf.Bytes(),
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		})
+	}
+	return renditions, ".gif", nil
+}
+
+// framePalette returns the palette of a source frame, with a transparent
+// entry added when the rendered frame needs one
+func framePalette(p color.Palette, img image.Image) color.Palette {
+	if isOpaque(img) || len(p) >= 256 {
+		return p
+	}
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return p
+		}
+	}
+	return append(append(color.Palette{}, p...), color.Transparent)
+}
+
+// renditionEncoder picks the output format: GIF stays GIF, images with
+// transparency become PNG and everything else becomes JPEG
+func renditionEncoder(format string, img image.Image) (string, func(*bytes.Buffer, image.Image) error) {
+	switch {
+	case format == "gif":
+		return ".gif", func(buf *bytes.Buffer, m image.Image) error {
+			return gif.Encode(buf, m, &gif.Options{NumColors: 256, Drawer: draw.FloydSteinberg})
+		}
+	case !isOpaque(img):
+		return ".png", func(buf *bytes.Buffer, m image.Image) error {
+			return png.Encode(buf, m)
+		}
+	default:
+		return ".jpg", func(buf *bytes.Buffer, m image.Image) error {
+			return jpeg.Encode(buf, m, &jpeg.Options{Quality: renditionJPEGQuality})
+		}
+	}
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// fitWithin scales img down so its longest side is at most maxSide
+func fitWithin(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxSide && h <= maxSide {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*maxSide/w)
+		w = maxSide
+	} else {
+		w = max(1, w*maxSide/h)
+		h = maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// applyOrientation rotates/flips img so it displays upright for the given
+// EXIF orientation value (1-8)
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 CW
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 CCW
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag from a JPEG's APP1 segment,
+// returning 1 (upright) when it is absent or unreadable
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return 1
+		}
+		marker := data[offset+1]
+		// Start of scan: no more metadata segments follow
+		if marker == 0xDA {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return 1
+		}
+		segment := data[offset+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		offset = end
+	}
+	return 1
+}
+
+// tiffOrientation finds tag 0x0112 in IFD0 of a TIFF-structured EXIF block
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 1
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Default login throttling
+const (
+	DefaultLoginDelayAfter      = 3
+	DefaultLoginDelay           = time.Second
+	DefaultLoginLockoutAfter    = 10
+	DefaultLoginLockoutDuration = 15 * time.Minute
+)
+
+// LoginPolicy slows down and then stops password guessing against an
+// account. Failures older than LockoutDuration are forgotten. The zero
+// LoginPolicy never delays or locks.
+type LoginPolicy struct {
+	// DelayAfter failures may be made freely; after that each failure makes
+	// the account wait Delay, doubling with every further failure
+	DelayAfter int
+	Delay      time.Duration
+	// LockoutAfter failures lock the account for LockoutDuration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+// DefaultLoginPolicy returns the built-in login throttling
+func DefaultLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		DelayAfter:      DefaultLoginDelayAfter,
+		Delay:           DefaultLoginDelay,
+		LockoutAfter:    DefaultLoginLockoutAfter,
+		LockoutDuration: DefaultLoginLockoutDuration,
+	}
+}
+
+// Wait returns how long an account must wait after its failures-th failed
+// login, and whether that wait is a lockout
+func (p LoginPolicy) Wait(failures int) (time.Duration, bool) {
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration, true
+	}
+	if p.Delay <= 0 || failures <= p.DelayAfter {
+		return 0, false
+	}
+	// The shift is capped so the delay cannot overflow
+	wait := p.Delay << min(failures-p.DelayAfter-1, 16)
+	if p.LockoutDuration > 0 {
+		wait = min(wait, p.LockoutDuration)
+	}
+	return wait, false
+}
+
+// dummyPasswordHash is compared against when a login names no account, so
+// the response takes as long as for a wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("instagram-light"), bcrypt.DefaultCost)
+
+// CheckPassword reports whether password matches hash. An empty hash, for an
+// account that does not exist, takes as long and never matches.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strings"
+	"time"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Default upload limits
+const (
+	DefaultMaxUploadSize    int64 = 10 << 20
+	DefaultMaxVideoSize     int64 = 100 << 20
+	DefaultMaxVideoDuration       = 90 * time.Second
+	DefaultMaxImagePixels   int64 = 40_000_000
+)
+
+// animationPixelFactor bounds the frames of an animated GIF, see decodeImage
+const animationPixelFactor = 10
+
+// Defaults for resumable uploads
+const (
+	DefaultUploadChunkSize  int64 = 8 << 20
+	DefaultUploadSessionTTL       = 24 * time.Hour
+)
+
+// Error codes returned to clients when an upload is rejected
+const (
+	MediaErrEmpty       = "empty_file"
+	MediaErrTooLarge    = "file_too_large"
+	MediaErrUnsupported = "unsupported_media_type"
+	MediaErrMalformed   = "malformed_media"
+	MediaErrPolyglot    = "polyglot_file"
+	MediaErrTooLong     = "video_too_long"
+	MediaErrBanned      = "banned_media"
+)
+
+// allowedMediaTypes maps sniffed content types to the extension used on disk
+var allowedMediaTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+}
+
+// Markers of active content that must never appear inside an uploaded media
+// file, in lower case; they are matched in any case
+var embeddedContentMarkers = [][]byte{
+	[]byte("<script"),
+	[]byte("<html"),
+	[]byte("<!doctype html"),
+	[]byte("<?php"),
+	[]byte("<iframe"),
+	[]byte("javascript:"),
+}
+
+// MediaError describes why an uploaded file was rejected
+type MediaError struct {
+	Code    string
+	Message string
+}
+
+func (e *MediaError) Error() string {
+	return e.Message
+}
+
+func mediaError(code, format string, args ...interface{}) *MediaError {
+	return &MediaError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// MediaInfo holds what was learned about an upload while validating it
+type MediaInfo struct {
+	ContentType string
+	Ext         string
+	Size        int64
+	Width       int
+	Height      int
+	// Set for videos only
+	Duration time.Duration
+	Codec    string
+}
+
+// IsImage reports whether the media is a still image
+func (m *MediaInfo) IsImage() bool {
+	return !strings.HasPrefix(m.ContentType, "video/")
+}
+
+// MediaLimits bounds what ValidateMedia accepts
+type MediaLimits struct {
+	MaxImageSize     int64
+	MaxVideoSize     int64
+	MaxVideoDuration time.Duration
+	// Largest width times height of an image or video; zero means
+	// DefaultMaxImagePixels
+	MaxImagePixels int64
+}
+
+// MaxSize returns the largest upload any media type may have
+func (l MediaLimits) MaxSize() int64 {
+	return max(l.MaxImageSize, l.MaxVideoSize)
+}
+
+// DefaultMediaLimits returns the built-in upload limits
+func DefaultMediaLimits() MediaLimits {
+	return MediaLimits{
+		MaxImageSize:     DefaultMaxUploadSize,
+		MaxVideoSize:     DefaultMaxVideoSize,
+		MaxVideoDuration: DefaultMaxVideoDuration,
+		MaxImagePixels:   DefaultMaxImagePixels,
+	}
+}
+
+func (l MediaLimits) maxPixels() int64 {
+	if l.MaxImagePixels <= 0 {
+		return DefaultMaxImagePixels
+	}
+	return l.MaxImagePixels
+}
+
+// ValidateMedia sniffs the content type of data, checks it against the allowlist
+// and limits, fully decodes images, parses video containers and rejects files
+// carrying extra payloads
+func ValidateMedia(data []byte, limits MediaLimits) (*MediaInfo, error) {
+	size := int64(len(data))
+	if size == 0 {
+		return nil, mediaError(MediaErrEmpty, "Uploaded file is empty")
+	}
+	if size > limits.MaxSize() {
+		return nil, mediaError(MediaErrTooLarge, "File exceeds the maximum size of %d bytes", limits.MaxSize())
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedMediaTypes[contentType]
+	if !ok {
+		return nil, mediaError(MediaErrUnsupported, "Unsupported media type %q", contentType)
+	}
+	info := &MediaInfo{ContentType: contentType, Ext: ext, Size: size}
+
+	if err := checkContainerBounds(contentType, data); err != nil {
+		return nil, err
+	}
+	if containsEmbeddedContent(contentType, data) {
+		return nil, mediaError(MediaErrPolyglot, "File contains embedded non-media content")
+	}
+
+	if !info.IsImage() {
+		return validateVideo(info, data, limits)
+	}
+	if size > limits.MaxImageSize {
+		return nil, mediaError(MediaErrTooLarge, "Image exceeds the maximum size of %d bytes", limits.MaxImageSize)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, mediaError(MediaErrMalformed, "Image could not be decoded")
+	}
+	if "image/"+format != contentType {
+		return nil, mediaError(MediaErrMalformed, "Image format %q does not match its content", format)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > limits.maxPixels() {
+		return nil, mediaError(MediaErrMalformed, "Image dimensions %dx%d are not allowed", cfg.Width, cfg.Height)
+	}
+	if err := decodeImage(contentType, data, int64(cfg.Width)*int64(cfg.Height), limits); err != nil {
+		return nil, err
+	}
+	info.Width = cfg.Width
+	info.Height = cfg.Height
+	return info, nil
+}
+
+// decodeImage fully decodes data, every frame of it for GIFs, so corrupt
+// pixel data is caught before anything is stored. An animation may hold
+// animationPixelFactor times the pixels of a still image across its frames.
+func decodeImage(contentType string, data []byte, pixels int64, limits MediaLimits) error {
+	if contentType != "image/gif" {
+		if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+			return mediaError(MediaErrMalformed, "Image could not be decoded")
+		}
+		return nil
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return mediaError(MediaErrMalformed, "Image could not be decoded")
+	}
+	if int64(len(anim.Image))*pixels > animationPixelFactor*limits.maxPixels() {
+		return mediaError(MediaErrMalformed, "Animation has too many frames for its size")
+	}
+	return nil
+}
+
+// validateVideo applies the video limits and fills info from the container
+func validateVideo(info *MediaInfo, data []byte, limits MediaLimits) (*MediaInfo, error) {
+	if info.Size > limits.MaxVideoSize {
+		return nil, mediaError(MediaErrTooLarge, "Video exceeds the maximum size of %d bytes", limits.MaxVideoSize)
+	}
+	video, err := ParseVideo(info.ContentType, data)
+	if err != nil {
+		return nil, err
+	}
+	if video.Duration > limits.MaxVideoDuration {
+		return nil, mediaError(MediaErrTooLong, "Video exceeds the maximum duration of %s", limits.MaxVideoDuration)
+	}
+	if int64(video.Width)*int64(video.Height) > limits.maxPixels() {
+		return nil, mediaError(MediaErrMalformed, "Video dimensions %dx%d are not allowed", video.Width, video.Height)
+	}
+	info.Width, info.Height = video.Width, video.Height
+	info.Duration, info.Codec = video.Duration, video.Codec
+	return info, nil
+}
+
+// checkContainerBounds verifies the file ends exactly where its format says it
+// does, so nothing can be appended behind a valid image or video. JPEGs are the
+// exception: phones append Multi-Picture (MPF) images and Motion Photo videos
+// after the end of the main image, so for them only containsEmbeddedContent
+// guards what follows.
+func checkContainerBounds(contentType string, data []byte) error {
+	trailing := mediaError(MediaErrPolyglot, "File has unexpected data after the end of the %s stream", contentType)
+	switch contentType {
+	case "image/png":
+		iend := []byte{0, 0, 0, 0, 'I', 'E', 'N', 'D', 0xAE, 0x42, 0x60, 0x82}
+		if !bytes.HasSuffix(data, iend) {
+			return trailing
+		}
+	case "image/gif":
+		if data[len(data)-1] != 0x3B {
+			return trailing
+		}
+	case "image/webp":
+		if len(data) < 12 || int64(binary.LittleEndian.Uint32(data[4:8]))+8 != int64(len(data)) {
+			return trailing
+		}
+	case "video/mp4":
+		return checkMP4Boxes(data)
+	case "video/webm":
+		return checkWebMElements(data)
+	}
+	return nil
+}
+
+// checkMP4Boxes walks the top-level ISO BMFF boxes and requires them to cover
+// the file exactly, starting with an ftyp box
+func checkMP4Boxes(data []byte) error {
+	offset := int64(0)
+	total := int64(len(data))
+	for index := 0; offset < total; index++ {
+		if total-offset < 8 {
+			return mediaError(MediaErrMalformed, "Truncated MP4 box header")
+		}
+		size := int64(binary.BigEndian.Uint32(data[offset : offset+4]))
+		boxType := data[offset+4 : offset+8]
+		header := int64(8)
+		switch size {
+		case 0:
+			size = total - offset
+		case 1:
+			if total-offset < 16 {
+				return mediaError(MediaErrMalformed, "Truncated MP4 box header")
+			}
+			size = int64(binary.BigEndian.Uint64(data[offset+8 : offset+16]))
+			header = 16
+		}
+		if size < header || size > total-offset {
+			return mediaError(MediaErrMalformed, "Invalid MP4 box size")
+		}
+		for _, b := range boxType {
+			if b < 0x20 || b > 0x7E {
+				return mediaError(MediaErrPolyglot, "File has unexpected data after the end of the video/mp4 stream")
+			}
+		}
+		if index == 0 && string(boxType) != "ftyp" {
+			return mediaError(MediaErrMalformed, "MP4 file does not start with an ftyp box")
+		}
+		offset += size
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// DefaultBannedHashDistance is how many of the 64 hash bits may differ for an
+// image to still count as a copy of a banned one
+const DefaultBannedHashDistance = 10
+
+// PerceptualHashes decodes an image and returns its pHash and dHash
+func PerceptualHashes(data []byte) (uint64, uint64, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return PHash(img), DHash(img), nil
+}
+
+// HammingDistance counts the bits in which two hashes differ
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// grayscale scales img down to a width x height grayscale image
+func grayscale(img image.Image, width, height int) *image.Gray {
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(gray, gray.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return gray
+}
+
+// DHash is a difference hash: each bit says whether a pixel of a 9x8
+// grayscale thumbnail is darker than its right neighbour. It survives
+// re-encoding and resizing.
+func DHash(img image.Image) uint64 {
+	gray := grayscale(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y < gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// PHash is a DCT hash: each bit says whether one of the 64 lowest frequencies
+// of a 32x32 grayscale thumbnail is above their median. It also survives
+// small crops, brightness and contrast changes.
+func PHash(img image.Image) uint64 {
+	const size = 32
+	gray := grayscale(img, size, size)
+	pixels := make([][]float64, size)
+	for y := range pixels {
+		pixels[y] = make([]float64, size)
+		for x := range pixels[y] {
+			pixels[y][x] = float64(gray.GrayAt(x, y).Y)
+		}
+	}
+
+	// Separable 2D DCT-II: rows first, then the columns of the 8 lowest rows
+	rows := make([][]float64, size)
+	for y := range rows {
+		rows[y] = dct(pixels[y], 8)
+	}
+	low := make([]float64, 0, 64)
+	column := make([]float64, size)
+	coeffs := make([][]float64, 8)
+	for x := 0; x < 8; x++ {
+		for y := 0; y < size; y++ {
+			column[y] = rows[y][x]
+		}
+		coeffs[x] = dct(column, 8)
+	}
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			low = append(low, coeffs[u][v])
+		}
+	}
+
+	// The DC term only reflects overall brightness, so it is left out of the median
+	sorted := append([]float64(nil), low[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for _, coeff := range low {
+		hash <<= 1
+		if coeff > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// dct returns the first n DCT-II coefficients of values
+func dct(values []float64, n int) []float64 {
+	size := float64(len(values))
+	out := make([]float64, n)
+	for k := range out {
+		var sum float64
+		for i, value := range values {
+			sum += value * math.Cos(math.Pi/size*(float64(i)+0.5)*float64(k))
+		}
+		out[k] = sum
+	}
+	return out
+}
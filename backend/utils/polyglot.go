@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// embeddedMarkerStarts holds the first byte of every embeddedContentMarkers
+// entry in both cases
+const embeddedMarkerStarts = "<jJ"
+
+// Decoders skip whatever follows the last coded block of a JPEG scan, so a
+// payload hidden inside a scan sits within this many bytes of the next marker
+const jpegScanSlack = 64 << 10
+
+// zipTailWindow is how far from the end of a file a ZIP end-of-central-directory
+// record is looked for
+const zipTailWindow = 64 << 10
+
+// containsEmbeddedContent looks for markup or script markers where a polyglot
+// payload can hide in a file of the given type: metadata, the slack at the end
+// of compressed streams and anything after the format's end marker. Compressed
+// image and video data is not scanned.
+func containsEmbeddedContent(contentType string, data []byte) bool {
+	for _, region := range embeddableRegions(contentType, data) {
+		if hasEmbeddedMarker(region) {
+			return true
+		}
+	}
+	// ZIP end-of-central-directory record near the end of the file (e.g. JPEG+JAR)
+	tail := data[max(0, len(data)-zipTailWindow):]
+	return bytes.Contains(tail, []byte("PK\x05\x06"))
+}
+
+// hasEmbeddedMarker reports whether region holds one of embeddedContentMarkers
+// in any letter case, without copying it
+func hasEmbeddedMarker(region []byte) bool {
+	for i := 0; i < len(region); i++ {
+		next := bytes.IndexAny(region[i:], embeddedMarkerStarts)
+		if next < 0 {
+			return false
+		}
+		i += next
+		for _, marker := range embeddedContentMarkers {
+			if len(region)-i >= len(marker) && bytes.EqualFold(region[i:i+len(marker)], marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// embeddableRegions returns the parts of data that are not compressed image
+// or video data. Once a structure stops making sense the rest of the file is
+// returned, so damaged files are scanned in full.
+func embeddableRegions(contentType string, data []byte) [][]byte {
+	switch contentType {
+	case "image/jpeg":
+		return jpegRegions(data)
+	case "image/png":
+		return pngRegions(data)
+	case "image/gif":
+		return gifRegions(data)
+	case "image/webp":
+		return webpRegions(data)
+	case "video/mp4":
+		return mp4Regions(data)
+	case "video/webm":
+		return webmRegions(data)
+	}
+	return [][]byte{data}
+}
+
+// jpegRegions returns the APPn and COM segments, the end of every scan and
+// everything after the EOI marker
+func jpegRegions(data []byte) [][]byte {
+	var regions [][]byte
+	offset := 2 // SOI
+	for offset+2 <= len(data) {
+		if data[offset] != 0xFF {
+			break
+		}
+		marker := data[offset+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker
+			offset++
+			continue
+		case marker == 0xD9:
+			return append(regions, data[offset+2:])
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+			offset += 2
+			continue
+		}
+		if offset+4 > len(data) {
+			break
+		}
+		end := offset + 2 + int(binary.BigEndian.Uint16(data[offset+2:]))
+		if end < offset+4 || end > len(data) {
+			break
+		}
+		if marker >= 0xE0 && marker <= 0xEF || marker == 0xFE {
+			regions = append(regions, data[offset+4:end])
+		}
+		offset = end
+		if marker == 0xDA {
+			scanEnd := jpegScanEnd(data, offset)
+			regions = append(regions, data[max(offset, scanEnd-jpegScanSlack):scanEnd])
+			offset = scanEnd
+		}
+	}
+	return append(regions, data[min(offset, len(data)):])
+}
+
+// jpegScanEnd returns the offset of the first marker after the entropy-coded
+// data starting at start, skipping stuffed bytes and restart markers
+func jpegScanEnd(data []byte, start int) int {
+	for i := start; ; i++ {
+		next := bytes.IndexByte(data[i:], 0xFF)
+		if next < 0 || i+next+1 >= len(data) {
+			return len(data)
+		}
+		i += next
+		if b := data[i+1]; b != 0x00 && b != 0xFF && (b < 0xD0 || b > 0xD7) {
+			return i
+		}
+	}
+}
+
+// pngRegions returns every chunk but the image data and whatever follows IEND
+func pngRegions(data []byte) [][]byte {
+	var regions [][]byte
+	offset := 8 // signature
+	for offset+12 <= len(data) {
+		length := int64(binary.BigEndian.Uint32(data[offset:]))
+		chunkType := string(data[offset+4 : offset+8])
+		if length > int64(len(data)-offset-12) {
+			break
+		}
+		end := offset + 12 + int(length)
+		if chunkType != "IDAT" && chunkType != "fdAT" {
+			regions = append(regions, data[offset+8:end-4])
+		}
+		offset = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return append(regions, data[min(offset, len(data)):])
+}
+
+// gifRegions returns the extension blocks (comments, application data, plain
+// text) and whatever follows the trailer, skipping the LZW image data
+func gifRegions(data []byte) [][]byte {
+	var regions [][]byte
+	offset := 13 // header and logical screen descriptor
+	if len(data) < offset {
+		return [][]byte{data}
+	}
+	if packed := data[10]; packed&0x80 != 0 {
+		offset += 3 << ((packed & 0x07) + 1)
+	}
+	for offset < len(data) {
+		switch data[offset] {
+		case 0x21:
+			end := gifSubBlocksEnd(data, offset+2)
+			if end < 0 {
+				return append(regions, data[offset:])
+			}
+			regions = append(regions, data[offset+2:end])
+			offset = end
+		case 0x2C:
+			// Separator and descriptor, optional local color table, LZW code size
+			if offset+10 > len(data) {
+				return append(regions, data[offset:])
+			}
+			next := offset + 10
+			if packed := data[offset+9]; packed&0x80 != 0 {
+				next += 3 << ((packed & 0x07) + 1)
+			}
+			end := gifSubBlocksEnd(data, next+1)
+			if end < 0 {
+				return append(regions, data[offset:])
+			}
+			offset = end
+		case 0x3B:
+			return append(regions, data[offset+1:])
+		default:
+			return append(regions, data[offset:])
+		}
+	}
+	return regions
+}
+
+// gifSubBlocksEnd returns the offset just past the block terminator of the
+// data sub-blocks starting at offset, or -1 when they run past the file
+func gifSubBlocksEnd(data []byte, offset int) int {
+	for offset < len(data) {
+		size := int(data[offset])
+		offset += 1 + size
+		if size == 0 {
+			return offset
+		}
+	}
+	return -1
+}
+
+// webpRegions returns every RIFF chunk but the image data
+func webpRegions(data []byte) [][]byte {
+	var regions [][]byte
+	offset := 12 // RIFF header
+	for offset+8 <= len(data) {
+		size := int64(binary.LittleEndian.Uint32(data[offset+4:]))
+		chunkType := string(data[offset : offset+4])
+		if size > int64(len(data)-offset-8) {
+			break
+		}
+		end := offset + 8 + int(size)
+		switch chunkType {
+		case "VP8 ", "VP8L", "ALPH", "ANMF":
+		default:
+			regions = append(regions, data[offset+8:end])
+		}
+		offset = end + int(size&1)
+	}
+	return append(regions, data[min(offset, len(data)):])
+}
+
+// mp4Regions returns every top-level box but the media data
+func mp4Regions(data []byte) [][]byte {
+	boxes, err := mp4Boxes(data)
+	if err != nil {
+		return [][]byte{data}
+	}
+	var regions [][]byte
+	for boxType, payloads := range boxes {
+		if boxType != "mdat" {
+			regions = append(regions, payloads...)
+		}
+	}
+	return regions
+}
+
+// webmRegions returns every element but the clusters holding the media data
+func webmRegions(data []byte) [][]byte {
+	elements, err := ebmlElements(data)
+	if err != nil {
+		return [][]byte{data}
+	}
+	var regions [][]byte
+	for _, element := range elements {
+		if element.ID != webmSegmentID {
+			regions = append(regions, element.Data)
+			continue
+		}
+		children, err := ebmlElements(element.Data)
+		if err != nil {
+			regions = append(regions, element.Data)
+			continue
+		}
+		for _, child := range children {
+			if child.ID != webmClusterID {
+				regions = append(regions, child.Data)
+			}
+		}
+	}
+	return regions
+}
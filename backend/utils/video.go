@@ -0,0 +1,345 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// VideoInfo holds the metadata read from a video container
+type VideoInfo struct {
+	Duration time.Duration
+	Width    int
+	Height   int
+	// Codec of the video track: an MP4 sample entry type ("avc1") or a WebM codec ID ("V_VP9")
+	Codec string
+}
+
+// Video codecs browsers can play, by container
+var allowedVideoCodecs = map[string]map[string]bool{
+	"video/mp4":  {"avc1": true, "avc3": true, "hvc1": true, "hev1": true, "av01": true, "vp09": true},
+	"video/webm": {"V_VP8": true, "V_VP9": true, "V_AV1": true},
+}
+
+// ParseVideo extracts duration, dimensions and codec from an MP4 or WebM file
+func ParseVideo(contentType string, data []byte) (*VideoInfo, error) {
+	var (
+		info *VideoInfo
+		err  error
+	)
+	switch contentType {
+	case "video/mp4":
+		info, err = parseMP4(data)
+	case "video/webm":
+		info, err = parseWebM(data)
+	default:
+		return nil, mediaError(MediaErrUnsupported, "Unsupported video type %q", contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Width <= 0 || info.Height <= 0 {
+		return nil, mediaError(MediaErrMalformed, "Video has no dimensions")
+	}
+	if !allowedVideoCodecs[contentType][info.Codec] {
+		return nil, mediaError(MediaErrUnsupported, "Unsupported video codec %q", info.Codec)
+	}
+	return info, nil
+}
+
+// mp4Boxes splits data into ISO BMFF boxes, returning each box's type and payload
+func mp4Boxes(data []byte) (map[string][][]byte, error) {
+	boxes := map[string][][]byte{}
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 8 {
+			return nil, mediaError(MediaErrMalformed, "Truncated MP4 box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data) - offset)
+		case 1:
+			if len(data)-offset < 16 {
+				return nil, mediaError(MediaErrMalformed, "Truncated MP4 box header")
+			}
+			size = binary.BigEndian.Uint64(data[offset+8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)-offset) {
+			return nil, mediaError(MediaErrMalformed, "Invalid MP4 box size")
+		}
+		boxes[boxType] = append(boxes[boxType], data[offset+int(header):offset+int(size)])
+		offset += int(size)
+	}
+	return boxes, nil
+}
+
+// mp4Child returns the payload of the first box of the given type inside parent
+func mp4Child(parent []byte, boxType string) ([]byte, bool) {
+	boxes, err := mp4Boxes(parent)
+	if err != nil || len(boxes[boxType]) == 0 {
+		return nil, false
+	}
+	return boxes[boxType][0], true
+}
+
+// parseMP4 reads the movie header and the first video track of an MP4 file
+func parseMP4(data []byte) (*VideoInfo, error) {
+	top, err := mp4Boxes(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(top["moov"]) == 0 {
+		return nil, mediaError(MediaErrMalformed, "MP4 file has no moov box")
+	}
+	moov := top["moov"][0]
+	mvhd, ok := mp4Child(moov, "mvhd")
+	if !ok {
+		return nil, mediaError(MediaErrMalformed, "MP4 file has no movie header")
+	}
+	duration, err := mp4Duration(mvhd)
+	if err != nil {
+		return nil, err
+	}
+
+	moovBoxes, _ := mp4Boxes(moov)
+	for _, trak := range moovBoxes["trak"] {
+		mdia, ok := mp4Child(trak, "mdia")
+		if !ok {
+			continue
+		}
+		// hdlr: version/flags(4) pre_defined(4) handler_type(4)
+		hdlr, ok := mp4Child(mdia, "hdlr")
+		if !ok || len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+			continue
+		}
+		info := &VideoInfo{Duration: duration}
+		if tkhd, ok := mp4Child(trak, "tkhd"); ok && len(tkhd) >= 8 {
+			// Width and height are the last two 16.16 fixed-point fields
+			info.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+			info.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+		}
+		// stsd: version/flags(4) entry_count(4), then entries of size(4) type(4)
+		minf, _ := mp4Child(mdia, "minf")
+		stbl, _ := mp4Child(minf, "stbl")
+		if stsd, ok := mp4Child(stbl, "stsd"); ok && len(stsd) >= 16 {
+			info.Codec = string(stsd[12:16])
+		}
+		return info, nil
+	}
+	return nil, mediaError(MediaErrMalformed, "MP4 file has no video track")
+}
+
+// mp4Duration reads the duration from an mvhd payload
+func mp4Duration(mvhd []byte) (time.Duration, error) {
+	var timescale, duration uint64
+	switch {
+	case len(mvhd) >= 20 && mvhd[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+	case len(mvhd) >= 32 && mvhd[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:]))
+		duration = binary.BigEndian.Uint64(mvhd[24:])
+	default:
+		return 0, mediaError(MediaErrMalformed, "Invalid MP4 movie header")
+	}
+	if timescale == 0 {
+		return 0, mediaError(MediaErrMalformed, "Invalid MP4 timescale")
+	}
+	seconds := float64(duration) / float64(timescale)
+	if seconds > math.MaxInt64/float64(time.Second) {
+		return 0, mediaError(MediaErrMalformed, "Invalid MP4 duration")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// EBML element IDs used by WebM, with their length marker bits kept
+const (
+	ebmlHeaderID    = 0x1A45DFA3
+	ebmlDocTypeID   = 0x4282
+	ebmlVoidID      = 0xEC
+	webmSegmentID   = 0x18538067
+	webmInfoID      = 0x1549A966
+	webmClusterID   = 0x1F43B675
+	webmTimescaleID = 0x2AD7B1
+	webmDurationID  = 0x4489
+	webmTracksID    = 0x1654AE6B
+	webmTrackID     = 0xAE
+	webmTrackTypeID = 0x83
+	webmCodecID     = 0x86
+	webmVideoID     = 0xE0
+	webmWidthID     = 0xB0
+	webmHeightID    = 0xBA
+)
+
+// ebmlElement is one element of an EBML stream
+type ebmlElement struct {
+	ID   uint32
+	Data []byte
+}
+
+// ebmlVint reads a variable-length integer, returning its value, length and
+// whether it is the reserved "unknown size" value. keepMarker keeps the length
+// marker bit, as element IDs do.
+func ebmlVint(data []byte, keepMarker bool) (uint64, int, bool, bool) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false, false
+	}
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || len(data) < length {
+		return 0, 0, false, false
+	}
+	first := data[0]
+	if !keepMarker {
+		first &^= 0x80 >> (length - 1)
+	}
+	value := uint64(first)
+	allOnes := first == 0xFF>>length
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	return value, length, allOnes && !keepMarker, true
+}
+
+// ebmlElements splits data into elements. An element of unknown size runs to
+// the end of data, as WebM allows for live-recorded segments.
+func ebmlElements(data []byte) ([]ebmlElement, error) {
+	var elements []ebmlElement
+	for offset := 0; offset < len(data); {
+		id, idLen, _, ok := ebmlVint(data[offset:], true)
+		if !ok || idLen > 4 {
+			return nil, mediaError(MediaErrMalformed, "Invalid WebM element ID")
+		}
+		size, sizeLen, unknown, ok := ebmlVint(data[offset+idLen:], false)
+		if !ok {
+			return nil, mediaError(MediaErrMalformed, "Invalid WebM element size")
+		}
+		start := offset + idLen + sizeLen
+		if unknown {
+			size = uint64(len(data) - start)
+		}
+		if size > uint64(len(data)-start) {
+			return nil, mediaError(MediaErrMalformed, "WebM element exceeds the file")
+		}
+		elements = append(elements, ebmlElement{ID: uint32(id), Data: data[start : start+int(size)]})
+		offset = start + int(size)
+	}
+	return elements, nil
+}
+
+// ebmlChild returns the first child element with the given ID
+func ebmlChild(parent []byte, id uint32) ([]byte, bool) {
+	elements, err := ebmlElements(parent)
+	if err != nil {
+		return nil, false
+	}
+	for _, element := range elements {
+		if element.ID == id {
+			return element.Data, true
+		}
+	}
+	return nil, false
+}
+
+func ebmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+// checkWebMElements requires the file to be an EBML header followed by
+// segments, with nothing else at the top level
+func checkWebMElements(data []byte) error {
+	elements, err := ebmlElements(data)
+	if err != nil {
+		return err
+	}
+	if len(elements) < 2 || elements[0].ID != ebmlHeaderID {
+		return mediaError(MediaErrMalformed, "WebM file does not start with an EBML header")
+	}
+	if docType, _ := ebmlChild(elements[0].Data, ebmlDocTypeID); string(docType) != "webm" {
+		return mediaError(MediaErrUnsupported, "EBML document type %q is not WebM", docType)
+	}
+	for _, element := range elements[1:] {
+		if element.ID != webmSegmentID && element.ID != ebmlVoidID {
+			return mediaError(MediaErrPolyglot, "File has unexpected data after the end of the video/webm stream")
+		}
+	}
+	return nil
+}
+
+// parseWebM reads the segment info and the first video track of a WebM file
+func parseWebM(data []byte) (*VideoInfo, error) {
+	elements, err := ebmlElements(data)
+	if err != nil {
+		return nil, err
+	}
+	var segment []byte
+	for _, element := range elements {
+		if element.ID == webmSegmentID {
+			segment = element.Data
+			break
+		}
+	}
+	infoElement, ok := ebmlChild(segment, webmInfoID)
+	if !ok {
+		return nil, mediaError(MediaErrMalformed, "WebM file has no segment info")
+	}
+	timescale := uint64(1_000_000)
+	if raw, ok := ebmlChild(infoElement, webmTimescaleID); ok {
+		timescale = ebmlUint(raw)
+	}
+	raw, _ := ebmlChild(infoElement, webmDurationID)
+	ticks := ebmlFloat(raw)
+	if ticks < 0 || math.IsNaN(ticks) || ticks*float64(timescale) > math.MaxInt64 {
+		return nil, mediaError(MediaErrMalformed, "Invalid WebM duration")
+	}
+
+	tracks, ok := ebmlChild(segment, webmTracksID)
+	if !ok {
+		return nil, mediaError(MediaErrMalformed, "WebM file has no tracks")
+	}
+	trackElements, err := ebmlElements(tracks)
+	if err != nil {
+		return nil, err
+	}
+	for _, track := range trackElements {
+		if track.ID != webmTrackID {
+			continue
+		}
+		// Track type 1 is video
+		if trackType, _ := ebmlChild(track.Data, webmTrackTypeID); ebmlUint(trackType) != 1 {
+			continue
+		}
+		codec, _ := ebmlChild(track.Data, webmCodecID)
+		video, _ := ebmlChild(track.Data, webmVideoID)
+		width, _ := ebmlChild(video, webmWidthID)
+		height, _ := ebmlChild(video, webmHeightID)
+		return &VideoInfo{
+			Duration: time.Duration(ticks * float64(timescale)),
+			Width:    int(ebmlUint(width)),
+			Height:   int(ebmlUint(height)),
+			Codec:    string(bytes.TrimRight(codec, "\x00")),
+		}, nil
+	}
+	return nil, mediaError(MediaErrMalformed, "WebM file has no video track")
+}
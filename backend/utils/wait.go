@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// WaitContext waits for wg until ctx is done, returning ctx's error if it
+// gave up
+func WaitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
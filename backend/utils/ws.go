@@ -2,50 +2,210 @@ package utils
 
 import (
 	"sync"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
+	"github.com/umutdeveloper/instagram-light/backend/metrics"
 )
 
-// Global WebSocket manager instance
-var WSManagerInstance = NewWSManager()
+// How long closing a connection may spend telling the client why
+const wsCloseWriteTimeout = time.Second
+
+// wsClient serializes writes to a connection, which allows only one writer
+type wsClient struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *wsClient) writeJSON(message interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(message)
+}
+
+// send writes message and counts whether it was delivered
+func (c *wsClient) send(message interface{}) error {
+	if err := c.writeJSON(message); err != nil {
+		metrics.WSMessagesDropped.WithLabelValues(metrics.DropWriteError).Inc()
+		return err
+	}
+	metrics.WSMessagesSent.Inc()
+	return nil
+}
+
+// close sends a close frame with code and reason, then closes the connection
+func (c *wsClient) close(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline := time.Now().Add(wsCloseWriteTimeout)
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	_ = c.conn.Close()
+}
 
 type WSManager struct {
-	mu          sync.RWMutex
-	connections map[string]*websocket.Conn // userID -> connection
+	mu sync.RWMutex
+	// A user can be connected from several tabs or devices at once
+	connections map[string]map[*websocket.Conn]*wsClient // userID -> connections
+	postViewers map[uint]map[string]bool                 // postID -> userIDs viewing it
+	// Set by CloseAll; no connections are accepted afterwards
+	closing bool
 }
 
 func NewWSManager() *WSManager {
 	return &WSManager{
-		connections: make(map[string]*websocket.Conn),
+		connections: make(map[string]map[*websocket.Conn]*wsClient),
+		postViewers: make(map[uint]map[string]bool),
 	}
 }
 
-func (m *WSManager) AddConnection(userID string, conn *websocket.Conn) {
+// AddConnection registers a user's connection. It returns false, closing the
+// connection, once the manager is shutting down.
+func (m *WSManager) AddConnection(userID string, conn *websocket.Conn) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.connections[userID] = conn
+	if m.closing {
+		(&wsClient{conn: conn}).close(websocket.CloseGoingAway, "Server is shutting down")
+		return false
+	}
+	if m.connections[userID] == nil {
+		m.connections[userID] = make(map[*websocket.Conn]*wsClient)
+	}
+	m.connections[userID][conn] = &wsClient{conn: conn}
+	metrics.WSConnections.Inc()
+	return true
 }
 
-func (m *WSManager) RemoveConnection(userID string) {
+// RemoveConnection forgets one of a user's connections. The posts the user
+// has open are forgotten with their last connection.
+func (m *WSManager) RemoveConnection(userID string, conn *websocket.Conn) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if _, ok := m.connections[userID][conn]; !ok {
+		return
+	}
+	delete(m.connections[userID], conn)
+	metrics.WSConnections.Dec()
+	if len(m.connections[userID]) > 0 {
+		return
+	}
 	delete(m.connections, userID)
+	for postID, viewers := range m.postViewers {
+		delete(viewers, userID)
+		if len(viewers) == 0 {
+			delete(m.postViewers, postID)
+		}
+	}
 }
 
-func (m *WSManager) SendToUser(userID string, message interface{}) error {
+// CloseAll tells every client the server is going away, closes their
+// connections and refuses new ones
+func (m *WSManager) CloseAll() {
+	m.mu.Lock()
+	m.closing = true
+	var clients []*wsClient
+	for _, conns := range m.connections {
+		for _, client := range conns {
+			clients = append(clients, client)
+		}
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *wsClient) {
+			defer wg.Done()
+			client.close(websocket.CloseGoingAway, "Server is shutting down")
+		}(client)
+	}
+	wg.Wait()
+}
+
+// WSStats describes the connections a WSManager holds
+type WSStats struct {
+	Connections int  `json:"connections"`
+	Users       int  `json:"users"`
+	Closing     bool `json:"closing"`
+}
+
+// Stats returns how many connections and users are connected
+func (m *WSManager) Stats() WSStats {
 	m.mu.RLock()
-	conn, ok := m.connections[userID]
-	m.mu.RUnlock()
-	if !ok {
-		return nil // No connection for user
+	defer m.mu.RUnlock()
+	stats := WSStats{Users: len(m.connections), Closing: m.closing}
+	for _, conns := range m.connections {
+		stats.Connections += len(conns)
 	}
-	return conn.WriteJSON(message)
+	return stats
+}
+
+// ViewPost records that userID has a post open
+func (m *WSManager) ViewPost(userID string, postID uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.postViewers[postID] == nil {
+		m.postViewers[postID] = make(map[string]bool)
+	}
+	m.postViewers[postID][userID] = true
+}
+
+// LeavePost records that userID closed a post
+func (m *WSManager) LeavePost(userID string, postID uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.postViewers[postID], userID)
+	if len(m.postViewers[postID]) == 0 {
+		delete(m.postViewers, postID)
+	}
+}
+
+// PostViewers returns the users who have a post open
+func (m *WSManager) PostViewers(postID uint) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	viewers := make([]string, 0, len(m.postViewers[postID]))
+	for userID := range m.postViewers[postID] {
+		viewers = append(viewers, userID)
+	}
+	return viewers
+}
+
+// SendToUser writes message to every connection of a user, returning the
+// last error
+func (m *WSManager) SendToUser(userID string, message interface{}) error {
+	clients := m.clients(userID)
+	if len(clients) == 0 {
+		metrics.WSMessagesDropped.WithLabelValues(metrics.DropOffline).Inc()
+		return nil
+	}
+	var err error
+	for _, client := range clients {
+		if writeErr := client.send(message); writeErr != nil {
+			err = writeErr
+		}
+	}
+	return err
 }
 
 func (m *WSManager) Broadcast(message interface{}) {
+	for _, client := range m.clients("") {
+		client.send(message)
+	}
+}
+
+// clients returns the connections of userID, or of everyone for "", so they
+// can be written to without holding the lock
+func (m *WSManager) clients(userID string) []*wsClient {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	for _, conn := range m.connections {
-		conn.WriteJSON(message)
+	var clients []*wsClient
+	for id, conns := range m.connections {
+		if userID != "" && id != userID {
+			continue
+		}
+		for _, client := range conns {
+			clients = append(clients, client)
+		}
 	}
+	return clients
 }